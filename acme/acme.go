@@ -0,0 +1,450 @@
+package acme
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/teamhephy/router/model"
+	"github.com/teamhephy/router/utils"
+	acmeclient "golang.org/x/crypto/acme"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	accountSecretName       = "deis-router-acme-account"
+	challengesConfigMapName = "deis-router-acme-challenges"
+	// Annotations on the challenges config map recording which replica obtains certificates, and
+	// when it last renewed its lease on doing so.
+	holderAnnotation  = "router.deis.io/acmeHolder"
+	renewedAnnotation = "router.deis.io/acmeRenewedAt"
+	// How long to wait for the certificate authority to issue a single certificate.
+	issueTimeout = 5 * time.Minute
+	// How often to check for certificates in need of renewal when the configuration doesn't change.
+	checkInterval = time.Hour
+	// How long to wait before trying to obtain a certificate for a domain again after a failure.
+	retryInterval = time.Hour
+	// How long a replica's lease on obtaining certificates lasts unless renewed, which it is before
+	// obtaining each certificate.
+	leaseDuration = 2 * issueTimeout
+	// How long to give all replicas to pick up the response to a challenge before the certificate
+	// authority is asked to validate it. Replicas rebuild their configuration every ten seconds.
+	challengePropagation = 30 * time.Second
+)
+
+var namespace = utils.GetOpt("POD_NAMESPACE", "default")
+
+// Manager obtains and renews certificates for the domains of apps that opted in to ACME
+// certificate issuance. Certificates are stored as cert-bearing secrets in the apps' namespaces,
+// from which the model picks them up like any other certificate. Only the replica holding the
+// lease recorded on the challenges config map obtains certificates, and it publishes responses to
+// HTTP-01 challenges in that config map, so that whichever replica the certificate authority
+// reaches can answer them.
+type Manager struct {
+	kubeClient   *kubernetes.Clientset
+	identity     string
+	configs      chan *model.RouterConfig
+	client       *acmeclient.Client
+	clientConfig model.ACMEConfig
+	failures     map[string]time.Time
+}
+
+// NewManager returns a Manager that identifies its replica by its hostname, which is the name of
+// its pod.
+func NewManager(kubeClient *kubernetes.Clientset) *Manager {
+	identity, err := os.Hostname()
+	if err != nil {
+		log.Printf("WARN: Failed to determine the hostname identifying this replica: %v\n", err)
+	}
+	return &Manager{
+		kubeClient: kubeClient,
+		identity:   identity,
+		configs:    make(chan *model.RouterConfig, 1),
+		failures:   make(map[string]time.Time),
+	}
+}
+
+// Update hands the Manager the latest router configuration. It never blocks; if the Manager is
+// busy, any configuration it has not picked up yet is replaced.
+func (m *Manager) Update(routerConfig *model.RouterConfig) {
+	for {
+		select {
+		case m.configs <- routerConfig:
+			return
+		default:
+		}
+		select {
+		case <-m.configs:
+		default:
+		}
+	}
+}
+
+// Run obtains and renews certificates for the latest router configuration whenever it changes,
+// and periodically otherwise. It never returns.
+func (m *Manager) Run() {
+	var routerConfig *model.RouterConfig
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case routerConfig = <-m.configs:
+		case <-ticker.C:
+		}
+		if routerConfig != nil {
+			m.sync(routerConfig)
+		}
+	}
+}
+
+func (m *Manager) sync(routerConfig *model.RouterConfig) {
+	for _, appConfig := range routerConfig.AppConfigs {
+		acmeConfig := appConfig.ACMEConfig
+		for _, domain := range acmeConfig.Domains {
+			if !needsRenewal(appConfig.Certificates[domain], routerConfig.ACMEConfig.RenewBefore, time.Now()) {
+				continue
+			}
+			if failedAt, ok := m.failures[domain]; ok && time.Since(failedAt) < retryInterval {
+				continue
+			}
+			// Replicas would otherwise compete for the same certificates and secrets.
+			if !m.lead(time.Now()) {
+				return
+			}
+			err := m.obtain(routerConfig.ACMEConfig, acmeConfig.Namespace, domain)
+			if err != nil {
+				log.Printf("WARN: Failed to obtain a certificate for %s: %v\n", domain, err)
+				m.failures[domain] = time.Now()
+				continue
+			}
+			delete(m.failures, domain)
+			log.Printf("INFO: Obtained a certificate for %s.\n", domain)
+		}
+	}
+}
+
+func (m *Manager) obtain(acmeConfig *model.ACMEConfig, ns string, domain string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), issueTimeout)
+	defer cancel()
+	client, err := m.getClient(ctx, acmeConfig)
+	if err != nil {
+		return err
+	}
+	order, err := client.AuthorizeOrder(ctx, acmeclient.DomainIDs(domain))
+	if err != nil {
+		return err
+	}
+	for _, authzURL := range order.AuthzURLs {
+		err = m.authorize(ctx, client, authzURL)
+		if err != nil {
+			return err
+		}
+	}
+	order, err = client.WaitOrder(ctx, order.URI)
+	if err != nil {
+		return err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{DNSNames: []string{domain}}, key)
+	if err != nil {
+		return err
+	}
+	der, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return err
+	}
+	keyPEM, err := encodeKey(key)
+	if err != nil {
+		return err
+	}
+	return m.saveSecret(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s-cert", model.ACMECertName(domain)),
+			Namespace: ns,
+			Labels: map[string]string{
+				"heritage": "deis",
+			},
+		},
+		Type: corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			"tls.crt": encodeCertificates(der),
+			"tls.key": keyPEM,
+		},
+	})
+}
+
+// authorize fulfills the HTTP-01 challenge of a pending authorization and waits for the
+// certificate authority to validate it.
+func (m *Manager) authorize(ctx context.Context, client *acmeclient.Client, authzURL string) error {
+	authz, err := client.GetAuthorization(ctx, authzURL)
+	if err != nil {
+		return err
+	}
+	if authz.Status == acmeclient.StatusValid {
+		return nil
+	}
+	var challenge *acmeclient.Challenge
+	for _, c := range authz.Challenges {
+		if c.Type == "http-01" {
+			challenge = c
+			break
+		}
+	}
+	if challenge == nil {
+		return fmt.Errorf("no http-01 challenge offered for %s", authz.Identifier.Value)
+	}
+	response, err := client.HTTP01ChallengeResponse(challenge.Token)
+	if err != nil {
+		return err
+	}
+	err = m.setChallengeResponse(challenge.Token, response)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := m.setChallengeResponse(challenge.Token, ""); err != nil {
+			log.Printf("WARN: Failed to withdraw the response to the challenge %s: %v\n", challenge.Token, err)
+		}
+	}()
+	select {
+	case <-time.After(challengePropagation):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	_, err = client.Accept(ctx, challenge)
+	if err != nil {
+		return err
+	}
+	_, err = client.WaitAuthorization(ctx, authz.URI)
+	return err
+}
+
+// lead takes or renews this replica's lease on obtaining certificates, returning whether it holds
+// the lease. Replicas coordinate through the challenges config map's resource version, so only
+// one of them takes an expired lease.
+func (m *Manager) lead(now time.Time) bool {
+	configMapClient := m.kubeClient.CoreV1().ConfigMaps(namespace)
+	configMap, err := configMapClient.Get(challengesConfigMapName, metav1.GetOptions{})
+	if err != nil {
+		statusErr, ok := err.(*errors.StatusError)
+		if !ok || statusErr.Status().Code != 404 {
+			log.Printf("WARN: Failed to look up the k8s config map %s: %v\n", challengesConfigMapName, err)
+			return false
+		}
+		configMap = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      challengesConfigMapName,
+				Namespace: namespace,
+				Labels: map[string]string{
+					"heritage": "deis",
+				},
+			},
+		}
+	}
+	if !acquire(configMap, m.identity, now) {
+		return false
+	}
+	if configMap.ResourceVersion == "" {
+		_, err = configMapClient.Create(configMap)
+	} else {
+		_, err = configMapClient.Update(configMap)
+	}
+	if err != nil {
+		// Another replica may have been quicker; it obtains certificates instead.
+		if statusErr, ok := err.(*errors.StatusError); !ok || statusErr.Status().Code != 409 {
+			log.Printf("WARN: Failed to take the lease on obtaining certificates: %v\n", err)
+		}
+		return false
+	}
+	return true
+}
+
+// setChallengeResponse publishes the response to the challenge with the given token for all
+// replicas to serve or, if the response is empty, withdraws it.
+func (m *Manager) setChallengeResponse(token string, response string) error {
+	configMapClient := m.kubeClient.CoreV1().ConfigMaps(namespace)
+	for attempt := 1; ; attempt++ {
+		configMap, err := configMapClient.Get(challengesConfigMapName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if response != "" && configMap.Annotations[holderAnnotation] != m.identity {
+			return fmt.Errorf("another replica took over obtaining certificates")
+		}
+		if configMap.Data == nil {
+			configMap.Data = make(map[string]string)
+		}
+		if response == "" {
+			delete(configMap.Data, token)
+		} else {
+			configMap.Data[token] = response
+		}
+		_, err = configMapClient.Update(configMap)
+		// Another replica may have tried to take the lease in the meantime.
+		if statusErr, ok := err.(*errors.StatusError); ok && statusErr.Status().Code == 409 && attempt < 3 {
+			continue
+		}
+		return err
+	}
+}
+
+// acquire records the replica as the holder of the lease in the config map's annotations, unless
+// another replica holds a lease that hasn't expired yet. It returns whether it did so.
+func acquire(configMap *corev1.ConfigMap, identity string, now time.Time) bool {
+	holder := configMap.Annotations[holderAnnotation]
+	if holder != "" && holder != identity {
+		renewedAt, err := time.Parse(time.RFC3339, configMap.Annotations[renewedAnnotation])
+		if err == nil && now.Sub(renewedAt) < leaseDuration {
+			return false
+		}
+	}
+	if configMap.Annotations == nil {
+		configMap.Annotations = make(map[string]string)
+	}
+	configMap.Annotations[holderAnnotation] = identity
+	configMap.Annotations[renewedAnnotation] = now.UTC().Format(time.RFC3339)
+	return true
+}
+
+// getClient returns a client registered with the certificate authority, creating a new one if the
+// ACME configuration has changed.
+func (m *Manager) getClient(ctx context.Context, acmeConfig *model.ACMEConfig) (*acmeclient.Client, error) {
+	if m.client != nil && m.clientConfig == *acmeConfig {
+		return m.client, nil
+	}
+	key, err := m.getAccountKey()
+	if err != nil {
+		return nil, err
+	}
+	client := &acmeclient.Client{
+		Key:          key,
+		DirectoryURL: acmeConfig.DirectoryURL,
+	}
+	if acmeConfig.InsecureSkipVerify {
+		client.HTTPClient = &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			},
+		}
+	}
+	account := &acmeclient.Account{}
+	if acmeConfig.Email != "" {
+		account.Contact = []string{fmt.Sprintf("mailto:%s", acmeConfig.Email)}
+	}
+	_, err = client.Register(ctx, account, acmeclient.AcceptTOS)
+	if err != nil && err != acmeclient.ErrAccountAlreadyExists {
+		return nil, err
+	}
+	m.client = client
+	m.clientConfig = *acmeConfig
+	return client, nil
+}
+
+// getAccountKey returns the key identifying the router's account with the certificate authority,
+// generating and storing one if none exists yet.
+func (m *Manager) getAccountKey() (crypto.Signer, error) {
+	secret, err := m.kubeClient.CoreV1().Secrets(namespace).Get(accountSecretName, metav1.GetOptions{})
+	if err == nil {
+		return decodeKey(secret.Data["account.key"])
+	}
+	statusErr, ok := err.(*errors.StatusError)
+	if !ok || statusErr.Status().Code != 404 {
+		return nil, err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := encodeKey(key)
+	if err != nil {
+		return nil, err
+	}
+	err = m.saveSecret(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      accountSecretName,
+			Namespace: namespace,
+			Labels: map[string]string{
+				"heritage": "deis",
+			},
+		},
+		Data: map[string][]byte{
+			"account.key": keyPEM,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func (m *Manager) saveSecret(secret *corev1.Secret) error {
+	secretClient := m.kubeClient.CoreV1().Secrets(secret.Namespace)
+	_, err := secretClient.Update(secret)
+	if err == nil {
+		return nil
+	}
+	statusErr, ok := err.(*errors.StatusError)
+	if !ok || statusErr.Status().Code != 404 {
+		return err
+	}
+	_, err = secretClient.Create(secret)
+	return err
+}
+
+// needsRenewal returns whether the certificate is missing, unparseable, or expires within
+// renewBefore days of now. A certificate the router generated only stands in while none has been
+// obtained, so it counts as missing.
+func needsRenewal(certificate *model.Certificate, renewBefore int, now time.Time) bool {
+	if certificate == nil || certificate.Generated {
+		return true
+	}
+	block, _ := pem.Decode([]byte(certificate.Cert))
+	if block == nil {
+		return true
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return true
+	}
+	return now.AddDate(0, 0, renewBefore).After(cert.NotAfter)
+}
+
+func encodeCertificates(der [][]byte) []byte {
+	var certs []byte
+	for _, b := range der {
+		certs = append(certs, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: b})...)
+	}
+	return certs
+}
+
+func encodeKey(key *ecdsa.PrivateKey) ([]byte, error) {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), nil
+}
+
+func decodeKey(keyPEM []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, fmt.Errorf("the k8s secret %s contained no PEM-encoded \"account.key\"", accountSecretName)
+	}
+	return x509.ParseECPrivateKey(block.Bytes)
+}
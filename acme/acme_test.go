@@ -0,0 +1,162 @@
+package acme
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/teamhephy/router/model"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNeedsRenewal(t *testing.T) {
+	now := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	certificate := newTestCertificate(t, now.AddDate(0, 0, 60))
+	// With selfSigned enabled as well, the router serves a generated certificate until one is
+	// obtained.
+	generated := newTestCertificate(t, now.AddDate(0, 0, 60))
+	generated.Generated = true
+
+	tests := []struct {
+		description string
+		certificate *model.Certificate
+		renewBefore int
+		want        bool
+	}{
+		{"missing certificate", nil, 30, true},
+		{"unparseable certificate", &model.Certificate{Cert: "foo"}, 30, true},
+		{"certificate expiring after the renewal window", certificate, 30, false},
+		{"certificate expiring within the renewal window", certificate, 90, true},
+		{"generated certificate", generated, 30, true},
+	}
+	for _, test := range tests {
+		if got := needsRenewal(test.certificate, test.renewBefore, now); got != test.want {
+			t.Errorf("Expected needsRenewal to be %t for a %s, got %t", test.want, test.description, got)
+		}
+	}
+}
+
+func TestAcquire(t *testing.T) {
+	now := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	lease := func(holder string, renewedAt time.Time) *corev1.ConfigMap {
+		return &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Annotations: map[string]string{
+					holderAnnotation:  holder,
+					renewedAnnotation: renewedAt.Format(time.RFC3339),
+				},
+			},
+		}
+	}
+
+	tests := []struct {
+		description string
+		configMap   *corev1.ConfigMap
+		want        bool
+	}{
+		{"missing lease", &corev1.ConfigMap{}, true},
+		{"own lease", lease("router-a", now.Add(-time.Minute)), true},
+		{"own expired lease", lease("router-a", now.Add(-leaseDuration)), true},
+		{"other replica's lease", lease("router-b", now.Add(-time.Minute)), false},
+		{"other replica's expired lease", lease("router-b", now.Add(-leaseDuration)), true},
+	}
+	for _, test := range tests {
+		got := acquire(test.configMap, "router-a", now)
+		if got != test.want {
+			t.Errorf("Expected acquire to be %t for a %s, got %t", test.want, test.description, got)
+		}
+		if holder := test.configMap.Annotations[holderAnnotation]; got && holder != "router-a" {
+			t.Errorf("Expected the lease to be held by router-a for a %s, got %s", test.description, holder)
+		}
+		if renewedAt := test.configMap.Annotations[renewedAnnotation]; got && renewedAt != now.Format(time.RFC3339) {
+			t.Errorf("Expected the lease to be renewed at %s for a %s, got %s", now.Format(time.RFC3339), test.description, renewedAt)
+		}
+	}
+}
+
+func TestEncodeKey(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM, err := encodeKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodedKey, err := decodeKey(keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(key.Public(), decodedKey.Public()) {
+		t.Errorf("Expected the decoded key to match the encoded one.")
+	}
+
+	if _, err := decodeKey([]byte("foo")); err == nil {
+		t.Errorf("Expected an error decoding an invalid key, but did not receive any error")
+	}
+}
+
+func TestEncodeCertificates(t *testing.T) {
+	certs := encodeCertificates([][]byte{[]byte("foo"), []byte("bar")})
+	for _, want := range []string{"foo", "bar"} {
+		var block *pem.Block
+		block, certs = pem.Decode(certs)
+		if block == nil || block.Type != "CERTIFICATE" || string(block.Bytes) != want {
+			t.Fatalf("Expected a CERTIFICATE block containing %s, got %+v", want, block)
+		}
+	}
+	if len(certs) != 0 {
+		t.Errorf("Expected no data after the certificates, got %q", certs)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	m := NewManager(nil)
+	first := &model.RouterConfig{}
+	second := &model.RouterConfig{}
+	// Neither update may block, and only the latest configuration should be picked up.
+	m.Update(first)
+	m.Update(second)
+	if got := <-m.configs; got != second {
+		t.Errorf("Expected the latest configuration to be picked up.")
+	}
+	select {
+	case <-m.configs:
+		t.Errorf("Expected no other configuration to be picked up.")
+	default:
+	}
+}
+
+func newTestCertificate(t *testing.T, notAfter time.Time) *model.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "foo.example.com"},
+		DNSNames:     []string{"foo.example.com"},
+		NotBefore:    notAfter.AddDate(0, 0, -90),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM, err := encodeKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &model.Certificate{
+		Cert: string(encodeCertificates([][]byte{der})),
+		Key:  string(keyPEM),
+	}
+}
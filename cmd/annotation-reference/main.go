@@ -0,0 +1,32 @@
+// Command annotation-reference writes the reference of the annotations the router understands to
+// standard output, either as Markdown or as a JSON Schema.
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"github.com/teamhephy/router/model"
+	"github.com/teamhephy/router/reference"
+)
+
+func main() {
+	format := flag.String("format", "markdown", "output format, either markdown or schema")
+	flag.Parse()
+	groups, err := model.AnnotationReference()
+	if err != nil {
+		log.Fatalf("Failed to describe the annotations: %v", err)
+	}
+	switch *format {
+	case "markdown":
+		err = reference.WriteMarkdown(os.Stdout, groups)
+	case "schema":
+		err = reference.WriteJSONSchema(os.Stdout, groups)
+	default:
+		log.Fatalf("Unknown format %s; expected markdown or schema.", *format)
+	}
+	if err != nil {
+		log.Fatalf("Failed to write the annotation reference: %v", err)
+	}
+}
@@ -0,0 +1,255 @@
+package dhparam
+
+import (
+	"crypto/rand"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"os"
+	"time"
+
+	"github.com/teamhephy/router/model"
+	"github.com/teamhephy/router/utils"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	secretName = "deis-router-dhparam"
+	// Annotations on the secret recording which replica is generating DH parameters for it, and
+	// when it last renewed its lease on doing so.
+	holderAnnotation  = "router.deis.io/dhParamHolder"
+	renewedAnnotation = "router.deis.io/dhParamRenewedAt"
+	// How often to check whether DH parameters are still missing when the configuration doesn't
+	// change.
+	checkInterval = time.Hour
+	// How often the replica generating DH parameters renews its lease, and how long the lease lasts
+	// unless renewed.
+	renewInterval = time.Minute
+	leaseDuration = 5 * renewInterval
+)
+
+var (
+	namespace = utils.GetOpt("POD_NAMESPACE", "default")
+
+	errAbandoned = errors.New("generation was abandoned")
+)
+
+// Generator generates DH parameters when none have been provided and stores them in the secret
+// the router reads its DH parameters from, so that all replicas share them. Only the replica
+// holding the lease recorded on the secret generates them; the others wait for them.
+type Generator struct {
+	kubeClient *kubernetes.Clientset
+	identity   string
+	configs    chan *model.RouterConfig
+	// DH parameters generated, but not yet stored, along with their size.
+	pending     []byte
+	pendingSize int
+}
+
+// NewGenerator returns a Generator that stores DH parameters using the given client and
+// identifies its replica by its hostname, which is the name of its pod.
+func NewGenerator(kubeClient *kubernetes.Clientset) *Generator {
+	identity, err := os.Hostname()
+	if err != nil {
+		log.Printf("WARN: Failed to determine the hostname identifying this replica: %v\n", err)
+	}
+	return &Generator{
+		kubeClient: kubeClient,
+		identity:   identity,
+		configs:    make(chan *model.RouterConfig, 1),
+	}
+}
+
+// Update hands the Generator the latest router configuration. It never blocks; if the Generator
+// is busy, any configuration it has not picked up yet is replaced.
+func (g *Generator) Update(routerConfig *model.RouterConfig) {
+	for {
+		select {
+		case g.configs <- routerConfig:
+			return
+		default:
+		}
+		select {
+		case <-g.configs:
+		default:
+		}
+	}
+}
+
+// Run generates and stores DH parameters whenever the latest router configuration lacks them. It
+// never returns.
+func (g *Generator) Run() {
+	var routerConfig *model.RouterConfig
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case routerConfig = <-g.configs:
+		case <-ticker.C:
+		}
+		if routerConfig != nil && routerConfig.SSLConfig.DHParam == "" {
+			g.sync(routerConfig.SSLConfig.DHParamSize)
+		}
+	}
+}
+
+func (g *Generator) sync(size int) {
+	secret, ok := g.lead(time.Now())
+	if !ok {
+		return
+	}
+	if g.pending == nil || g.pendingSize != size {
+		log.Printf("INFO: Generating %d-bit DH parameters; this may take several minutes.\n", size)
+		lastRenewed := time.Now()
+		dhParam, err := generate(rand.Reader, size, func() bool {
+			if time.Since(lastRenewed) < renewInterval {
+				return false
+			}
+			lastRenewed = time.Now()
+			secret, ok = g.lead(lastRenewed)
+			return !ok
+		})
+		if err == errAbandoned {
+			log.Println("INFO: Another replica took over generating DH parameters; abandoning them.")
+			return
+		}
+		if err != nil {
+			log.Printf("WARN: Failed to generate DH parameters: %v\n", err)
+			return
+		}
+		g.pending = dhParam
+		g.pendingSize = size
+	}
+	delete(secret.Annotations, holderAnnotation)
+	delete(secret.Annotations, renewedAnnotation)
+	if secret.Data == nil {
+		secret.Data = make(map[string][]byte)
+	}
+	secret.Data["dhparam"] = g.pending
+	_, err := g.kubeClient.CoreV1().Secrets(namespace).Update(secret)
+	if err != nil {
+		// Another replica may have taken over in the meantime; its DH parameters will be picked up
+		// instead.
+		if statusErr, ok := err.(*apierrors.StatusError); ok && statusErr.Status().Code == 409 {
+			log.Printf("INFO: The k8s secret %s has changed; discarding the generated DH parameters.\n", secretName)
+			g.pending = nil
+			return
+		}
+		log.Printf("WARN: Failed to store the generated DH parameters: %v\n", err)
+		return
+	}
+	log.Printf("INFO: Stored the generated DH parameters in the k8s secret %s.\n", secretName)
+	g.pending = nil
+}
+
+// lead takes or renews this replica's lease on generating DH parameters, returning the secret
+// recording it and whether this replica holds the lease. Replicas coordinate through the secret's
+// resource version, so only one of them takes an expired lease. The lease is never taken on a
+// secret that already conveys DH parameters or that conveys none without anyone generating them,
+// which is the operator's to fix.
+func (g *Generator) lead(now time.Time) (*corev1.Secret, bool) {
+	secretClient := g.kubeClient.CoreV1().Secrets(namespace)
+	secret, err := secretClient.Get(secretName, metav1.GetOptions{})
+	if err == nil {
+		if _, ok := secret.Annotations[holderAnnotation]; !ok || len(secret.Data["dhparam"]) > 0 {
+			return nil, false
+		}
+	} else {
+		if statusErr, ok := err.(*apierrors.StatusError); !ok || statusErr.Status().Code != 404 {
+			log.Printf("WARN: Failed to look up the k8s secret %s: %v\n", secretName, err)
+			return nil, false
+		}
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      secretName,
+				Namespace: namespace,
+				Labels: map[string]string{
+					"heritage": "deis",
+				},
+			},
+		}
+	}
+	if !acquire(secret, g.identity, now) {
+		return nil, false
+	}
+	if secret.ResourceVersion == "" {
+		secret, err = secretClient.Create(secret)
+	} else {
+		secret, err = secretClient.Update(secret)
+	}
+	if err != nil {
+		// Another replica may have been quicker; it generates DH parameters instead.
+		if statusErr, ok := err.(*apierrors.StatusError); !ok || statusErr.Status().Code != 409 {
+			log.Printf("WARN: Failed to take the lease on generating DH parameters: %v\n", err)
+		}
+		return nil, false
+	}
+	return secret, true
+}
+
+// acquire records the replica as the holder of the lease in the secret's annotations, unless
+// another replica holds a lease that hasn't expired yet. It returns whether it did so.
+func acquire(secret *corev1.Secret, identity string, now time.Time) bool {
+	holder := secret.Annotations[holderAnnotation]
+	if holder != "" && holder != identity {
+		renewedAt, err := time.Parse(time.RFC3339, secret.Annotations[renewedAnnotation])
+		if err == nil && now.Sub(renewedAt) < leaseDuration {
+			return false
+		}
+	}
+	if secret.Annotations == nil {
+		secret.Annotations = make(map[string]string)
+	}
+	secret.Annotations[holderAnnotation] = identity
+	secret.Annotations[renewedAnnotation] = now.UTC().Format(time.RFC3339)
+	return true
+}
+
+// dhParameters is the ASN.1 structure of PKCS #3 DH parameters.
+type dhParameters struct {
+	Prime *big.Int
+	Base  int
+}
+
+// generate returns PEM-encoded DH parameters with a safe prime of the given size and the generator
+// 2, as "openssl dhparam" does. Between candidate primes, it asks abandon whether to give up, in
+// which case it returns errAbandoned.
+func generate(random io.Reader, size int, abandon func() bool) ([]byte, error) {
+	if size < 16 {
+		return nil, fmt.Errorf("%d bits are too few for a safe prime", size)
+	}
+	one := big.NewInt(1)
+	twelve := big.NewInt(12)
+	eleven := big.NewInt(11)
+	for {
+		if abandon() {
+			return nil, errAbandoned
+		}
+		// For the prime p = 2q + 1 to be safe, q must be prime as well. For 2 to generate the
+		// subgroup of order q, p must be 23 modulo 24, which is to say q must be 11 modulo 12.
+		q, err := rand.Prime(random, size-1)
+		if err != nil {
+			return nil, err
+		}
+		if new(big.Int).Mod(q, twelve).Cmp(eleven) != 0 {
+			continue
+		}
+		p := new(big.Int).Lsh(q, 1)
+		p.Add(p, one)
+		if p.BitLen() != size || !p.ProbablyPrime(20) {
+			continue
+		}
+		der, err := asn1.Marshal(dhParameters{Prime: p, Base: 2})
+		if err != nil {
+			return nil, err
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "DH PARAMETERS", Bytes: der}), nil
+	}
+}
@@ -0,0 +1,111 @@
+package dhparam
+
+import (
+	"crypto/rand"
+	"encoding/asn1"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/teamhephy/router/model"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGenerate(t *testing.T) {
+	dhParam, err := generate(rand.Reader, 128, func() bool { return false })
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, rest := pem.Decode(dhParam)
+	if block == nil || block.Type != "DH PARAMETERS" {
+		t.Fatalf("Expected a DH PARAMETERS block, got %q", dhParam)
+	}
+	if len(rest) != 0 {
+		t.Errorf("Expected no data after the DH parameters, got %q", rest)
+	}
+	var params dhParameters
+	if _, err := asn1.Unmarshal(block.Bytes, &params); err != nil {
+		t.Fatal(err)
+	}
+	p := params.Prime
+	if p.BitLen() != 128 {
+		t.Errorf("Expected a 128-bit prime, got %d bits", p.BitLen())
+	}
+	if params.Base != 2 {
+		t.Errorf("Expected the generator 2, got %d", params.Base)
+	}
+	q := new(big.Int).Rsh(p, 1)
+	if !p.ProbablyPrime(20) || !q.ProbablyPrime(20) {
+		t.Errorf("Expected a safe prime, got %s", p)
+	}
+	if new(big.Int).Mod(p, big.NewInt(24)).Int64() != 23 {
+		t.Errorf("Expected the prime to be 23 modulo 24, got %s", p)
+	}
+
+	if _, err := generate(rand.Reader, 8, func() bool { return false }); err == nil {
+		t.Errorf("Expected an error generating a tiny prime, but did not receive any error")
+	}
+}
+
+func TestGenerateAbandoned(t *testing.T) {
+	checks := 0
+	_, err := generate(rand.Reader, 128, func() bool {
+		checks++
+		return true
+	})
+	if err != errAbandoned {
+		t.Errorf("Expected generation to be abandoned, got %v", err)
+	}
+	if checks != 1 {
+		t.Errorf("Expected generation to be abandoned at the first check, got %d checks", checks)
+	}
+}
+
+func TestAcquire(t *testing.T) {
+	now := time.Date(2020, time.January, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		expected    bool
+	}{
+		{"no lease", nil, true},
+		{"own lease", map[string]string{holderAnnotation: "router-a", renewedAnnotation: "2020-01-01T11:59:00Z"}, true},
+		{"other's lease", map[string]string{holderAnnotation: "router-b", renewedAnnotation: "2020-01-01T11:59:00Z"}, false},
+		{"other's expired lease", map[string]string{holderAnnotation: "router-b", renewedAnnotation: "2020-01-01T11:50:00Z"}, true},
+		{"other's unreadable lease", map[string]string{holderAnnotation: "router-b", renewedAnnotation: "yesterday"}, true},
+	}
+	for _, test := range tests {
+		secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Annotations: test.annotations}}
+		if got := acquire(secret, "router-a", now); got != test.expected {
+			t.Errorf("%s: expected %t, got %t", test.name, test.expected, got)
+		}
+		if !test.expected {
+			continue
+		}
+		if holder := secret.Annotations[holderAnnotation]; holder != "router-a" {
+			t.Errorf("%s: expected the holder router-a, got %q", test.name, holder)
+		}
+		if renewedAt := secret.Annotations[renewedAnnotation]; renewedAt != "2020-01-01T12:00:00Z" {
+			t.Errorf("%s: expected the lease renewed at 2020-01-01T12:00:00Z, got %q", test.name, renewedAt)
+		}
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	g := NewGenerator(nil)
+	first := &model.RouterConfig{}
+	second := &model.RouterConfig{}
+	// Neither update may block, and only the latest configuration should be picked up.
+	g.Update(first)
+	g.Update(second)
+	if got := <-g.configs; got != second {
+		t.Errorf("Expected the latest configuration to be picked up.")
+	}
+	select {
+	case <-g.configs:
+		t.Errorf("Expected no other configuration to be picked up.")
+	default:
+	}
+}
@@ -5,6 +5,7 @@ go 1.16
 require (
 	github.com/Masterminds/sprig v0.0.0-20151229193220-2493695b1e81
 	github.com/aokoli/goutils v1.1.1-0.20200616180355-864fea799ab6 // indirect
+	golang.org/x/crypto v0.0.0-20200220183623-bac4c82f6975
 	k8s.io/api v0.0.0-20200903132056-f4b723619c71
 	k8s.io/apimachinery v0.17.12-rc.0.0.20200903131703-e01b6f647e0d
 	k8s.io/client-go v0.0.0-20200904012956-92dd56df9ae8
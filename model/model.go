@@ -1,14 +1,22 @@
 package model
 
 import (
-	"bytes"
-	"encoding/gob"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
 	"fmt"
 	"log"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/teamhephy/router/utils"
 	modelerUtility "github.com/teamhephy/router/utils/modeler"
+	"golang.org/x/crypto/ocsp"
 	appv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
@@ -22,39 +30,90 @@ const (
 	prefix               string = "router.deis.io"
 	modelerFieldTag      string = "key"
 	modelerConstraintTag string = "constraint"
+	modelerDefaultTag    string = "default"
+	modelerScopeTag      string = "scope"
+	// SessionTicketKeySize is the size of a session ticket key as used by nginx with AES-256.
+	SessionTicketKeySize int = 80
 )
 
 var (
 	namespace   = utils.GetOpt("POD_NAMESPACE", "default")
-	modeler     = modelerUtility.NewModeler(prefix, modelerFieldTag, modelerConstraintTag, true)
+	modeler     = modelerUtility.NewModeler(prefix, modelerFieldTag, modelerConstraintTag, true).WithDefaultTag(modelerDefaultTag)
 	listOptions metav1.ListOptions
+	// Apps share some models with the router, minus the fields only the router's annotations set.
+	appModeler = modeler.Scoped(modelerScopeTag, "app")
+	// Key types of the certificates a secret may convey alongside its primary certificate.
+	keyTypes = []string{"rsa", "ecdsa"}
+	// Keys of the annotations understood on the router's deployment and on services.
+	routerAnnotationKeys  []string
+	serviceAnnotationKeys []string
 )
 
 func init() {
 	labelMap := labels.Set{fmt.Sprintf("%s/routable", prefix): "true"}
 	listOptions = metav1.ListOptions{LabelSelector: labelMap.AsSelector().String(), FieldSelector: fields.Everything().String()}
+	routerAnnotationKeys = knownAnnotationKeys(map[string]interface{}{
+		"nginx": (*RouterConfig)(nil),
+	})
+	serviceAnnotationKeys = knownAnnotationKeys(map[string]interface{}{
+		"":       (*AppConfig)(nil),
+		"stream": (*StreamConfig)(nil),
+		"nginx":  (*BuilderConfig)(nil),
+	})
+}
+
+// knownAnnotationKeys returns the keys of the annotations populating the given models, each of
+// which is keyed by its context. It panics if a model can't be populated from annotations at all.
+func knownAnnotationKeys(models map[string]interface{}) []string {
+	var keys []string
+	for context, model := range models {
+		modelKeys, err := modelerFor(model).KnownKeys(context, model)
+		if err != nil {
+			panic(err)
+		}
+		keys = append(keys, modelKeys...)
+	}
+	return keys
+}
+
+// modelerFor returns the modeler populating the model from annotations: the app modeler for app
+// configurations, and the router's own for everything else.
+func modelerFor(model interface{}) *modelerUtility.Modeler {
+	if _, ok := model.(*AppConfig); ok {
+		return appModeler
+	}
+	return modeler
+}
+
+// applyDefaults sets the fields of the model, and of the models nested in it, to the defaults
+// given by their default tags. Defaults never change, so failing to apply them is a programming
+// error, and it panics.
+func applyDefaults(model interface{}) {
+	if err := modeler.ApplyDefaults(model); err != nil {
+		panic(err)
+	}
 }
 
 // RouterConfig is the primary type used to encapsulate all router configuration.
 type RouterConfig struct {
-	WorkerProcesses          string      `key:"workerProcesses" constraint:"^(auto|[1-9]\\d*)$"`
-	MaxWorkerConnections     string      `key:"maxWorkerConnections" constraint:"^[1-9]\\d*$"`
-	TrafficStatusZoneSize    string      `key:"trafficStatusZoneSize" constraint:"^[1-9]\\d*[kKmM]?$"`
-	DefaultTimeout           string      `key:"defaultTimeout" constraint:"^[1-9]\\d*(ms|[smhdwMy])?$"`
-	ServerNameHashMaxSize    string      `key:"serverNameHashMaxSize" constraint:"^[1-9]\\d*[kKmM]?$"`
-	ServerNameHashBucketSize string      `key:"serverNameHashBucketSize" constraint:"^[1-9]\\d*[kKmM]?$"`
+	WorkerProcesses          string      `key:"workerProcesses" constraint:"^(auto|[1-9]\\d*)$" default:"auto"`
+	MaxWorkerConnections     string      `key:"maxWorkerConnections" constraint:"^[1-9]\\d*$" default:"768"`
+	TrafficStatusZoneSize    string      `key:"trafficStatusZoneSize" constraint:"^[1-9]\\d*[kKmM]?$" default:"1m"`
+	DefaultTimeout           string      `key:"defaultTimeout" constraint:"^[1-9]\\d*(ms|[smhdwMy])?$" default:"1300s"`
+	ServerNameHashMaxSize    string      `key:"serverNameHashMaxSize" constraint:"^[1-9]\\d*[kKmM]?$" default:"512"`
+	ServerNameHashBucketSize string      `key:"serverNameHashBucketSize" constraint:"^[1-9]\\d*[kKmM]?$" default:"64"`
 	GzipConfig               *GzipConfig `key:"gzip"`
-	BodySize                 string      `key:"bodySize" constraint:"^[0-9]\\d*[kKmM]?$"`
-	LargeHeaderBuffersCount  string      `key:"largeHeaderBuffersCount" constraint:"^[1-9]\\d*$"`
-	LargeHeaderBuffersSize   string      `key:"largeHeaderBuffersSize" constraint:"^[0-9]\\d*[kKmM]?$"`
-	ProxyRealIPCIDRs         []string    `key:"proxyRealIpCidrs" constraint:"^((([0-9]|[1-9][0-9]|1[0-9]{2}|2[0-4][0-9]|25[0-5])\\.){3}([0-9]|[1-9][0-9]|1[0-9]{2}|2[0-4][0-9]|25[0-5])(\\/([0-9]|[1-2][0-9]|3[0-2]))?(\\s*,\\s*)?)+$"`
-	ErrorLogLevel            string      `key:"errorLogLevel" constraint:"^(debug|info|notice|warn|error|crit|alert|emerg)$"`
+	BodySize                 string      `key:"bodySize" constraint:"^[0-9]\\d*[kKmM]?$" default:"1m"`
+	LargeHeaderBuffersCount  string      `key:"largeHeaderBuffersCount" constraint:"^[1-9]\\d*$" default:"4"`
+	LargeHeaderBuffersSize   string      `key:"largeHeaderBuffersSize" constraint:"^[0-9]\\d*[kKmM]?$" default:"32k"`
+	ProxyRealIPCIDRs         []string    `key:"proxyRealIpCidrs" constraint:"^((([0-9]|[1-9][0-9]|1[0-9]{2}|2[0-4][0-9]|25[0-5])\\.){3}([0-9]|[1-9][0-9]|1[0-9]{2}|2[0-4][0-9]|25[0-5])(\\/([0-9]|[1-2][0-9]|3[0-2]))?(\\s*,\\s*)?)+$" default:"10.0.0.0/8"`
+	ErrorLogLevel            string      `key:"errorLogLevel" constraint:"^(debug|info|notice|warn|error|crit|alert|emerg)$" default:"error"`
 	PlatformDomain           string      `key:"platformDomain" constraint:"(?i)^([a-z0-9]+(-[a-z0-9]+)*\\.)+[a-z0-9]+(-*[a-z0-9]+)+$"`
 	UseProxyProtocol         bool        `key:"useProxyProtocol" constraint:"(?i)^(true|false)$"`
 	DisableServerTokens      bool        `key:"disableServerTokens" constraint:"(?i)^(true|false)$"`
 	EnforceWhitelists        bool        `key:"enforceWhitelists" constraint:"(?i)^(true|false)$"`
 	DefaultWhitelist         []string    `key:"defaultWhitelist" constraint:"^((([0-9]|[1-9][0-9]|1[0-9]{2}|2[0-4][0-9]|25[0-5])\\.){3}([0-9]|[1-9][0-9]|1[0-9]{2}|2[0-4][0-9]|25[0-5])(\\/([0-9]|[1-2][0-9]|3[0-2]))?(\\s*,\\s*)?)+$"`
-	WhitelistMode            string      `key:"whitelistMode" constraint:"^(extend|override)$"`
+	WhitelistMode            string      `key:"whitelistMode" constraint:"^(extend|override)$" default:"extend"`
 	EnableRegexDomains       bool        `key:"enableRegexDomains" constraint:"(?i)^(true|false)$"`
 	LoadModsecurityModule    bool        `key:"loadModsecurityModule" constraint:"(?i)^(true|false)$"`
 	DefaultServiceIP         string      `key:"defaultServiceIP"`
@@ -63,137 +122,237 @@ type RouterConfig struct {
 	RequestIDs               bool        `key:"requestIDs" constraint:"(?i)^(true|false)$"`
 	RequestStartHeader       bool        `key:"requestStartHeader" constraint:"(?i)^(true|false)$"`
 	SSLConfig                *SSLConfig  `key:"ssl"`
+	ACMEConfig               *ACMEConfig `key:"acme"`
 	AppConfigs               []*AppConfig
 	BuilderConfig            *BuilderConfig
+	StreamConfigs            []*StreamConfig
 	PlatformCertificate      *Certificate
-	HTTP2Enabled             bool                `key:"http2Enabled" constraint:"(?i)^(true|false)$"`
-	LogFormat                string              `key:"logFormat"`
+	ACMEChallenges           map[string]string
+	HTTP2Enabled             bool                `key:"http2Enabled" constraint:"(?i)^(true|false)$" default:"true"`
+	LogFormat                string              `key:"logFormat" default:"[$time_iso8601] - $app_name - $remote_addr - $remote_user - $status - \"$request\" - $bytes_sent - \"$http_referer\" - \"$http_user_agent\" - \"$server_name\" - $upstream_addr - $http_host - $upstream_response_time - $request_time"`
 	ProxyBuffersConfig       *ProxyBuffersConfig `key:"proxyBuffers"`
+	SelfSignedConfig         *SelfSignedConfig   `key:"selfSigned"`
 	ReferrerPolicy           string              `key:"referrerPolicy" constraint:"^(no-referrer|no-referrer-when-downgrade|origin|origin-when-cross-origin|same-origin|strict-origin|strict-origin-when-cross-origin|unsafe-url|none)$"`
 }
 
 func newRouterConfig() (*RouterConfig, error) {
-	proxyBuffersConfig, err := newProxyBuffersConfig(nil)
-	if err != nil {
-		return nil, err
-	}
-	return &RouterConfig{
-		WorkerProcesses:          "auto",
-		MaxWorkerConnections:     "768",
-		TrafficStatusZoneSize:    "1m",
-		DefaultTimeout:           "1300s",
-		ServerNameHashMaxSize:    "512",
-		ServerNameHashBucketSize: "64",
-		GzipConfig:               newGzipConfig(),
-		BodySize:                 "1m",
-		LargeHeaderBuffersCount:  "4",
-		LargeHeaderBuffersSize:   "32k",
-		ProxyRealIPCIDRs:         []string{"10.0.0.0/8"},
-		DisableServerTokens:      false,
-		ErrorLogLevel:            "error",
-		UseProxyProtocol:         false,
-		EnforceWhitelists:        false,
-		WhitelistMode:            "extend",
-		EnableRegexDomains:       false,
-		LoadModsecurityModule:    false,
-		RequestIDs:               false,
-		RequestStartHeader:       false,
-		SSLConfig:                newSSLConfig(),
-		DefaultServiceEnabled:    false,
-		DefaultAppName:           "",
-		DefaultServiceIP:         "",
-		HTTP2Enabled:             true,
-		LogFormat:                `[$time_iso8601] - $app_name - $remote_addr - $remote_user - $status - "$request" - $bytes_sent - "$http_referer" - "$http_user_agent" - "$server_name" - $upstream_addr - $http_host - $upstream_response_time - $request_time`,
-		ProxyBuffersConfig:       proxyBuffersConfig,
-		ReferrerPolicy:           "",
-	}, nil
+	routerConfig := &RouterConfig{}
+	if err := modeler.ApplyDefaults(routerConfig); err != nil {
+		return nil, err
+	}
+	return routerConfig, nil
 }
 
 // GzipConfig encapsulates gzip configuration.
 type GzipConfig struct {
-	Enabled     bool   `key:"enabled" constraint:"(?i)^(true|false)$"`
-	CompLevel   string `key:"compLevel" constraint:"^[1-9]$"`
-	Disable     string `key:"disable"`
-	HTTPVersion string `key:"httpVersion" constraint:"^(1\\.0|1\\.1)$"`
-	MinLength   string `key:"minLength" constraint:"^\\d+$"`
-	Proxied     string `key:"proxied" constraint:"^((off|expired|no-cache|no-store|private|no_last_modified|no_etag|auth|any)\\s*)+$"`
-	Types       string `key:"types" constraint:"(?i)^([a-z\\d]+/[a-z\\d][a-z\\d+\\-\\.]*[a-z\\d]\\s*)+$"`
-	Vary        string `key:"vary" constraint:"^(on|off)$"`
+	Enabled     bool   `key:"enabled" constraint:"(?i)^(true|false)$" default:"true"`
+	CompLevel   string `key:"compLevel" constraint:"^[1-9]$" default:"5"`
+	Disable     string `key:"disable" default:"msie6"`
+	HTTPVersion string `key:"httpVersion" constraint:"^(1\\.0|1\\.1)$" default:"1.1"`
+	MinLength   string `key:"minLength" constraint:"^\\d+$" default:"256"`
+	Proxied     string `key:"proxied" constraint:"^((off|expired|no-cache|no-store|private|no_last_modified|no_etag|auth|any)\\s*)+$" default:"any"`
+	Types       string `key:"types" constraint:"(?i)^([a-z\\d]+/[a-z\\d][a-z\\d+\\-\\.]*[a-z\\d]\\s*)+$" default:"application/atom+xml application/javascript application/json application/rss+xml application/vnd.ms-fontobject application/x-font-ttf application/x-web-app-manifest+json application/xhtml+xml application/xml font/opentype image/svg+xml image/x-icon text/css text/plain text/x-component"`
+	Vary        string `key:"vary" constraint:"^(on|off)$" default:"on"`
 }
 
 func newGzipConfig() *GzipConfig {
-	return &GzipConfig{
-		Enabled:     true,
-		CompLevel:   "5",
-		Disable:     "msie6",
-		HTTPVersion: "1.1",
-		MinLength:   "256",
-		Proxied:     "any",
-		Types:       "application/atom+xml application/javascript application/json application/rss+xml application/vnd.ms-fontobject application/x-font-ttf application/x-web-app-manifest+json application/xhtml+xml application/xml font/opentype image/svg+xml image/x-icon text/css text/plain text/x-component",
-		Vary:        "on",
-	}
+	gzipConfig := &GzipConfig{}
+	applyDefaults(gzipConfig)
+	return gzipConfig
 }
 
 // AppConfig encapsulates the configuration for all routes to a single back end.
 type AppConfig struct {
 	Name                      string
-	Domains                   []string `key:"domains" constraint:"(?i)^((([a-z0-9]+(-*[a-z0-9]+)*)|((\\*\\.)?[a-z0-9]+(-*[a-z0-9]+)*\\.)+[a-z0-9]+(-*[a-z0-9]+)+)(\\s*,\\s*)?)+$"`
-	RegexDomain               string   `key:"regexDomain"`
-	Whitelist                 []string `key:"whitelist" constraint:"^((([0-9]|[1-9][0-9]|1[0-9]{2}|2[0-4][0-9]|25[0-5])\\.){3}([0-9]|[1-9][0-9]|1[0-9]{2}|2[0-4][0-9]|25[0-5])(\\/([0-9]|[1-2][0-9]|3[0-2]))?(\\s*,\\s*)?)+$"`
-	ConnectTimeout            string   `key:"connectTimeout" constraint:"^[1-9]\\d*(ms|[smhdwMy])?$"`
-	TCPTimeout                string   `key:"tcpTimeout" constraint:"^[1-9]\\d*(ms|[smhdwMy])?$"`
+	Namespace                 string
+	ServiceName               string
+	Domains                   []string          `key:"domains" constraint:"(?i)^((([a-z0-9]+(-*[a-z0-9]+)*)|((\\*\\.)?[a-z0-9]+(-*[a-z0-9]+)*\\.)+[a-z0-9]+(-*[a-z0-9]+)+)(\\s*,\\s*)?)+$"`
+	RegexDomain               string            `key:"regexDomain"`
+	Whitelist                 []string          `key:"whitelist" constraint:"^((([0-9]|[1-9][0-9]|1[0-9]{2}|2[0-4][0-9]|25[0-5])\\.){3}([0-9]|[1-9][0-9]|1[0-9]{2}|2[0-4][0-9]|25[0-5])(\\/([0-9]|[1-2][0-9]|3[0-2]))?(\\s*,\\s*)?)+$"`
+	PathAccess                *PathAccessConfig `key:"pathAccess"`
+	ConnectTimeout            string            `key:"connectTimeout" constraint:"^[1-9]\\d*(ms|[smhdwMy])?$" default:"30s"`
+	TCPTimeout                string            `key:"tcpTimeout" constraint:"^[1-9]\\d*(ms|[smhdwMy])?$"`
 	ServiceIP                 string
-	CertMappings              map[string]string `key:"certificates" constraint:"(?i)^((([a-z0-9]+(-*[a-z0-9]+)*)|((\\*\\.)?[a-z0-9]+(-*[a-z0-9]+)*\\.)+[a-z0-9]+(-*[a-z0-9]+)+):([a-z0-9]+(-*[a-z0-9]+)*)(\\s*,\\s*)?)+$"`
+	CertMappings              map[string]string `key:"certificates" constraint:"(?i)^((([a-z0-9]+(-*[a-z0-9]+)*)|((\\*\\.)?[a-z0-9]+(-*[a-z0-9]+)*\\.)+[a-z0-9]+(-*[a-z0-9]+)+):([a-z0-9]+(-*[a-z0-9]+)*)(\\|[a-z0-9]+(-*[a-z0-9]+)*)*(\\s*,\\s*)?)+$"`
 	Certificates              map[string]*Certificate
+	SelfSignedDomains         []string
 	Available                 bool
-	Maintenance               bool            `key:"maintenance" constraint:"(?i)^(true|false)$"`
-	DisableRequestStartHeader bool            `key:"disableRequestStartHeader" constraint:"(?i)^(true|false)$"`
-	ReferrerPolicy            string          `key:"referrerPolicy" constraint:"^(no-referrer|no-referrer-when-downgrade|origin|origin-when-cross-origin|same-origin|strict-origin|strict-origin-when-cross-origin|unsafe-url|none)$"`
-	SSLConfig                 *SSLConfig      `key:"ssl"`
-	Nginx                     *NginxAppConfig `key:"nginx"`
-	ProxyLocations            []string        `key:"proxyLocations"`
-	ProxyDomain               string          `key:"proxyDomain"`
+	Maintenance               bool              `key:"maintenance" constraint:"(?i)^(true|false)$"`
+	DisableRequestStartHeader bool              `key:"disableRequestStartHeader" constraint:"(?i)^(true|false)$"`
+	ReferrerPolicy            string            `key:"referrerPolicy" constraint:"^(no-referrer|no-referrer-when-downgrade|origin|origin-when-cross-origin|same-origin|strict-origin|strict-origin-when-cross-origin|unsafe-url|none)$"`
+	SSLConfig                 *SSLConfig        `key:"ssl"`
+	TLSPassthrough            bool              `key:"tlsPassthrough" constraint:"(?i)^(true|false)$"`
+	ACMEConfig                *AppACMEConfig    `key:"acme"`
+	ClientCertConfig          *ClientCertConfig `key:"clientCert"`
+	BackendConfig             *BackendConfig    `key:"backend"`
+	Nginx                     *NginxAppConfig   `key:"nginx"`
+	ProxyLocations            []string          `key:"proxyLocations"`
+	ProxyDomain               string            `key:"proxyDomain"`
 	Locations                 []*Location
 }
 
 // Location represents a location block inside a back end server block.
 type Location struct {
-	App  *AppConfig
-	Path string
+	App    *AppConfig
+	Path   string
+	Access *LocationAccess
+}
+
+// LocationAccess represents the access rules rendered inside a single location block. Requests
+// from addresses that aren't allowed are denied if any are allowed or if DenyAll is set.
+type LocationAccess struct {
+	Allow     []string
+	Deny      []string
+	DenyAll   bool
+	BasicAuth *BasicAuth
+}
+
+// PathAccessConfig encapsulates access rules that are scoped to individual paths of an app. Each
+// option maps a path to a space-delimited list of addresses or, for basic auth, to the name of
+// a secret bearing an htpasswd file.
+type PathAccessConfig struct {
+	Allow      map[string]string `key:"allow" constraint:"^(/[A-Za-z0-9._~%/-]*:(([0-9]|[1-9][0-9]|1[0-9]{2}|2[0-4][0-9]|25[0-5])\\.){3}([0-9]|[1-9][0-9]|1[0-9]{2}|2[0-4][0-9]|25[0-5])(\\/([0-9]|[1-2][0-9]|3[0-2]))?(\\s+(([0-9]|[1-9][0-9]|1[0-9]{2}|2[0-4][0-9]|25[0-5])\\.){3}([0-9]|[1-9][0-9]|1[0-9]{2}|2[0-4][0-9]|25[0-5])(\\/([0-9]|[1-2][0-9]|3[0-2]))?)*(\\s*,\\s*)?)+$"`
+	Deny       map[string]string `key:"deny" constraint:"^(/[A-Za-z0-9._~%/-]*:(([0-9]|[1-9][0-9]|1[0-9]{2}|2[0-4][0-9]|25[0-5])\\.){3}([0-9]|[1-9][0-9]|1[0-9]{2}|2[0-4][0-9]|25[0-5])(\\/([0-9]|[1-2][0-9]|3[0-2]))?(\\s+(([0-9]|[1-9][0-9]|1[0-9]{2}|2[0-4][0-9]|25[0-5])\\.){3}([0-9]|[1-9][0-9]|1[0-9]{2}|2[0-4][0-9]|25[0-5])(\\/([0-9]|[1-2][0-9]|3[0-2]))?)*(\\s*,\\s*)?)+$"`
+	BasicAuth  map[string]string `key:"basicAuth" constraint:"(?i)^(/[A-Za-z0-9._~%/-]*:[a-z0-9]+(-*[a-z0-9]+)*(\\s*,\\s*)?)+$"`
+	BasicAuths map[string]*BasicAuth
+}
+
+func newPathAccessConfig() *PathAccessConfig {
+	return &PathAccessConfig{
+		BasicAuths: make(map[string]*BasicAuth),
+	}
+}
+
+// BasicAuth represents an htpasswd file used to require basic authentication for a path.
+type BasicAuth struct {
+	Name     string
+	Htpasswd string
+}
+
+func newBasicAuth(name string, htpasswd string) *BasicAuth {
+	return &BasicAuth{
+		Name:     name,
+		Htpasswd: htpasswd,
+	}
 }
 
 func newAppConfig(routerConfig *RouterConfig) (*AppConfig, error) {
-	nginxConfig, err := newNginxAppConfig(routerConfig)
-	if err != nil {
+	appConfig := &AppConfig{}
+	if err := modeler.ApplyDefaults(appConfig); err != nil {
 		return nil, err
 	}
-	return &AppConfig{
-		ConnectTimeout: "30s",
-		TCPTimeout:     routerConfig.DefaultTimeout,
-		Certificates:   make(map[string]*Certificate),
-		SSLConfig:      newSSLConfig(),
-		Nginx:          nginxConfig,
-	}, nil
+	appConfig.TCPTimeout = routerConfig.DefaultTimeout
+	appConfig.Certificates = make(map[string]*Certificate)
+	appConfig.PathAccess = newPathAccessConfig()
+	appConfig.SSLConfig = newAppSSLConfig(routerConfig.SSLConfig)
+	appConfig.Nginx = newNginxAppConfig(routerConfig)
+	return appConfig, nil
+}
+
+// ACMEConfig encapsulates the router-wide configuration used for obtaining certificates from an
+// ACME certificate authority.
+type ACMEConfig struct {
+	DirectoryURL       string `key:"directoryURL" constraint:"^https?://\\S+$" default:"https://acme-v02.api.letsencrypt.org/directory"`
+	Email              string `key:"email" constraint:"^[^@\\s]+@[^@\\s]+$"`
+	RenewBefore        int    `key:"renewBefore" constraint:"^[1-9]\\d*$" default:"30"`
+	InsecureSkipVerify bool   `key:"insecureSkipVerify" constraint:"(?i)^(true|false)$"`
+}
+
+func newACMEConfig() *ACMEConfig {
+	acmeConfig := &ACMEConfig{}
+	applyDefaults(acmeConfig)
+	return acmeConfig
+}
+
+// AppACMEConfig encapsulates an application's opt-in to ACME certificate issuance. Domains lists
+// the application's domains for which the router should obtain certificates.
+type AppACMEConfig struct {
+	Enabled   bool `key:"enabled" constraint:"(?i)^(true|false)$"`
+	Namespace string
+	Domains   []string
+}
+
+func newAppACMEConfig() *AppACMEConfig {
+	acmeConfig := &AppACMEConfig{}
+	applyDefaults(acmeConfig)
+	return acmeConfig
+}
+
+// ACMECertName returns the name used for the cert-bearing secret holding the certificate obtained
+// for a domain from an ACME certificate authority.
+func ACMECertName(domain string) string {
+	return fmt.Sprintf("acme-%s", strings.ToLower(domain))
+}
+
+// SelfSignedConfig encapsulates the router-wide configuration used for generating certificates
+// for domains that have none. CAMapping names a cert-bearing secret in the router's namespace
+// whose certificate and key sign the generated certificates; without one, they are self-signed.
+type SelfSignedConfig struct {
+	Enabled   bool   `key:"enabled" constraint:"(?i)^(true|false)$"`
+	CAMapping string `key:"ca" constraint:"(?i)^[a-z0-9]+(-*[a-z0-9]+)*$"`
+	ValidDays int    `key:"validDays" constraint:"^[1-9]\\d*$" default:"90"`
+}
+
+func newSelfSignedConfig() *SelfSignedConfig {
+	selfSignedConfig := &SelfSignedConfig{}
+	applyDefaults(selfSignedConfig)
+	return selfSignedConfig
+}
+
+// GeneratedCertName returns the name used for the cert-bearing secret holding the certificate
+// generated for a domain.
+func GeneratedCertName(domain string) string {
+	return fmt.Sprintf("generated-%s", strings.Replace(strings.ToLower(domain), "*", "wildcard", 1))
 }
 
 // BuilderConfig encapsulates the configuration of the deis-builder-- if it's in use.
 type BuilderConfig struct {
-	ConnectTimeout string `key:"connectTimeout" constraint:"^[1-9]\\d*(ms|[smhdwMy])?$"`
-	TCPTimeout     string `key:"tcpTimeout" constraint:"^[1-9]\\d*(ms|[smhdwMy])?$"`
+	ConnectTimeout string `key:"connectTimeout" constraint:"^[1-9]\\d*(ms|[smhdwMy])?$" default:"10s"`
+	TCPTimeout     string `key:"tcpTimeout" constraint:"^[1-9]\\d*(ms|[smhdwMy])?$" default:"1200s"`
 	ServiceIP      string
 }
 
 func newBuilderConfig() *BuilderConfig {
-	return &BuilderConfig{
-		ConnectTimeout: "10s",
-		TCPTimeout:     "1200s",
-	}
+	builderConfig := &BuilderConfig{}
+	applyDefaults(builderConfig)
+	return builderConfig
 }
 
-// Certificate represents an SSL certificate for use in securing routable applications.
+// StreamConfig encapsulates the configuration of a TCP or UDP service exposed on one or more
+// router ports. Ports maps each router port to the corresponding port of the service. Router
+// ports must be unprivileged, as nginx doesn't run as root and couldn't listen on them.
+type StreamConfig struct {
+	Name           string
+	Ports          map[string]string `key:"ports" constraint:"^((102[4-9]|10[3-9]\\d|1[1-9]\\d{2}|[2-9]\\d{3}|[1-5]\\d{4}|6[0-4]\\d{3}|65[0-4]\\d{2}|655[0-2]\\d|6553[0-5]):([1-9]\\d{0,3}|[1-5]\\d{4}|6[0-4]\\d{3}|65[0-4]\\d{2}|655[0-2]\\d|6553[0-5])(\\s*,\\s*)?)+$"`
+	Protocol       string            `key:"protocol" constraint:"^(tcp|udp)$" default:"tcp"`
+	ConnectTimeout string            `key:"connectTimeout" constraint:"^[1-9]\\d*(ms|[smhdwMy])?$" default:"10s"`
+	Timeout        string            `key:"timeout" constraint:"^[1-9]\\d*(ms|[smhdwMy])?$" default:"10m"`
+	ProxyProtocol  bool              `key:"proxyProtocol" constraint:"(?i)^(true|false)$"`
+	Whitelist      []string          `key:"whitelist" constraint:"^((([0-9]|[1-9][0-9]|1[0-9]{2}|2[0-4][0-9]|25[0-5])\\.){3}([0-9]|[1-9][0-9]|1[0-9]{2}|2[0-4][0-9]|25[0-5])(\\/([0-9]|[1-2][0-9]|3[0-2]))?(\\s*,\\s*)?)+$"`
+	ServiceIP      string
+}
+
+func newStreamConfig() *StreamConfig {
+	streamConfig := &StreamConfig{}
+	applyDefaults(streamConfig)
+	return streamConfig
+}
+
+// Certificate represents an SSL certificate for use in securing routable applications. Alternates
+// are certificates for the same domains using other key types (for instance, RSA alongside
+// ECDSA), so that each client is served the best certificate it supports. TrustedChain holds the
+// intermediate and root certificates of the certificate and its alternates, used for verifying
+// OCSP responses, and OCSPResponse an optional pre-fetched, DER-encoded OCSP response to staple.
+// KeyType and NotAfter are only known once the certificate has been validated.
 type Certificate struct {
-	Cert string
-	Key  string
+	Cert         string
+	Key          string
+	KeyType      string
+	NotAfter     time.Time
+	TrustedChain string
+	OCSPResponse string
+	Alternates   []*Certificate
+	Generated    bool
 }
 
 func newCertificate(cert string, key string) *Certificate {
@@ -203,56 +362,117 @@ func newCertificate(cert string, key string) *Certificate {
 	}
 }
 
-// SSLConfig represents SSL-related configuration options.
+// SSLConfig represents SSL-related configuration options. SessionTicketKeys are shared by all
+// replicas, newest first; the newest key encrypts tickets, while the others are kept for
+// decrypting tickets issued before the last rotations.
+//
+// The default cipher suite prefers 128-bit over 256-bit encryption (lower overhead) and GCM over
+// EDH over RSA auth (for forward secrecy), falls back to 112-bit 3DES (mainly for IE 8
+// compatibility), and lets clients choose between AES128-GCM and ChaCha20-Poly1305. It is
+// compatible with Firefox 1, Chrome 1, IE 7, Opera 5, Safari 1, Windows XP IE8, Android 2.3, and
+// Java 7, but not with Windows XP IE6 or Java 6. Source:
+// https://wiki.mozilla.org/Security/Server_Side_TLS (old backward compatibility).
 type SSLConfig struct {
-	Enforce           bool        `key:"enforce" constraint:"(?i)^(true|false)$"`
-	Protocols         string      `key:"protocols" constraint:"^((SSLv[2-3]|TLSv1(?:\\.[1-3])?)\\s*)+$"`
-	Ciphers           string      `key:"ciphers" constraint:"^((\\b[\\w.!+-]+\\b)+(:?@(STRENGTH|SECLEVEL=[0-5]))?(:([!+-]\\b)?|$))*(((\\b[\\w.+-]+\\b)+|(\\[(\\b[\\w.|+-]+\\b)+\\]))(:|$))*$"`
-	SessionCache      string      `key:"sessionCache" constraint:"^(off|none|((builtin(:[1-9]\\d*)?|shared:\\w+:[1-9]\\d*[kKmM]?)\\s*){1,2})$"`
-	SessionTimeout    string      `key:"sessionTimeout" constraint:"^[1-9]\\d*(ms|[smhdwMy])?$"`
-	UseSessionTickets bool        `key:"useSessionTickets" constraint:"(?i)^(true|false)$"`
-	BufferSize        string      `key:"bufferSize" constraint:"^[1-9]\\d*[kKmM]?$"`
-	HSTSConfig        *HSTSConfig `key:"hsts"`
-	EarlyDataMethods  string      `key:"earlyDataMethods" constraint:"^((GET|HEAD|POST|PUT|DELETE|PATCH|OPTIONS)(\\|\\b|$))*$"`
-	DHParam           string
+	Enforce                  bool          `key:"enforce" constraint:"(?i)^(true|false)$"`
+	Protocols                string        `key:"protocols" constraint:"^((SSLv[2-3]|TLSv1(?:\\.[1-3])?)\\s*)+$" default:"TLSv1 TLSv1.1 TLSv1.2 TLSv1.3"`
+	Ciphers                  string        `key:"ciphers" constraint:"^((\\b[\\w.!+-]+\\b)+(:?@(STRENGTH|SECLEVEL=[0-5]))?(:([!+-]\\b)?|$))*(((\\b[\\w.+-]+\\b)+|(\\[(\\b[\\w.|+-]+\\b)+\\]))(:|$))*$" default:"[TLS_AES_128_GCM_SHA256|TLS_CHACHA20_POLY1305_SHA256]:TLS_AES_256_GCM_SHA384:[ECDHE-ECDSA-AES128-GCM-SHA256|ECDHE-ECDSA-CHACHA20-POLY1305|ECDHE-ECDSA-CHACHA20-POLY1305-OLD]:[ECDHE-RSA-AES128-GCM-SHA256|ECDHE-RSA-CHACHA20-POLY1305|ECDHE-RSA-CHACHA20-POLY1305-OLD]:ECDHE-ECDSA-AES256-GCM-SHA384:ECDHE-RSA-AES256-GCM-SHA384:DHE-RSA-AES128-GCM-SHA256:DHE-RSA-AES256-GCM-SHA384:ECDHE-ECDSA-AES128-SHA256:ECDHE-RSA-AES128-SHA256:ECDHE-ECDSA-AES128-SHA:ECDHE-RSA-AES256-SHA384:ECDHE-RSA-AES128-SHA:ECDHE-ECDSA-AES256-SHA384:ECDHE-ECDSA-AES256-SHA:ECDHE-RSA-AES256-SHA:DHE-RSA-AES128-SHA256:DHE-RSA-AES128-SHA:DHE-RSA-AES256-SHA256:DHE-RSA-AES256-SHA:ECDHE-ECDSA-DES-CBC3-SHA:ECDHE-RSA-DES-CBC3-SHA:EDH-RSA-DES-CBC3-SHA:AES128-GCM-SHA256:AES256-GCM-SHA384:AES128-SHA256:AES256-SHA256:AES128-SHA:AES256-SHA:DES-CBC3-SHA"`
+	SessionCache             string        `key:"sessionCache" constraint:"^(off|none|((builtin(:[1-9]\\d*)?|shared:\\w+:[1-9]\\d*[kKmM]?)\\s*){1,2})$"`
+	SessionTimeout           string        `key:"sessionTimeout" constraint:"^[1-9]\\d*(ms|[smhdwMy])?$" default:"10m"`
+	UseSessionTickets        bool          `key:"useSessionTickets" constraint:"(?i)^(true|false)$" default:"true"`
+	BufferSize               string        `key:"bufferSize" constraint:"^[1-9]\\d*[kKmM]?$" default:"4k"`
+	HSTSConfig               *HSTSConfig   `key:"hsts"`
+	OCSPConfig               *OCSPConfig   `key:"ocsp"`
+	EarlyDataMethods         string        `key:"earlyDataMethods" constraint:"^((GET|HEAD|POST|PUT|DELETE|PATCH|OPTIONS)(\\|\\b|$))*$" default:"GET|HEAD|OPTIONS"`
+	ExpiryWarningDays        int           `key:"expiryWarningDays" constraint:"^[1-9]\\d*$" default:"14" scope:"router"`
+	DHParamSize              int           `key:"dhParamSize" constraint:"^(2048|3072|4096)$" default:"2048" scope:"router"`
+	SessionTicketKeyRotation time.Duration `key:"sessionTicketKeyRotation" constraint:"^[1-9]\\d*[smhdw]$" default:"12h" scope:"router"`
+	DHParam                  string
+	SessionTicketKeys        []string
 }
 
 func newSSLConfig() *SSLConfig {
-	return &SSLConfig{
-		Enforce:   false,
-		Protocols: "TLSv1 TLSv1.1 TLSv1.2 TLSv1.3",
-		// Default cipher suite:
-		//  - Prefer 128-Bit over 256-Bit encryptions (lower overhead)
-		//  - Prefer GCM over EDH over RSA auth (for Forward Secrecy)
-		//  - Fallback to 112-Bit 3DES (mainly for IE 8 compatibility)
-		//  - Let clients choose between AES128-GCM and ChaCha20-Poly1305
-		// Compatible: Firefox 1, Chrome 1, IE 7, Opera 5, Safari 1, Windows XP IE8, Android 2.3, Java 7
-		// Incompatible: Windows XP IE6, Java 6
-		// Source: https://wiki.mozilla.org/Security/Server_Side_TLS (old backward compatibility)
-		Ciphers:           "[TLS_AES_128_GCM_SHA256|TLS_CHACHA20_POLY1305_SHA256]:TLS_AES_256_GCM_SHA384:[ECDHE-ECDSA-AES128-GCM-SHA256|ECDHE-ECDSA-CHACHA20-POLY1305|ECDHE-ECDSA-CHACHA20-POLY1305-OLD]:[ECDHE-RSA-AES128-GCM-SHA256|ECDHE-RSA-CHACHA20-POLY1305|ECDHE-RSA-CHACHA20-POLY1305-OLD]:ECDHE-ECDSA-AES256-GCM-SHA384:ECDHE-RSA-AES256-GCM-SHA384:DHE-RSA-AES128-GCM-SHA256:DHE-RSA-AES256-GCM-SHA384:ECDHE-ECDSA-AES128-SHA256:ECDHE-RSA-AES128-SHA256:ECDHE-ECDSA-AES128-SHA:ECDHE-RSA-AES256-SHA384:ECDHE-RSA-AES128-SHA:ECDHE-ECDSA-AES256-SHA384:ECDHE-ECDSA-AES256-SHA:ECDHE-RSA-AES256-SHA:DHE-RSA-AES128-SHA256:DHE-RSA-AES128-SHA:DHE-RSA-AES256-SHA256:DHE-RSA-AES256-SHA:ECDHE-ECDSA-DES-CBC3-SHA:ECDHE-RSA-DES-CBC3-SHA:EDH-RSA-DES-CBC3-SHA:AES128-GCM-SHA256:AES256-GCM-SHA384:AES128-SHA256:AES256-SHA256:AES128-SHA:AES256-SHA:DES-CBC3-SHA",
-		SessionTimeout:    "10m",
-		UseSessionTickets: true,
-		BufferSize:        "4k",
-		HSTSConfig:        newHSTSConfig(),
-		EarlyDataMethods:  "GET|HEAD|OPTIONS",
+	sslConfig := &SSLConfig{}
+	applyDefaults(sslConfig)
+	return sslConfig
+}
+
+// newAppSSLConfig returns a copy of the router's SSL configuration, from which an app's own SSL
+// configuration departs only where its annotations say so.
+func newAppSSLConfig(sslConfig *SSLConfig) *SSLConfig {
+	if sslConfig == nil {
+		return newSSLConfig()
 	}
+	copy := modelerUtility.DeepCopy(sslConfig).(*SSLConfig)
+	// DH parameters and session ticket keys are router-wide.
+	copy.DHParam = ""
+	copy.SessionTicketKeys = nil
+	return copy
+}
+
+// ClientCertConfig represents options having to do with verifying client certificates presented
+// to an app's TLS listener.
+type ClientCertConfig struct {
+	Verify         string `key:"verify" constraint:"^(off|on|optional|optional_no_ca)$" default:"off"`
+	VerifyDepth    int    `key:"verifyDepth" constraint:"^\\d+$" default:"1"`
+	CAMapping      string `key:"ca" constraint:"(?i)^[a-z0-9]+(-*[a-z0-9]+)*$"`
+	ForwardHeaders bool   `key:"forwardHeaders" constraint:"(?i)^(true|false)$"`
+	Name           string
+	CA             string
+	CRL            string
+}
+
+func newClientCertConfig() *ClientCertConfig {
+	clientCertConfig := &ClientCertConfig{}
+	applyDefaults(clientCertConfig)
+	return clientCertConfig
+}
+
+// BackendConfig represents options having to do with how the router connects to an app's back
+// end.
+type BackendConfig struct {
+	Protocol          string `key:"protocol" constraint:"^(http|https|grpc|grpcs|h2c)$" default:"http"`
+	Port              string `key:"port" constraint:"^[1-9]\\d*$"`
+	SNIName           string `key:"sniName" constraint:"(?i)^([a-z0-9]+(-*[a-z0-9]+)*\\.)*[a-z0-9]+(-*[a-z0-9]+)*$"`
+	CAMapping         string `key:"ca" constraint:"(?i)^[a-z0-9]+(-*[a-z0-9]+)*$"`
+	VerifyDepth       int    `key:"verifyDepth" constraint:"^\\d+$" default:"1"`
+	CertMapping       string `key:"certificate" constraint:"(?i)^[a-z0-9]+(-*[a-z0-9]+)*$"`
+	Name              string
+	CA                string
+	CRL               string
+	ClientCertificate *Certificate
+}
+
+func newBackendConfig() *BackendConfig {
+	backendConfig := &BackendConfig{}
+	applyDefaults(backendConfig)
+	return backendConfig
 }
 
 // HSTSConfig represents configuration options having to do with HTTP Strict Transport Security.
 type HSTSConfig struct {
 	Enabled           bool `key:"enabled" constraint:"(?i)^(true|false)$"`
-	MaxAge            int  `key:"maxAge" constraint:"^[1-9]\\d*$"`
+	MaxAge            int  `key:"maxAge" constraint:"^[1-9]\\d*$" default:"15552000"`
 	IncludeSubDomains bool `key:"includeSubDomains" constraint:"(?i)^(true|false)$"`
 	Preload           bool `key:"preload" constraint:"(?i)^(true|false)$"`
 }
 
 func newHSTSConfig() *HSTSConfig {
-	return &HSTSConfig{
-		Enabled:           false,
-		MaxAge:            15552000, // 180 days
-		IncludeSubDomains: false,
-		Preload:           false,
-	}
+	hstsConfig := &HSTSConfig{}
+	applyDefaults(hstsConfig)
+	return hstsConfig
+}
+
+// OCSPConfig represents configuration options having to do with OCSP stapling.
+type OCSPConfig struct {
+	Stapling bool   `key:"stapling" constraint:"(?i)^(true|false)$"`
+	Verify   bool   `key:"verify" constraint:"(?i)^(true|false)$"`
+	Resolver string `key:"resolver" constraint:"^([\\w.:\\[\\]-]+(=(on|off))?\\s*)+$"`
+}
+
+func newOCSPConfig() *OCSPConfig {
+	ocspConfig := &OCSPConfig{}
+	applyDefaults(ocspConfig)
+	return ocspConfig
 }
 
 // NginxAppConfig is a wrapper for all Nginx-specific app configurations. These
@@ -262,46 +482,28 @@ type NginxAppConfig struct {
 	ProxyBuffersConfig *ProxyBuffersConfig `key:"proxyBuffers"`
 }
 
-func newNginxAppConfig(routerConfig *RouterConfig) (*NginxAppConfig, error) {
-	proxyBuffersConfig, err := newProxyBuffersConfig(routerConfig.ProxyBuffersConfig)
-	if err != nil {
-		return nil, err
-	}
+func newNginxAppConfig(routerConfig *RouterConfig) *NginxAppConfig {
 	return &NginxAppConfig{
-		ProxyBuffersConfig: proxyBuffersConfig,
-	}, nil
+		ProxyBuffersConfig: newProxyBuffersConfig(routerConfig.ProxyBuffersConfig),
+	}
 }
 
 // ProxyBuffersConfig represents configuration options having to do with Nginx
 // proxy buffers.
 type ProxyBuffersConfig struct {
 	Enabled  bool   `key:"enabled" constraint:"(?i)^(true|false)$"`
-	Number   int    `key:"number" constraint:"^[1-9]\\d*$"`
-	Size     string `key:"size" constraint:"^[1-9]\\d*[kKmM]?$"`
-	BusySize string `key:"busySize" constraint:"^[1-9]\\d*[kKmM]?$"`
+	Number   int    `key:"number" constraint:"^[1-9]\\d*$" default:"8"`
+	Size     string `key:"size" constraint:"^[1-9]\\d*[kKmM]?$" default:"4k"`
+	BusySize string `key:"busySize" constraint:"^[1-9]\\d*[kKmM]?$" default:"8k"`
 }
 
-func newProxyBuffersConfig(proxyBuffersConfig *ProxyBuffersConfig) (*ProxyBuffersConfig, error) {
+func newProxyBuffersConfig(proxyBuffersConfig *ProxyBuffersConfig) *ProxyBuffersConfig {
 	if proxyBuffersConfig != nil {
-		var buf bytes.Buffer
-		enc := gob.NewEncoder(&buf)
-		dec := gob.NewDecoder(&buf)
-		err := enc.Encode(proxyBuffersConfig)
-		if err != nil {
-			return nil, err
-		}
-		var copy *ProxyBuffersConfig
-		err = dec.Decode(&copy)
-		if err != nil {
-			return nil, err
-		}
-		return copy, nil
+		return modelerUtility.DeepCopy(proxyBuffersConfig).(*ProxyBuffersConfig)
 	}
-	return &ProxyBuffersConfig{
-		Number:   8,
-		Size:     "4k",
-		BusySize: "8k",
-	}, nil
+	proxyBuffersConfig = &ProxyBuffersConfig{}
+	applyDefaults(proxyBuffersConfig)
+	return proxyBuffersConfig
 }
 
 // Build creates a RouterConfig configuration object by querying the k8s API for
@@ -333,8 +535,26 @@ func Build(kubeClient *kubernetes.Clientset) (*RouterConfig, error) {
 	if err != nil {
 		return nil, err
 	}
+	sessionTicketKeysSecret, err := getSecret(kubeClient, "deis-router-session-ticket-keys", namespace)
+	if err != nil {
+		return nil, err
+	}
+	wildcardCertSecrets, err := getWildcardCertSecrets(kubeClient)
+	if err != nil {
+		return nil, err
+	}
+	// streamsConfigMap might be nil if it's not found and that's ok.
+	streamsConfigMap, err := getConfigMap(kubeClient, "deis-router-streams", namespace)
+	if err != nil {
+		return nil, err
+	}
+	// acmeChallengesConfigMap might be nil if no certificate was ever obtained and that's ok.
+	acmeChallengesConfigMap, err := getConfigMap(kubeClient, "deis-router-acme-challenges", namespace)
+	if err != nil {
+		return nil, err
+	}
 	// Build the model...
-	routerConfig, err := build(kubeClient, routerDeployment, platformCertSecret, dhParamSecret, appServices, builderService)
+	routerConfig, err := build(kubeClient, routerDeployment, platformCertSecret, dhParamSecret, sessionTicketKeysSecret, wildcardCertSecrets, appServices, builderService, streamsConfigMap, acmeChallengesConfigMap)
 	if err != nil {
 		return nil, err
 	}
@@ -390,13 +610,70 @@ func getSecret(kubeClient *kubernetes.Clientset, name string, ns string) (*corev
 	return secret, nil
 }
 
-func build(kubeClient *kubernetes.Clientset, routerDeployment *appv1.Deployment, platformCertSecret *corev1.Secret, dhParamSecret *corev1.Secret, appServices *corev1.ServiceList, builderService *corev1.Service) (*RouterConfig, error) {
-	routerConfig, err := buildRouterConfig(routerDeployment, platformCertSecret, dhParamSecret)
+// getWildcardCertSecrets returns the cert-bearing secrets from the same namespace as the router
+// that are labeled for use with any app whose domains they cover.
+func getWildcardCertSecrets(kubeClient *kubernetes.Clientset) (*corev1.SecretList, error) {
+	secretClient := kubeClient.CoreV1().Secrets(namespace)
+	labelMap := labels.Set{fmt.Sprintf("%s/wildcard", prefix): "true"}
+	secrets, err := secretClient.List(metav1.ListOptions{LabelSelector: labelMap.AsSelector().String()})
 	if err != nil {
 		return nil, err
 	}
+	return secrets, nil
+}
+
+func getService(kubeClient *kubernetes.Clientset, name string, ns string) (*corev1.Service, error) {
+	serviceClient := kubeClient.CoreV1().Services(ns)
+	service, err := serviceClient.Get(name, metav1.GetOptions{})
+	if err != nil {
+		statusErr, ok := err.(*errors.StatusError)
+		// If the issue is just that no such service was found, that's ok.
+		if ok && statusErr.Status().Code == 404 {
+			// We'll just return nil instead of a found *metav1.Service.
+			return nil, nil
+		}
+		return nil, err
+	}
+	return service, nil
+}
+
+func getConfigMap(kubeClient *kubernetes.Clientset, name string, ns string) (*corev1.ConfigMap, error) {
+	configMapClient := kubeClient.CoreV1().ConfigMaps(ns)
+	configMap, err := configMapClient.Get(name, metav1.GetOptions{})
+	if err != nil {
+		statusErr, ok := err.(*errors.StatusError)
+		// If the issue is just that no such config map was found, that's ok.
+		if ok && statusErr.Status().Code == 404 {
+			// We'll just return nil instead of a found *metav1.ConfigMap.
+			return nil, nil
+		}
+		return nil, err
+	}
+	return configMap, nil
+}
+
+func build(kubeClient *kubernetes.Clientset, routerDeployment *appv1.Deployment, platformCertSecret *corev1.Secret, dhParamSecret *corev1.Secret, sessionTicketKeysSecret *corev1.Secret, wildcardCertSecrets *corev1.SecretList, appServices *corev1.ServiceList, builderService *corev1.Service, streamsConfigMap *corev1.ConfigMap, acmeChallengesConfigMap *corev1.ConfigMap) (*RouterConfig, error) {
+	routerConfig, err := buildRouterConfig(routerDeployment, platformCertSecret, dhParamSecret, sessionTicketKeysSecret)
+	if err != nil {
+		return nil, err
+	}
+	if acmeChallengesConfigMap != nil {
+		routerConfig.ACMEChallenges = buildACMEChallenges(acmeChallengesConfigMap)
+	}
+	var wildcardCertificates []*Certificate
+	for i := range wildcardCertSecrets.Items {
+		certSecret := &wildcardCertSecrets.Items[i]
+		certificate, err := buildCertificate(certSecret, certSecret.Name)
+		if err != nil {
+			return nil, err
+		}
+		if certificate != nil {
+			wildcardCertificates = append(wildcardCertificates, certificate)
+		}
+	}
 	for _, appService := range appServices.Items {
-		appConfig, err := buildAppConfig(kubeClient, appService, routerConfig)
+		reportUnknownAnnotations(appService.Annotations, serviceAnnotationKeys, fmt.Sprintf("service %s/%s", appService.Namespace, appService.Name))
+		appConfig, err := buildAppConfig(kubeClient, appService, routerConfig, wildcardCertificates)
 		if err != nil {
 			return nil, err
 		}
@@ -409,6 +686,7 @@ func build(kubeClient *kubernetes.Clientset, routerDeployment *appv1.Deployment,
 		return nil, err
 	}
 	addRootLocations(routerConfig.AppConfigs)
+	addAccessLocations(routerConfig)
 	if builderService != nil {
 		builderConfig, err := buildBuilderConfig(builderService)
 		if err != nil {
@@ -418,9 +696,77 @@ func build(kubeClient *kubernetes.Clientset, routerDeployment *appv1.Deployment,
 			routerConfig.BuilderConfig = builderConfig
 		}
 	}
+	// Streams listed in the config map take precedence over those claimed by service annotations.
+	var streamConfigs []*StreamConfig
+	if streamsConfigMap != nil {
+		streamConfigs, err = buildConfigMapStreamConfigs(streamsConfigMap)
+		if err != nil {
+			return nil, err
+		}
+		for _, streamConfig := range streamConfigs {
+			tokens := strings.SplitN(streamConfig.Name, "/", 2)
+			service, err := getService(kubeClient, tokens[1], tokens[0])
+			if err != nil {
+				return nil, err
+			}
+			if service == nil {
+				log.Printf("WARN: The service %s listed in the streams config map was not found.\n", streamConfig.Name)
+				continue
+			}
+			streamConfig.ServiceIP = service.Spec.ClusterIP
+		}
+	}
+	for _, appService := range appServices.Items {
+		streamConfig, err := buildStreamConfig(appService)
+		if err != nil {
+			return nil, err
+		}
+		if streamConfig != nil {
+			streamConfigs = append(streamConfigs, streamConfig)
+		}
+	}
+	routerConfig.StreamConfigs = claimStreamPorts(streamConfigs, routerConfig.BuilderConfig != nil)
 	return routerConfig, nil
 }
 
+// claimStreamPorts returns the streams that have a service to proxy to, dropping any router ports
+// that are reserved or were already claimed by a preceding stream.
+func claimStreamPorts(streamConfigs []*StreamConfig, builderEnabled bool) []*StreamConfig {
+	claimedPorts := map[string]string{
+		"8080": "the router",
+		"6443": "the router",
+		"9090": "the router",
+		"9091": "the router",
+	}
+	if builderEnabled {
+		claimedPorts["2222"] = "the builder"
+	}
+	var claimedStreamConfigs []*StreamConfig
+	for _, streamConfig := range streamConfigs {
+		if streamConfig.ServiceIP == "" {
+			continue
+		}
+		ports := make(map[string]string, len(streamConfig.Ports))
+		for routerPort, servicePort := range streamConfig.Ports {
+			if port, err := strconv.Atoi(routerPort); err != nil || port < 1024 {
+				log.Printf("WARN: Router port %s requested by %s is privileged, and the router can't listen on it; skipping it.\n", routerPort, streamConfig.Name)
+				continue
+			}
+			if claimant, ok := claimedPorts[routerPort]; ok {
+				log.Printf("WARN: Router port %s requested by %s is already claimed by %s; skipping it.\n", routerPort, streamConfig.Name, claimant)
+				continue
+			}
+			claimedPorts[routerPort] = streamConfig.Name
+			ports[routerPort] = servicePort
+		}
+		if len(ports) > 0 {
+			streamConfig.Ports = ports
+			claimedStreamConfigs = append(claimedStreamConfigs, streamConfig)
+		}
+	}
+	return claimedStreamConfigs
+}
+
 func appByDomain(appConfigs []*AppConfig, domain string) *AppConfig {
 	for _, app := range appConfigs {
 		for _, appDomain := range app.Domains {
@@ -456,12 +802,180 @@ func addRootLocations(appConfigs []*AppConfig) {
 	}
 }
 
-func buildRouterConfig(routerDeployment *appv1.Deployment, platformCertSecret *corev1.Secret, dhParamSecret *corev1.Secret) (*RouterConfig, error) {
+// addAccessLocations attaches each app's path-scoped access rules to the location serving that
+// path, adding a location for the path if the app doesn't already have one.
+func addAccessLocations(routerConfig *RouterConfig) {
+	for _, app := range routerConfig.AppConfigs {
+		whitelist, whitelisted := serverWhitelist(routerConfig, app)
+		paths := make(map[string]bool)
+		for path := range app.PathAccess.Allow {
+			paths[path] = true
+		}
+		for path := range app.PathAccess.Deny {
+			paths[path] = true
+		}
+		for path := range app.PathAccess.BasicAuth {
+			paths[path] = true
+		}
+		for path := range app.PathAccess.BasicAuths {
+			paths[path] = true
+		}
+		// Locations are added in a stable order, so that an unchanged app doesn't change the
+		// configuration and cause a reload.
+		sortedPaths := make([]string, 0, len(paths))
+		for path := range paths {
+			sortedPaths = append(sortedPaths, path)
+		}
+		sort.Strings(sortedPaths)
+		for _, path := range sortedPaths {
+			var location *Location
+			for _, loc := range app.Locations {
+				if loc.Path == path {
+					location = loc
+					break
+				}
+			}
+			if location == nil {
+				location = &Location{App: app, Path: path}
+				app.Locations = append(app.Locations, location)
+			}
+			access := &LocationAccess{
+				Allow:     strings.Fields(app.PathAccess.Allow[path]),
+				Deny:      strings.Fields(app.PathAccess.Deny[path]),
+				BasicAuth: app.PathAccess.BasicAuths[path],
+			}
+			// Nginx doesn't apply the server's whitelist within a location that has access rules of
+			// its own, so a path that only denies addresses must carry the whitelist along.
+			if whitelisted && len(access.Allow) == 0 && len(access.Deny) != 0 {
+				access.Allow = append(access.Allow, whitelist...)
+				access.DenyAll = true
+			}
+			// A path requiring basic auth whose htpasswd couldn't be loaded is closed to everyone
+			// rather than served without authentication.
+			if _, ok := app.PathAccess.BasicAuth[path]; ok && access.BasicAuth == nil {
+				access.Allow = []string{}
+				access.DenyAll = true
+			}
+			location.Access = access
+		}
+	}
+}
+
+// serverWhitelist returns the addresses allowed to access the app's servers and whether access
+// to them is restricted at all, as it is even by an empty whitelist if whitelists are enforced.
+func serverWhitelist(routerConfig *RouterConfig, app *AppConfig) ([]string, bool) {
+	if !routerConfig.EnforceWhitelists && len(routerConfig.DefaultWhitelist) == 0 && len(app.Whitelist) == 0 {
+		return nil, false
+	}
+	var whitelist []string
+	if len(app.Whitelist) == 0 || routerConfig.WhitelistMode == "extend" {
+		whitelist = append(whitelist, routerConfig.DefaultWhitelist...)
+	}
+	whitelist = append(whitelist, app.Whitelist...)
+	return whitelist, true
+}
+
+// mapAnnotations populates the model from the annotations of the given k8s object. Annotations
+// with values that don't satisfy their constraints are rejected and reported, leaving the
+// corresponding fields at their defaults.
+func mapAnnotations(annotations map[string]string, context string, out interface{}, object string) error {
+	err := modelerFor(out).MapToModelCollectingErrors(annotations, context, out)
+	validationErrs, ok := err.(modelerUtility.ModelValidationErrors)
+	if !ok {
+		return err
+	}
+	for _, validationErr := range validationErrs {
+		log.Printf("WARN: Rejected an annotation on %s -- %s; using the default value instead.\n", object, validationErr)
+	}
+	return nil
+}
+
+// reportUnknownAnnotations warns about each annotation of the given k8s object that looks like it
+// is meant for the router, but isn't among the known keys, suggesting what may have been meant.
+func reportUnknownAnnotations(annotations map[string]string, knownKeys []string, object string) {
+	for _, unknownErr := range modeler.UnknownKeys(annotations, knownKeys) {
+		if unknownErr.Suggestion == "" {
+			log.Printf("WARN: Ignored the unknown annotation %s on %s.\n", unknownErr.Key, object)
+		} else {
+			log.Printf("WARN: Ignored the unknown annotation %s on %s; did you mean %s?\n", unknownErr.Key, object, unknownErr.Suggestion)
+		}
+	}
+}
+
+// RouterAnnotations returns the annotations on the router's deployment that the router
+// configuration would be built from, omitting those for default values.
+func RouterAnnotations(routerConfig *RouterConfig) (map[string]string, error) {
+	defaults, err := newRouterConfig()
+	if err != nil {
+		return nil, err
+	}
+	return modeler.ModelToMap("nginx", routerConfig, defaults)
+}
+
+// AppAnnotations returns the annotations on an app's service that its configuration would be built
+// from, omitting those for values it would have anyway, whether by default or inherited from the
+// router configuration.
+func AppAnnotations(routerConfig *RouterConfig, appConfig *AppConfig) (map[string]string, error) {
+	defaults, err := newAppConfig(routerConfig)
+	if err != nil {
+		return nil, err
+	}
+	return appModeler.ModelToMap("", appConfig, defaults)
+}
+
+// AnnotationGroup describes the annotations understood on one kind of k8s object, such as the
+// router's deployment, with the defaults they have when not given.
+type AnnotationGroup struct {
+	Component    string
+	ResourceType string
+	Fields       []modelerUtility.FieldDescription
+}
+
+// AnnotationReference describes the annotations understood on the router's deployment, on
+// routable application services, including those configuring streams, and on the builder's
+// service. Defaults of application services are those under the default router configuration.
+func AnnotationReference() ([]*AnnotationGroup, error) {
+	routerConfig, err := newRouterConfig()
+	if err != nil {
+		return nil, err
+	}
+	appConfig, err := newAppConfig(routerConfig)
+	if err != nil {
+		return nil, err
+	}
+	groups := []*AnnotationGroup{
+		{Component: "deis-router", ResourceType: "deployment"},
+		{Component: "routable application", ResourceType: "service"},
+		{Component: "deis-builder", ResourceType: "service"},
+	}
+	models := []map[string]interface{}{
+		{"nginx": routerConfig},
+		{"": appConfig, "stream": newStreamConfig()},
+		{"nginx": newBuilderConfig()},
+	}
+	for i, group := range groups {
+		for context, model := range models[i] {
+			fields, err := modelerFor(model).Describe(context, model)
+			if err != nil {
+				return nil, err
+			}
+			group.Fields = append(group.Fields, fields...)
+		}
+		sort.Slice(group.Fields, func(j, k int) bool {
+			return group.Fields[j].Key < group.Fields[k].Key
+		})
+	}
+	return groups, nil
+}
+
+func buildRouterConfig(routerDeployment *appv1.Deployment, platformCertSecret *corev1.Secret, dhParamSecret *corev1.Secret, sessionTicketKeysSecret *corev1.Secret) (*RouterConfig, error) {
 	routerConfig, err := newRouterConfig()
 	if err != nil {
 		return nil, err
 	}
-	err = modeler.MapToModel(routerDeployment.Annotations, "nginx", routerConfig)
+	object := fmt.Sprintf("deployment %s/%s", routerDeployment.Namespace, routerDeployment.Name)
+	reportUnknownAnnotations(routerDeployment.Annotations, routerAnnotationKeys, object)
+	err = mapAnnotations(routerDeployment.Annotations, "nginx", routerConfig, object)
 	if err != nil {
 		return nil, err
 	}
@@ -470,7 +984,9 @@ func buildRouterConfig(routerDeployment *appv1.Deployment, platformCertSecret *c
 		if err != nil {
 			return nil, err
 		}
-		routerConfig.PlatformCertificate = platformCertificate
+		if usableCertificate(platformCertificate, "", "platform") {
+			routerConfig.PlatformCertificate = platformCertificate
+		}
 	}
 	if dhParamSecret != nil {
 		dhParam, err := buildDHParam(dhParamSecret)
@@ -479,10 +995,13 @@ func buildRouterConfig(routerDeployment *appv1.Deployment, platformCertSecret *c
 		}
 		routerConfig.SSLConfig.DHParam = dhParam
 	}
+	if sessionTicketKeysSecret != nil {
+		routerConfig.SSLConfig.SessionTicketKeys = buildSessionTicketKeys(sessionTicketKeysSecret)
+	}
 	return routerConfig, nil
 }
 
-func buildAppConfig(kubeClient *kubernetes.Clientset, service corev1.Service, routerConfig *RouterConfig) (*AppConfig, error) {
+func buildAppConfig(kubeClient *kubernetes.Clientset, service corev1.Service, routerConfig *RouterConfig, wildcardCertificates []*Certificate) (*AppConfig, error) {
 	appConfig, err := newAppConfig(routerConfig)
 	if err != nil {
 		return nil, err
@@ -498,7 +1017,9 @@ func buildAppConfig(kubeClient *kubernetes.Clientset, service corev1.Service, ro
 	if appConfig.Name != service.Namespace {
 		appConfig.Name = service.Namespace + "/" + appConfig.Name
 	}
-	err = modeler.MapToModel(service.Annotations, "", appConfig)
+	appConfig.Namespace = service.Namespace
+	appConfig.ServiceName = service.Name
+	err = mapAnnotations(service.Annotations, "", appConfig, fmt.Sprintf("service %s/%s", service.Namespace, service.Name))
 	if err != nil {
 		return nil, err
 	}
@@ -512,25 +1033,148 @@ func buildAppConfig(kubeClient *kubernetes.Clientset, service corev1.Service, ro
 	// For each that is a FQDN, we'll look to see if a corresponding cert-bearing secret also
 	// exists.  If so, that will be used.  If a domain isn't an FQDN we will use the default cert--
 	// even if that is nil.
+	// Certs mapped to domains the app doesn't route may still cover some of the domains it does.
+	mappedCertificates := make(map[string]*Certificate, len(appConfig.CertMappings))
+	var referencedCertificates []*Certificate
+	for _, certMapping := range sortedValues(appConfig.CertMappings) {
+		if _, ok := mappedCertificates[certMapping]; ok {
+			continue
+		}
+		certificate, err := getCertificate(kubeClient, certMapping, service.Namespace)
+		if err != nil {
+			return nil, err
+		}
+		mappedCertificates[certMapping] = certificate
+		if certificate != nil {
+			referencedCertificates = append(referencedCertificates, certificate)
+		}
+	}
+	acmeConfig := appConfig.ACMEConfig
+	acmeConfig.Namespace = service.Namespace
 	for _, domain := range appConfig.Domains {
-		if strings.Contains(domain, ".") {
-			// Look for a cert-bearing secret for this domain.
-			if certMapping, ok := appConfig.CertMappings[domain]; ok {
-				secretName := fmt.Sprintf("%s-cert", certMapping)
-				certSecret, err := getSecret(kubeClient, secretName, service.Namespace)
-				if err != nil {
-					return nil, err
-				}
-				if certSecret != nil {
-					certificate, err := buildCertificate(certSecret, domain)
-					if err != nil {
-						return nil, err
-					}
-					appConfig.Certificates[domain] = certificate
-				}
+		if !strings.Contains(domain, ".") {
+			appConfig.Certificates[domain] = routerConfig.PlatformCertificate
+			continue
+		}
+		// Look for a cert-bearing secret explicitly mapped to this domain.
+		certMapping, mapped := appConfig.CertMappings[domain]
+		if usableCertificate(mappedCertificates[certMapping], domain, certMapping) {
+			appConfig.Certificates[domain] = mappedCertificates[certMapping]
+			continue
+		}
+		// Otherwise, look for a cert referenced by the app or a wildcard cert from the router's
+		// namespace whose SANs cover this domain.
+		if certificate := matchCertificate(domain, referencedCertificates, wildcardCertificates); certificate != nil {
+			appConfig.Certificates[domain] = certificate
+			continue
+		}
+		// Failing that, use the cert obtained from the ACME certificate authority, if the app opted
+		// in. Wildcard domains can't be validated using HTTP-01.
+		if !mapped && acmeConfig.Enabled && !strings.HasPrefix(domain, "*.") {
+			acmeConfig.Domains = append(acmeConfig.Domains, domain)
+			certificate, err := getCertificate(kubeClient, ACMECertName(domain), service.Namespace)
+			if err != nil {
+				return nil, err
+			}
+			if usableCertificate(certificate, domain, ACMECertName(domain)) {
+				appConfig.Certificates[domain] = certificate
+				continue
+			}
+		}
+		// As a last resort, use the cert generated by the router, if it generates any.
+		if !mapped && routerConfig.SelfSignedConfig.Enabled {
+			appConfig.SelfSignedDomains = append(appConfig.SelfSignedDomains, domain)
+			certificate, err := getCertificate(kubeClient, GeneratedCertName(domain), service.Namespace)
+			if err != nil {
+				return nil, err
+			}
+			if usableCertificate(certificate, domain, GeneratedCertName(domain)) {
+				certificate.Generated = true
+				appConfig.Certificates[domain] = certificate
 			}
+		}
+	}
+	// Look up the CA-bearing secret used for verifying client certificates.
+	clientCertConfig := appConfig.ClientCertConfig
+	if clientCertConfig.CAMapping != "" {
+		secretName := fmt.Sprintf("%s-ca", clientCertConfig.CAMapping)
+		caSecret, err := getSecret(kubeClient, secretName, service.Namespace)
+		if err != nil {
+			return nil, err
+		}
+		if caSecret != nil {
+			ca, crl, err := buildCA(caSecret, secretName)
+			if err != nil {
+				return nil, err
+			}
+			clientCertConfig.Name = fmt.Sprintf("%s-%s", service.Namespace, clientCertConfig.CAMapping)
+			clientCertConfig.CA = ca
+			clientCertConfig.CRL = crl
+		}
+	}
+	// Client certificates can't be verified without a CA.
+	clientCertVerifiable := clientCertConfig.CA != "" || (clientCertConfig.Verify != "on" && clientCertConfig.Verify != "optional")
+	// Look up the secrets used for securing connections to the back end.
+	backendConfig := appConfig.BackendConfig
+	backendConfig.Name = fmt.Sprintf("%s-%s", service.Namespace, service.Name)
+	if backendConfig.Port == "" {
+		if appConfig.TLSPassthrough || backendConfig.Protocol == "https" || backendConfig.Protocol == "grpcs" {
+			backendConfig.Port = "443"
 		} else {
-			appConfig.Certificates[domain] = routerConfig.PlatformCertificate
+			backendConfig.Port = "80"
+		}
+	}
+	backendVerifiable := true
+	if backendConfig.CAMapping != "" {
+		secretName := fmt.Sprintf("%s-ca", backendConfig.CAMapping)
+		caSecret, err := getSecret(kubeClient, secretName, service.Namespace)
+		if err != nil {
+			return nil, err
+		}
+		if caSecret != nil {
+			ca, crl, err := buildCA(caSecret, secretName)
+			if err != nil {
+				return nil, err
+			}
+			backendConfig.CA = ca
+			backendConfig.CRL = crl
+		}
+		backendVerifiable = backendConfig.CA != ""
+	}
+	if backendConfig.CertMapping != "" {
+		secretName := fmt.Sprintf("%s-cert", backendConfig.CertMapping)
+		certSecret, err := getSecret(kubeClient, secretName, service.Namespace)
+		if err != nil {
+			return nil, err
+		}
+		if certSecret != nil {
+			context := fmt.Sprintf("%s back end", appConfig.Name)
+			certificate, err := buildCertificate(certSecret, context)
+			if err != nil {
+				return nil, err
+			}
+			if usableCertificate(certificate, "", context) {
+				backendConfig.ClientCertificate = certificate
+			}
+		}
+	}
+	// Look up the htpasswd-bearing secret for each path that requires basic auth.
+	for path, authMapping := range appConfig.PathAccess.BasicAuth {
+		secretName := fmt.Sprintf("%s-auth", authMapping)
+		authSecret, err := getSecret(kubeClient, secretName, service.Namespace)
+		if err != nil {
+			return nil, err
+		}
+		if authSecret != nil {
+			basicAuth, err := buildBasicAuth(authSecret, fmt.Sprintf("%s-%s", service.Namespace, authMapping))
+			if err != nil {
+				return nil, err
+			}
+			if basicAuth != nil {
+				appConfig.PathAccess.BasicAuths[path] = basicAuth
+			}
+		} else {
+			log.Printf("WARN: The k8s secret %s/%s required for basic auth on path %s of %s was not found; denying all requests to the path.\n", service.Namespace, secretName, path, appConfig.Name)
 		}
 	}
 	appConfig.ServiceIP = service.Spec.ClusterIP
@@ -540,19 +1184,84 @@ func buildAppConfig(kubeClient *kubernetes.Clientset, service corev1.Service, ro
 		return nil, err
 	}
 	appConfig.Available = len(endpoints.Subsets) > 0 && len(endpoints.Subsets[0].Addresses) > 0
+	// If the back end is meant to be verified but can't be, treat it as unavailable rather than
+	// proxying to it unverified.
+	if !backendVerifiable {
+		log.Printf("WARN: Verification of the back end for %s requires a CA, but none was found; treating it as unavailable.\n", appConfig.Name)
+		appConfig.Available = false
+	}
+	// Likewise, if clients are meant to be verified but can't be, treat the app as unavailable
+	// rather than serving it to unverified clients.
+	if !clientCertVerifiable {
+		log.Printf("WARN: Client certificate verification for %s requires a CA, but none was found; treating it as unavailable.\n", appConfig.Name)
+		appConfig.Available = false
+	}
+	// gRPC clients can only reach the router over HTTP/2, which is only offered on the TLS listener.
+	if !routerConfig.HTTP2Enabled && (backendConfig.Protocol == "grpc" || backendConfig.Protocol == "grpcs" || backendConfig.Protocol == "h2c") {
+		log.Printf("WARN: The %s back end for %s requires HTTP2 to be enabled on the router; treating it as unavailable.\n", backendConfig.Protocol, appConfig.Name)
+		appConfig.Available = false
+	}
 	return appConfig, nil
 }
 
 func buildBuilderConfig(service *corev1.Service) (*BuilderConfig, error) {
 	builderConfig := newBuilderConfig()
 	builderConfig.ServiceIP = service.Spec.ClusterIP
-	err := modeler.MapToModel(service.Annotations, "nginx", builderConfig)
+	err := mapAnnotations(service.Annotations, "nginx", builderConfig, fmt.Sprintf("service %s/%s", service.Namespace, service.Name))
 	if err != nil {
 		return nil, err
 	}
 	return builderConfig, nil
 }
 
+// buildStreamConfig returns the stream for a routable service, or nil if the service doesn't
+// claim any router ports.
+func buildStreamConfig(service corev1.Service) (*StreamConfig, error) {
+	streamConfig := newStreamConfig()
+	err := mapAnnotations(service.Annotations, "stream", streamConfig, fmt.Sprintf("service %s/%s", service.Namespace, service.Name))
+	if err != nil {
+		return nil, err
+	}
+	if len(streamConfig.Ports) == 0 {
+		return nil, nil
+	}
+	streamConfig.Name = fmt.Sprintf("%s/%s", service.Namespace, service.Name)
+	streamConfig.ServiceIP = service.Spec.ClusterIP
+	return streamConfig, nil
+}
+
+// buildConfigMapStreamConfigs returns a stream for each entry of the streams config map. Keys are
+// router ports, optionally suffixed with "/udp", and values are of the form
+// "<namespace>/<service>:<port>".
+func buildConfigMapStreamConfigs(configMap *corev1.ConfigMap) ([]*StreamConfig, error) {
+	keyConstraint := regexp.MustCompile("^([1-9]\\d{0,3}|[1-5]\\d{4}|6[0-4]\\d{3}|65[0-4]\\d{2}|655[0-2]\\d|6553[0-5])(/(tcp|udp))?$")
+	valueConstraint := regexp.MustCompile("(?i)^([a-z0-9]+(-*[a-z0-9]+)*)/([a-z0-9]+(-*[a-z0-9]+)*):([1-9]\\d{0,3}|[1-5]\\d{4}|6[0-4]\\d{3}|65[0-4]\\d{2}|655[0-2]\\d|6553[0-5])$")
+	// Iterate in a stable order so that port conflicts are resolved the same way every time.
+	keys := make([]string, 0, len(configMap.Data))
+	for key := range configMap.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var streamConfigs []*StreamConfig
+	for _, key := range keys {
+		value := strings.TrimSpace(configMap.Data[key])
+		keyMatch := keyConstraint.FindStringSubmatch(key)
+		valueMatch := valueConstraint.FindStringSubmatch(value)
+		if keyMatch == nil || valueMatch == nil {
+			log.Printf("WARN: Skipping invalid entry \"%s: %s\" in the streams config map.\n", key, value)
+			continue
+		}
+		streamConfig := newStreamConfig()
+		streamConfig.Name = fmt.Sprintf("%s/%s", valueMatch[1], valueMatch[3])
+		streamConfig.Ports = map[string]string{keyMatch[1]: valueMatch[5]}
+		if keyMatch[3] != "" {
+			streamConfig.Protocol = keyMatch[3]
+		}
+		streamConfigs = append(streamConfigs, streamConfig)
+	}
+	return streamConfigs, nil
+}
+
 func buildCertificate(certSecret *corev1.Secret, context string) (*Certificate, error) {
 	cert, ok := certSecret.Data["tls.crt"]
 	// If no cert is found in the secret, warn and return nil
@@ -568,13 +1277,341 @@ func buildCertificate(certSecret *corev1.Secret, context string) (*Certificate,
 	}
 	certStr := string(cert[:])
 	keyStr := string(key[:])
-	return newCertificate(certStr, keyStr), nil
+	certificate := newCertificate(certStr, keyStr)
+	certificate.TrustedChain = buildTrustedChain(certStr, string(certSecret.Data["ca.crt"]))
+	certificate.OCSPResponse = string(certSecret.Data["tls.ocsp"])
+	// The secret may convey certificates using other key types alongside the primary one.
+	for _, keyType := range keyTypes {
+		cert, certOk := certSecret.Data[fmt.Sprintf("tls-%s.crt", keyType)]
+		key, keyOk := certSecret.Data[fmt.Sprintf("tls-%s.key", keyType)]
+		if !certOk && !keyOk {
+			continue
+		}
+		if !certOk || !keyOk {
+			log.Printf("WARN: The k8s secret intended to convey the %s certificate contained only one of the entries \"tls-%s.crt\" and \"tls-%s.key\".\n", context, keyType, keyType)
+			continue
+		}
+		certificate.Alternates = append(certificate.Alternates, newCertificate(string(cert), string(key)))
+		certificate.TrustedChain += buildTrustedChain(string(cert), "")
+	}
+	dropAmbiguousOCSPResponse(certificate, context)
+	dropInvalidOCSPResponse(certificate, context, time.Now())
+	return certificate, nil
+}
+
+// dropAmbiguousOCSPResponse discards the pre-fetched OCSP response of a certificate that has
+// alternates. A response can only be stapled to the certificate it was issued for, but nginx
+// would staple it to every certificate of a server.
+func dropAmbiguousOCSPResponse(certificate *Certificate, context string) {
+	if certificate.OCSPResponse != "" && len(certificate.Alternates) > 0 {
+		log.Printf("WARN: The %s certificate has alternate certificates, so its pre-fetched OCSP response is ignored.\n", context)
+		certificate.OCSPResponse = ""
+	}
+}
+
+// dropInvalidOCSPResponse discards the pre-fetched OCSP response of a certificate unless it is a
+// current response for the certificate, signed on behalf of its issuer, which is the first
+// certificate of its trusted chain. Nginx would refuse to load any other response, leaving the
+// router stuck with its old configuration.
+func dropInvalidOCSPResponse(certificate *Certificate, context string, now time.Time) {
+	if certificate.OCSPResponse == "" {
+		return
+	}
+	if err := validateOCSPResponse(certificate, now); err != nil {
+		log.Printf("WARN: The pre-fetched OCSP response of the %s certificate is ignored: %v\n", context, err)
+		certificate.OCSPResponse = ""
+	}
+}
+
+func validateOCSPResponse(certificate *Certificate, now time.Time) error {
+	leafBlock, _ := pem.Decode([]byte(certificate.Cert))
+	if leafBlock == nil {
+		return fmt.Errorf("the certificate isn't PEM-encoded")
+	}
+	leaf, err := x509.ParseCertificate(leafBlock.Bytes)
+	if err != nil {
+		return err
+	}
+	issuerBlock, _ := pem.Decode([]byte(certificate.TrustedChain))
+	if issuerBlock == nil {
+		return fmt.Errorf("the certificate's issuer is unknown, so the response can't be verified")
+	}
+	issuer, err := x509.ParseCertificate(issuerBlock.Bytes)
+	if err != nil {
+		return err
+	}
+	response, err := ocsp.ParseResponseForCert([]byte(certificate.OCSPResponse), leaf, issuer)
+	if err != nil {
+		return err
+	}
+	if !response.NextUpdate.IsZero() && now.After(response.NextUpdate) {
+		return fmt.Errorf("the response expired at %s", response.NextUpdate.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// buildTrustedChain returns the certificates following the leaf in the given PEM-encoded chain,
+// followed by the given PEM-encoded CA certificate.
+func buildTrustedChain(cert string, ca string) string {
+	var trustedChain []byte
+	rest := []byte(cert)
+	for leaf := true; ; leaf = false {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if !leaf {
+			trustedChain = append(trustedChain, pem.EncodeToMemory(block)...)
+		}
+	}
+	return string(trustedChain) + ca
+}
+
+// getCertificate returns the certificate conveyed by the cert-bearing secrets for the given
+// mapping, or nil if no such secret exists. A mapping may name several secrets separated by "|",
+// each conveying a certificate using a different key type; the first one found is the primary
+// certificate and the others become its alternates.
+func getCertificate(kubeClient *kubernetes.Clientset, certMapping string, ns string) (*Certificate, error) {
+	var certificate *Certificate
+	for _, name := range strings.Split(certMapping, "|") {
+		certSecret, err := getSecret(kubeClient, fmt.Sprintf("%s-cert", name), ns)
+		if err != nil {
+			return nil, err
+		}
+		if certSecret == nil {
+			continue
+		}
+		secretCertificate, err := buildCertificate(certSecret, name)
+		if err != nil {
+			return nil, err
+		}
+		if secretCertificate == nil {
+			continue
+		}
+		if certificate == nil {
+			certificate = secretCertificate
+			continue
+		}
+		alternates := secretCertificate.Alternates
+		secretCertificate.Alternates = nil
+		certificate.TrustedChain += secretCertificate.TrustedChain
+		secretCertificate.TrustedChain = ""
+		certificate.Alternates = append(append(certificate.Alternates, secretCertificate), alternates...)
+	}
+	if certificate != nil {
+		dropAmbiguousOCSPResponse(certificate, certMapping)
+	}
+	return certificate, nil
+}
+
+// matchCertificate returns the first of the given certificates whose SANs cover the domain, or
+// nil if there is none. Invalid certificates are skipped.
+func matchCertificate(domain string, certificateLists ...[]*Certificate) *Certificate {
+	for _, certificates := range certificateLists {
+		for _, certificate := range certificates {
+			if validateCertificate(certificate, domain, time.Now()) == nil {
+				return certificate
+			}
+		}
+	}
+	return nil
+}
+
+// usableCertificate returns whether the certificate is present and valid for the domain, warning
+// about it if it is present but invalid.
+func usableCertificate(certificate *Certificate, domain string, context string) bool {
+	if certificate == nil {
+		return false
+	}
+	err := validateCertificate(certificate, domain, time.Now())
+	if err != nil {
+		log.Printf("WARN: The %s certificate can't be used: %v\n", context, err)
+		return false
+	}
+	return true
+}
+
+// validateCertificate returns an error unless the certificate and each of its alternates is valid
+// for the domain, and no two of them use the same key type. It records the certificates' key
+// types and when the certificate expires, which is when the first of them expires.
+func validateCertificate(certificate *Certificate, domain string, now time.Time) error {
+	if err := validateKeyPair(certificate, domain, now); err != nil {
+		return err
+	}
+	seenKeyTypes := map[string]bool{certificate.KeyType: true}
+	for i, alternate := range certificate.Alternates {
+		if err := validateKeyPair(alternate, domain, now); err != nil {
+			return fmt.Errorf("alternate certificate %d is invalid: %v", i+1, err)
+		}
+		if seenKeyTypes[alternate.KeyType] {
+			return fmt.Errorf("more than one certificate uses an %s key", alternate.KeyType)
+		}
+		seenKeyTypes[alternate.KeyType] = true
+		if alternate.NotAfter.Before(certificate.NotAfter) {
+			certificate.NotAfter = alternate.NotAfter
+		}
+	}
+	return nil
+}
+
+// validateKeyPair returns an error unless the certificate's key matches, its chain is in order,
+// it is currently valid, and, if a domain is given, its SANs cover that domain. Its alternates
+// aren't considered. It records the certificate's key type and when it expires.
+func validateKeyPair(certificate *Certificate, domain string, now time.Time) error {
+	keyPair, err := tls.X509KeyPair([]byte(certificate.Cert), []byte(certificate.Key))
+	if err != nil {
+		return err
+	}
+	switch keyPair.PrivateKey.(type) {
+	case *rsa.PrivateKey:
+		certificate.KeyType = "rsa"
+	case *ecdsa.PrivateKey:
+		certificate.KeyType = "ecdsa"
+	default:
+		return fmt.Errorf("its key type isn't supported; use an RSA or ECDSA key")
+	}
+	var chain []*x509.Certificate
+	rest := []byte(certificate.Cert)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return err
+		}
+		chain = append(chain, cert)
+	}
+	leaf := chain[0]
+	certificate.NotAfter = leaf.NotAfter
+	for i := 0; i < len(chain)-1; i++ {
+		if err := chain[i].CheckSignatureFrom(chain[i+1]); err != nil {
+			return fmt.Errorf("certificate %d in the chain isn't signed by the one following it: %v", i+1, err)
+		}
+	}
+	if now.Before(leaf.NotBefore) {
+		return fmt.Errorf("it isn't valid until %s", leaf.NotBefore.UTC().Format(time.RFC3339))
+	}
+	if now.After(leaf.NotAfter) {
+		return fmt.Errorf("it expired on %s", leaf.NotAfter.UTC().Format(time.RFC3339))
+	}
+	if domain != "" && !certificateCovers(certificate, domain) {
+		return fmt.Errorf("its SANs %v don't cover %s", leaf.DNSNames, domain)
+	}
+	return nil
+}
+
+// certificateCovers returns whether one of the DNS names in the certificate's SANs is the domain
+// itself or a wildcard matching it. A wildcard only matches a single label, as in browsers.
+func certificateCovers(certificate *Certificate, domain string) bool {
+	block, _ := pem.Decode([]byte(certificate.Cert))
+	if block == nil {
+		return false
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return false
+	}
+	domain = strings.ToLower(domain)
+	for _, name := range cert.DNSNames {
+		name = strings.ToLower(name)
+		if name == domain {
+			return true
+		}
+		if strings.HasPrefix(name, "*.") && !strings.HasPrefix(domain, "*.") {
+			if i := strings.Index(domain, "."); i > 0 && domain[i:] == name[1:] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sortedValues returns the values of the map, ordered by their keys.
+func sortedValues(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	values := make([]string, 0, len(keys))
+	for _, key := range keys {
+		values = append(values, m[key])
+	}
+	return values
+}
+
+func buildCA(caSecret *corev1.Secret, context string) (string, string, error) {
+	ca, ok := caSecret.Data["ca.crt"]
+	// If no CA is found in the secret, warn and return ""
+	if !ok {
+		log.Printf("WARN: The k8s secret %s intended to convey a CA contained no entry \"ca.crt\".\n", context)
+		return "", "", nil
+	}
+	// The CRL is optional.
+	crl := caSecret.Data["ca.crl"]
+	return string(ca), string(crl), nil
+}
+
+func buildBasicAuth(authSecret *corev1.Secret, name string) (*BasicAuth, error) {
+	htpasswd, ok := authSecret.Data["htpasswd"]
+	// If no htpasswd is found in the secret, warn and return nil, which closes the path
+	if !ok {
+		log.Printf("WARN: The k8s secret intended to convey the %s htpasswd contained no entry \"htpasswd\"; denying all requests to the path.\n", name)
+		return nil, nil
+	}
+	return newBasicAuth(name, string(htpasswd)), nil
+}
+
+// buildSessionTicketKeys returns the 80-byte session ticket keys conveyed, newest first, by the
+// secret's "keys" entry, followed by the key staged in its "staged" entry, if any. Only the first
+// key encrypts tickets; the staged key only decrypts them until it is promoted.
+func buildSessionTicketKeys(sessionTicketKeysSecret *corev1.Secret) []string {
+	keys, ok := sessionTicketKeysSecret.Data["keys"]
+	if !ok || len(keys) == 0 || len(keys)%SessionTicketKeySize != 0 {
+		log.Printf("WARN: The k8s secret intended to convey the session ticket keys contained no entry \"keys\" made up of %d-byte keys.\n", SessionTicketKeySize)
+		return nil
+	}
+	var sessionTicketKeys []string
+	for i := 0; i < len(keys); i += SessionTicketKeySize {
+		sessionTicketKeys = append(sessionTicketKeys, string(keys[i:i+SessionTicketKeySize]))
+	}
+	if staged := sessionTicketKeysSecret.Data["staged"]; len(staged) == SessionTicketKeySize {
+		sessionTicketKeys = append(sessionTicketKeys, string(staged))
+	}
+	return sessionTicketKeys
+}
+
+// buildACMEChallenges returns the responses to pending ACME HTTP-01 challenges, keyed by token,
+// that the replica obtaining certificates published in the config map for all replicas to serve.
+func buildACMEChallenges(configMap *corev1.ConfigMap) map[string]string {
+	tokenConstraint := regexp.MustCompile("^[A-Za-z0-9_-]+$")
+	responseConstraint := regexp.MustCompile("^[A-Za-z0-9_-]+\\.[A-Za-z0-9_-]+$")
+	challenges := make(map[string]string, len(configMap.Data))
+	for token, response := range configMap.Data {
+		if !tokenConstraint.MatchString(token) || !responseConstraint.MatchString(response) {
+			log.Printf("WARN: Skipping invalid entry \"%s: %s\" in the ACME challenges config map.\n", token, response)
+			continue
+		}
+		challenges[token] = response
+	}
+	return challenges
 }
 
 func buildDHParam(dhParamSecret *corev1.Secret) (string, error) {
 	dhParam, ok := dhParamSecret.Data["dhparam"]
-	// If no dhparam is found in the secret, warn and return ""
+	// If no dhparam is found in the secret, warn and return "", unless a replica is still
+	// generating one
 	if !ok {
+		if _, generating := dhParamSecret.Annotations["router.deis.io/dhParamHolder"]; generating {
+			return "", nil
+		}
 		log.Println("WARN: The k8s secret intended to convey the dhparam contained no entry \"dhparam\".")
 		return "", nil
 	}
@@ -1,9 +1,21 @@
 package model
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
+	"golang.org/x/crypto/ocsp"
 	appv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -59,6 +71,7 @@ func TestBuildRouterConfig(t *testing.T) {
 		},
 	}
 
+	platformCert := newTestCertificate(t, "*.example.com")
 	platformCertSecret := corev1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      platformCertName,
@@ -66,8 +79,8 @@ func TestBuildRouterConfig(t *testing.T) {
 		},
 		Type: corev1.SecretTypeOpaque,
 		Data: map[string][]byte{
-			"tls.crt": []byte("foo"),
-			"tls.key": []byte("bar"),
+			"tls.crt": []byte(platformCert.Cert),
+			"tls.key": []byte(platformCert.Key),
 		},
 	}
 
@@ -91,7 +104,6 @@ func TestBuildRouterConfig(t *testing.T) {
 	}
 	sslConfig := newSSLConfig()
 	hstsConfig := newHSTSConfig()
-	platformCert := newCertificate("foo", "bar")
 
 	// A value not set in the deployment annotations (should be default value).
 	expectedConfig.MaxWorkerConnections = "768"
@@ -112,7 +124,7 @@ func TestBuildRouterConfig(t *testing.T) {
 
 	expectedConfig.PlatformCertificate = platformCert
 
-	actualConfig, err := buildRouterConfig(&routerDeployment, &platformCertSecret, &dhParamSecret)
+	actualConfig, err := buildRouterConfig(&routerDeployment, &platformCertSecret, &dhParamSecret, nil)
 	if err != nil {
 		t.Error(err)
 	}
@@ -127,6 +139,39 @@ func TestBuildRouterConfig(t *testing.T) {
 	}
 }
 
+func TestNewAppSSLConfig(t *testing.T) {
+	routerConfig, err := newRouterConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	routerConfig.SSLConfig.Protocols = "TLSv1.2 TLSv1.3"
+	routerConfig.SSLConfig.HSTSConfig.Enabled = true
+	routerConfig.SSLConfig.DHParam = "bizbaz"
+
+	appConfig, err := newAppConfig(routerConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = modeler.MapToModel(map[string]string{"router.deis.io/ssl.protocols": "TLSv1 TLSv1.1 TLSv1.2"}, "", appConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Settings the app doesn't override fall back to the router's.
+	if !appConfig.SSLConfig.HSTSConfig.Enabled {
+		t.Errorf("Expected the app to inherit HSTS from the router.")
+	}
+	if appConfig.SSLConfig.Protocols != "TLSv1 TLSv1.1 TLSv1.2" {
+		t.Errorf("Expected the app's own protocols, got %s", appConfig.SSLConfig.Protocols)
+	}
+	if routerConfig.SSLConfig.Protocols != "TLSv1.2 TLSv1.3" {
+		t.Errorf("Expected the router's protocols to be unaffected by the app's, got %s", routerConfig.SSLConfig.Protocols)
+	}
+	if appConfig.SSLConfig.DHParam != "" {
+		t.Errorf("Expected the app not to carry the router's DH parameters.")
+	}
+}
+
 func TestBuildBuilderConfig(t *testing.T) {
 	// Ensure a Builder Service with annotations returns the expected BuilderConfig.
 	builderService := corev1.Service{
@@ -182,6 +227,139 @@ func TestBuildBuilderConfig(t *testing.T) {
 	}
 }
 
+func TestBuildStreamConfig(t *testing.T) {
+	service := corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "postgres",
+			Namespace: "tools",
+			Annotations: map[string]string{
+				"router.deis.io/stream.ports":     "15432:5432",
+				"router.deis.io/stream.whitelist": "10.0.0.0/8",
+			},
+		},
+		Spec: corev1.ServiceSpec{
+			ClusterIP: "1.2.3.4",
+		},
+	}
+
+	expectedConfig := newStreamConfig()
+	expectedConfig.Name = "tools/postgres"
+	expectedConfig.Ports = map[string]string{"15432": "5432"}
+	expectedConfig.Whitelist = []string{"10.0.0.0/8"}
+	expectedConfig.ServiceIP = "1.2.3.4"
+
+	actualConfig, err := buildStreamConfig(service)
+	if err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(expectedConfig, actualConfig) {
+		t.Errorf("Expected streamConfig %+v, got %+v", expectedConfig, actualConfig)
+	}
+
+	// A service that doesn't claim any router ports has no stream.
+	service.Annotations = map[string]string{}
+	actualConfig, err = buildStreamConfig(service)
+	if err != nil {
+		t.Error(err)
+	}
+	if actualConfig != nil {
+		t.Errorf("Expected no streamConfig, got %+v", actualConfig)
+	}
+}
+
+func TestBuildConfigMapStreamConfigs(t *testing.T) {
+	configMap := &corev1.ConfigMap{
+		Data: map[string]string{
+			"5432":     "tools/postgres:5432",
+			"53/udp":   "kube-system/kube-dns:53",
+			"1883/tcp": " messaging/mqtt:1883 ",
+			"foo":      "tools/foo:80",
+			"8000":     "not-a-service",
+			"70000":    "tools/big:80",
+			"6000":     "tools/big:70000",
+		},
+	}
+
+	streamConfigs, err := buildConfigMapStreamConfigs(configMap)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []struct {
+		name     string
+		ports    map[string]string
+		protocol string
+	}{
+		{"messaging/mqtt", map[string]string{"1883": "1883"}, "tcp"},
+		{"kube-system/kube-dns", map[string]string{"53": "53"}, "udp"},
+		{"tools/postgres", map[string]string{"5432": "5432"}, "tcp"},
+	}
+	if len(streamConfigs) != len(expected) {
+		t.Fatalf("Expected %d streamConfigs, got %d", len(expected), len(streamConfigs))
+	}
+	for i, want := range expected {
+		got := streamConfigs[i]
+		if got.Name != want.name || got.Protocol != want.protocol || !reflect.DeepEqual(got.Ports, want.ports) {
+			t.Errorf("Expected streamConfig %d to be %+v, got %+v", i, want, got)
+		}
+	}
+}
+
+func TestBuildACMEChallenges(t *testing.T) {
+	configMap := &corev1.ConfigMap{
+		Data: map[string]string{
+			"LoqXcYV8q5ONbJQxbmR7SCTNo3tiAXDfowyjxAjEuX0": "LoqXcYV8q5ONbJQxbmR7SCTNo3tiAXDfowyjxAjEuX0.9jg46WB3rR_AHD-EBXdN7cBkH1WOu0tA3M9fm21mqTI",
+			"../nginx":    "foo.bar",
+			"injected":    "foo.bar;\n}",
+			"no-response": "",
+		},
+	}
+
+	challenges := buildACMEChallenges(configMap)
+
+	want := map[string]string{
+		"LoqXcYV8q5ONbJQxbmR7SCTNo3tiAXDfowyjxAjEuX0": "LoqXcYV8q5ONbJQxbmR7SCTNo3tiAXDfowyjxAjEuX0.9jg46WB3rR_AHD-EBXdN7cBkH1WOu0tA3M9fm21mqTI",
+	}
+	if !reflect.DeepEqual(want, challenges) {
+		t.Errorf("Expected challenges %v, got %v", want, challenges)
+	}
+}
+
+func TestClaimStreamPorts(t *testing.T) {
+	first := newStreamConfig()
+	first.Name = "tools/postgres"
+	first.Ports = map[string]string{"5432": "5432", "6443": "443"}
+	first.ServiceIP = "1.2.3.4"
+	second := newStreamConfig()
+	second.Name = "tools/other"
+	second.Ports = map[string]string{"5432": "5432", "2222": "22", "2223": "22"}
+	second.ServiceIP = "1.2.3.5"
+	third := newStreamConfig()
+	third.Name = "tools/gone"
+	third.Ports = map[string]string{"9000": "9000"}
+	fourth := newStreamConfig()
+	fourth.Name = "tools/web"
+	fourth.Ports = map[string]string{"8080": "80"}
+	fourth.ServiceIP = "1.2.3.6"
+	// Privileged ports, which only the config map can request, are rejected as well.
+	fifth := newStreamConfig()
+	fifth.Name = "kube-system/kube-dns"
+	fifth.Ports = map[string]string{"53": "53"}
+	fifth.ServiceIP = "1.2.3.7"
+
+	streamConfigs := claimStreamPorts([]*StreamConfig{first, second, third, fourth, fifth}, true)
+
+	if len(streamConfigs) != 2 {
+		t.Fatalf("Expected 2 streamConfigs, got %d", len(streamConfigs))
+	}
+	if want := map[string]string{"5432": "5432"}; !reflect.DeepEqual(streamConfigs[0].Ports, want) {
+		t.Errorf("Expected ports %v for %s, got %v", want, streamConfigs[0].Name, streamConfigs[0].Ports)
+	}
+	if want := map[string]string{"2223": "22"}; !reflect.DeepEqual(streamConfigs[1].Ports, want) {
+		t.Errorf("Expected ports %v for %s, got %v", want, streamConfigs[1].Name, streamConfigs[1].Ports)
+	}
+}
+
 func TestBuildCertificate(t *testing.T) {
 	// Ensure a valid Cert Secret returns the expected certificate.
 	validCertSecret := corev1.Secret{
@@ -231,6 +409,282 @@ func TestBuildCertificate(t *testing.T) {
 	}
 }
 
+func TestBuildCertificateAlternates(t *testing.T) {
+	certSecret := corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      platformCertName,
+			Namespace: deisNamespace,
+		},
+		Type: corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			"tls.crt":       []byte("foo"),
+			"tls.key":       []byte("bar"),
+			"tls-ecdsa.crt": []byte("biz"),
+			"tls-ecdsa.key": []byte("baz"),
+			// Incomplete pairs are skipped.
+			"tls-rsa.crt": []byte("qux"),
+		},
+	}
+	certificate, err := buildCertificate(&certSecret, "test-alternates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedCert := newCertificate("foo", "bar")
+	expectedCert.Alternates = []*Certificate{newCertificate("biz", "baz")}
+	if !reflect.DeepEqual(expectedCert, certificate) {
+		t.Errorf("Expected certificate %+v, got %+v", expectedCert, certificate)
+	}
+}
+
+func TestValidateCertificate(t *testing.T) {
+	now := time.Now()
+	caKey, caCert := newTestCA(t)
+	leafKey, leafPEM := newTestLeaf(t, caKey, caCert, now.Add(-time.Hour), now.Add(time.Hour), "www.example.com")
+	caPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCert.Raw}))
+	expiredKey, expiredPEM := newTestLeaf(t, caKey, caCert, now.Add(-2*time.Hour), now.Add(-time.Hour), "www.example.com")
+	futureKey, futurePEM := newTestLeaf(t, caKey, caCert, now.Add(time.Hour), now.Add(2*time.Hour), "www.example.com")
+
+	tests := []struct {
+		description string
+		certificate *Certificate
+		domain      string
+		valid       bool
+	}{
+		{"a valid chain", newCertificate(leafPEM+caPEM, leafKey), "www.example.com", true},
+		{"a valid leaf without its chain", newCertificate(leafPEM, leafKey), "www.example.com", true},
+		{"a valid chain without a domain", newCertificate(leafPEM+caPEM, leafKey), "", true},
+		{"a chain in the wrong order", newCertificate(caPEM+leafPEM, leafKey), "www.example.com", false},
+		{"a mismatched key", newCertificate(leafPEM+caPEM, newTestCertificate(t, "www.example.com").Key), "www.example.com", false},
+		{"SANs not covering the domain", newCertificate(leafPEM+caPEM, leafKey), "www.example.org", false},
+		{"an expired leaf", newCertificate(expiredPEM, expiredKey), "www.example.com", false},
+		{"a leaf that isn't valid yet", newCertificate(futurePEM, futureKey), "www.example.com", false},
+		{"garbage", newCertificate("foo", "bar"), "www.example.com", false},
+	}
+	for _, test := range tests {
+		err := validateCertificate(test.certificate, test.domain, now)
+		if test.valid && err != nil {
+			t.Errorf("Expected %s to be valid, got %v", test.description, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("Expected %s to be invalid, but did not receive any error", test.description)
+		}
+	}
+
+	// Validating a certificate records when it expires.
+	certificate := newCertificate(leafPEM+caPEM, leafKey)
+	if err := validateCertificate(certificate, "www.example.com", now); err != nil {
+		t.Fatal(err)
+	}
+	if want := now.Add(time.Hour).UTC().Truncate(time.Second); !certificate.NotAfter.Equal(want) {
+		t.Errorf("Expected NotAfter to be %s, got %s", want, certificate.NotAfter)
+	}
+}
+
+func TestBuildCertificateOCSP(t *testing.T) {
+	caKey, caCert := newTestCA(t)
+	ca := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCert.Raw}))
+	leafKey, leafCert := newTestLeaf(t, caKey, caCert, time.Now().Add(-time.Hour), time.Now().Add(time.Hour), "www.example.com")
+	intermediate := newTestCertificate(t, "Test Intermediate")
+	certSecret := corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      platformCertName,
+			Namespace: deisNamespace,
+		},
+		Type: corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			"tls.crt": []byte(leafCert + intermediate.Cert),
+			"tls.key": []byte(leafKey),
+			"ca.crt":  []byte(ca),
+		},
+	}
+	certificate, err := buildCertificate(&certSecret, "test-ocsp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The trusted chain consists of the intermediates following the leaf, followed by the CA.
+	if want := intermediate.Cert + ca; certificate.TrustedChain != want {
+		t.Errorf("Expected trusted chain %q, got %q", want, certificate.TrustedChain)
+	}
+
+	// Only a current response for the certificate, signed by its issuer, is stapled.
+	certSecret.Data["tls.crt"] = []byte(leafCert)
+	valid := newTestOCSPResponse(t, caKey, caCert, 2, time.Now().Add(time.Hour))
+	tests := []struct {
+		description string
+		response    []byte
+		ca          string
+		want        string
+	}{
+		{"valid response", valid, ca, string(valid)},
+		{"malformed response", []byte("foo"), ca, ""},
+		{"response for another certificate", newTestOCSPResponse(t, caKey, caCert, 3, time.Now().Add(time.Hour)), ca, ""},
+		{"expired response", newTestOCSPResponse(t, caKey, caCert, 2, time.Now().Add(-time.Minute)), ca, ""},
+		{"response without a known issuer", valid, "", ""},
+		{"response signed by another issuer", valid, newTestCertificate(t, "Test CA").Cert, ""},
+	}
+	for _, test := range tests {
+		certSecret.Data["tls.ocsp"] = test.response
+		certSecret.Data["ca.crt"] = []byte(test.ca)
+		certificate, err := buildCertificate(&certSecret, "test-ocsp")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if certificate.OCSPResponse != test.want {
+			t.Errorf("Expected OCSP response %q for a %s, got %q", test.want, test.description, certificate.OCSPResponse)
+		}
+	}
+
+	// A pre-fetched OCSP response is ambiguous for a certificate with alternates.
+	certSecret.Data["tls.ocsp"] = valid
+	certSecret.Data["ca.crt"] = []byte(ca)
+	certSecret.Data["tls-rsa.crt"] = []byte("bar")
+	certSecret.Data["tls-rsa.key"] = []byte("baz")
+	certificate, err = buildCertificate(&certSecret, "test-ocsp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if certificate.OCSPResponse != "" {
+		t.Errorf("Expected the OCSP response to be dropped, got %q", certificate.OCSPResponse)
+	}
+}
+
+func TestValidateCertificateAlternates(t *testing.T) {
+	now := time.Now()
+	rsaCertificate := newTestRSACertificate(t, "www.example.com")
+	ecdsaCertificate := newTestCertificate(t, "www.example.com")
+	otherECDSACertificate := newTestCertificate(t, "www.example.com")
+	otherDomainCertificate := newTestCertificate(t, "www.example.org")
+
+	tests := []struct {
+		description string
+		alternates  []*Certificate
+		valid       bool
+	}{
+		{"an alternate using another key type", []*Certificate{ecdsaCertificate}, true},
+		{"an alternate using the same key type", []*Certificate{rsaCertificate}, false},
+		{"two alternates using the same key type", []*Certificate{ecdsaCertificate, otherECDSACertificate}, false},
+		{"an alternate not covering the domain", []*Certificate{otherDomainCertificate}, false},
+		{"an invalid alternate", []*Certificate{newCertificate("foo", "bar")}, false},
+	}
+	for _, test := range tests {
+		certificate := newCertificate(rsaCertificate.Cert, rsaCertificate.Key)
+		certificate.Alternates = test.alternates
+		err := validateCertificate(certificate, "www.example.com", now)
+		if test.valid && err != nil {
+			t.Errorf("Expected a certificate with %s to be valid, got %v", test.description, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("Expected a certificate with %s to be invalid, but did not receive any error", test.description)
+		}
+	}
+
+	// The certificate expires when the first of its key pairs expires.
+	certificate := newCertificate(ecdsaCertificate.Cert, ecdsaCertificate.Key)
+	certificate.Alternates = []*Certificate{newCertificate(rsaCertificate.Cert, rsaCertificate.Key)}
+	if err := validateCertificate(certificate, "www.example.com", now); err != nil {
+		t.Fatal(err)
+	}
+	if certificate.KeyType != "ecdsa" || certificate.Alternates[0].KeyType != "rsa" {
+		t.Errorf("Expected key types ecdsa and rsa, got %s and %s", certificate.KeyType, certificate.Alternates[0].KeyType)
+	}
+	if !certificate.NotAfter.Equal(rsaCertificate.NotAfter) {
+		t.Errorf("Expected NotAfter to be %s, got %s", rsaCertificate.NotAfter, certificate.NotAfter)
+	}
+}
+
+func TestCertificateCovers(t *testing.T) {
+	certificate := newTestCertificate(t, "www.example.com", "*.apps.example.com")
+	tests := []struct {
+		domain string
+		want   bool
+	}{
+		{"www.example.com", true},
+		{"WWW.Example.com", true},
+		{"foo.apps.example.com", true},
+		{"*.apps.example.com", true},
+		{"apps.example.com", false},
+		{"foo.bar.apps.example.com", false},
+		{"example.com", false},
+		{"*.example.com", false},
+		{"foo.example.com", false},
+	}
+	for _, test := range tests {
+		if got := certificateCovers(certificate, test.domain); got != test.want {
+			t.Errorf("Expected certificateCovers to be %t for %s, got %t", test.want, test.domain, got)
+		}
+	}
+
+	if certificateCovers(newCertificate("foo", "bar"), "www.example.com") {
+		t.Errorf("Expected an unparseable certificate not to cover any domain")
+	}
+}
+
+func TestMatchCertificate(t *testing.T) {
+	referenced := newTestCertificate(t, "*.example.com")
+	wildcard := newTestCertificate(t, "*.example.com", "*.apps.example.com")
+
+	if got := matchCertificate("www.example.com", []*Certificate{referenced}, []*Certificate{wildcard}); got != referenced {
+		t.Errorf("Expected a cert referenced by the app to take precedence over a wildcard cert")
+	}
+	if got := matchCertificate("foo.apps.example.com", []*Certificate{referenced}, []*Certificate{wildcard}); got != wildcard {
+		t.Errorf("Expected the wildcard cert to be matched")
+	}
+	if got := matchCertificate("www.example.org", []*Certificate{referenced}, []*Certificate{wildcard}); got != nil {
+		t.Errorf("Expected no cert to be matched, got %+v", got)
+	}
+}
+
+func TestBuildCA(t *testing.T) {
+	// Ensure a valid CA Secret returns the expected CA and CRL.
+	caSecret := corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "partners-ca",
+			Namespace: deisNamespace,
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{
+			"ca.crt": []byte("foo"),
+			"ca.crl": []byte("bar"),
+		},
+	}
+	ca, crl, err := buildCA(&caSecret, "partners-ca")
+	if err != nil {
+		t.Error(err)
+	}
+	if ca != "foo" || crl != "bar" {
+		t.Errorf("Expected CA foo and CRL bar, but got CA %s and CRL %s.", ca, crl)
+	}
+
+	// Ensure a CA Secret without a CRL returns an empty CRL.
+	delete(caSecret.Data, "ca.crl")
+	ca, crl, err = buildCA(&caSecret, "partners-ca")
+	if err != nil {
+		t.Error(err)
+	}
+	if ca != "foo" || crl != "" {
+		t.Errorf("Expected CA foo and no CRL, but got CA %s and CRL %s.", ca, crl)
+	}
+
+	// Ensure an invalid CA Secret returns an empty CA.
+	invalidCASecret := corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "partners-ca",
+			Namespace: deisNamespace,
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{
+			"foo": []byte("bar"),
+		},
+	}
+	ca, _, err = buildCA(&invalidCASecret, "partners-ca")
+	if err != nil {
+		t.Error(err)
+	}
+	if ca != "" {
+		t.Errorf("Invalid CA Secret should have returned empty string.")
+	}
+}
+
 func TestBuildDHParam(t *testing.T) {
 	// Ensure a valid DHParam Secret returns the expected DHParam string.
 	expectedDHParam := "bizbaz"
@@ -272,3 +726,519 @@ func TestBuildDHParam(t *testing.T) {
 		t.Errorf("Invalid DHParam Secret should have returned empty string.")
 	}
 }
+
+func TestBuildSessionTicketKeys(t *testing.T) {
+	// Ensure the keys are split into 80-byte keys, keeping their order.
+	first := strings.Repeat("a", SessionTicketKeySize)
+	second := strings.Repeat("b", SessionTicketKeySize)
+	sessionTicketKeysSecret := corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "deis-router-session-ticket-keys",
+			Namespace: deisNamespace,
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{
+			"keys": []byte(first + second),
+		},
+	}
+	if want, got := []string{first, second}, buildSessionTicketKeys(&sessionTicketKeysSecret); !reflect.DeepEqual(want, got) {
+		t.Errorf("Expected session ticket keys %q, got %q", want, got)
+	}
+
+	// Ensure the staged key comes last, as it only decrypts tickets.
+	staged := strings.Repeat("s", SessionTicketKeySize)
+	sessionTicketKeysSecret.Data["staged"] = []byte(staged)
+	if want, got := []string{first, second, staged}, buildSessionTicketKeys(&sessionTicketKeysSecret); !reflect.DeepEqual(want, got) {
+		t.Errorf("Expected session ticket keys %q, got %q", want, got)
+	}
+
+	// Ensure keys of the wrong size are ignored.
+	sessionTicketKeysSecret.Data["keys"] = []byte(first + "c")
+	if got := buildSessionTicketKeys(&sessionTicketKeysSecret); got != nil {
+		t.Errorf("Expected no session ticket keys, got %q", got)
+	}
+}
+
+func TestAddAccessLocations(t *testing.T) {
+	// Ensure path access rules are attached to existing locations or to newly added ones.
+	basicAuth := newBasicAuth("deis-admins", "foo:bar")
+	app := &AppConfig{
+		PathAccess: &PathAccessConfig{
+			Allow: map[string]string{"/admin": "10.0.0.0/8 192.168.0.0/16"},
+			Deny:  map[string]string{"/": "1.2.3.4"},
+			BasicAuths: map[string]*BasicAuth{
+				"/admin": basicAuth,
+			},
+		},
+	}
+	addRootLocations([]*AppConfig{app})
+	addAccessLocations(&RouterConfig{AppConfigs: []*AppConfig{app}})
+
+	expectedLocations := []*Location{
+		{
+			App:  app,
+			Path: "/",
+			Access: &LocationAccess{
+				Allow: []string{},
+				Deny:  []string{"1.2.3.4"},
+			},
+		},
+		{
+			App:  app,
+			Path: "/admin",
+			Access: &LocationAccess{
+				Allow:     []string{"10.0.0.0/8", "192.168.0.0/16"},
+				Deny:      []string{},
+				BasicAuth: basicAuth,
+			},
+		},
+	}
+	if len(app.Locations) != len(expectedLocations) {
+		t.Fatalf("Expected %d locations, but got %d.", len(expectedLocations), len(app.Locations))
+	}
+	for _, expected := range expectedLocations {
+		var actual *Location
+		for _, location := range app.Locations {
+			if location.Path == expected.Path {
+				actual = location
+			}
+		}
+		if !reflect.DeepEqual(expected, actual) {
+			t.Errorf("Expected location %+v does not match actual %+v.", expected, actual)
+		}
+	}
+}
+
+func TestAddAccessLocationsOrder(t *testing.T) {
+	// Ensure building the same app twice yields its locations in the same order, so that an
+	// unchanged app doesn't cause a reload.
+	newApp := func() *AppConfig {
+		return &AppConfig{
+			PathAccess: &PathAccessConfig{
+				Allow: map[string]string{"/a": "1.2.3.4", "/c": "1.2.3.4", "/e": "1.2.3.4", "/g": "1.2.3.4"},
+				Deny:  map[string]string{"/b": "1.2.3.5", "/d": "1.2.3.5", "/f": "1.2.3.5", "/h": "1.2.3.5"},
+			},
+		}
+	}
+	var orders [][]string
+	for i := 0; i < 2; i++ {
+		app := newApp()
+		addRootLocations([]*AppConfig{app})
+		addAccessLocations(&RouterConfig{AppConfigs: []*AppConfig{app}})
+		var order []string
+		for _, location := range app.Locations {
+			order = append(order, location.Path)
+		}
+		orders = append(orders, order)
+	}
+	if !reflect.DeepEqual(orders[0], orders[1]) {
+		t.Errorf("Expected the same order of locations both times, but got %v and %v.", orders[0], orders[1])
+	}
+	expected := []string{"/", "/a", "/b", "/c", "/d", "/e", "/f", "/g", "/h"}
+	if !reflect.DeepEqual(expected, orders[0]) {
+		t.Errorf("Expected locations %v, but got %v.", expected, orders[0])
+	}
+}
+
+func TestAddAccessLocationsWhitelisted(t *testing.T) {
+	// Ensure a path that only denies addresses keeps the whitelist of a whitelisted app.
+	routerConfig := &RouterConfig{
+		DefaultWhitelist: []string{"10.0.0.0/8"},
+		WhitelistMode:    "extend",
+	}
+	app := &AppConfig{
+		Whitelist: []string{"1.2.3.0/24"},
+		PathAccess: &PathAccessConfig{
+			Allow: map[string]string{"/admin": "1.2.3.4"},
+			Deny:  map[string]string{"/": "1.2.3.5"},
+		},
+	}
+	routerConfig.AppConfigs = []*AppConfig{app}
+	addRootLocations(routerConfig.AppConfigs)
+	addAccessLocations(routerConfig)
+
+	expectedAccesses := map[string]*LocationAccess{
+		"/": {
+			Allow:   []string{"10.0.0.0/8", "1.2.3.0/24"},
+			Deny:    []string{"1.2.3.5"},
+			DenyAll: true,
+		},
+		"/admin": {
+			Allow: []string{"1.2.3.4"},
+			Deny:  []string{},
+		},
+	}
+	for _, location := range app.Locations {
+		if !reflect.DeepEqual(expectedAccesses[location.Path], location.Access) {
+			t.Errorf("Expected access %+v for %s, but got %+v.", expectedAccesses[location.Path], location.Path, location.Access)
+		}
+	}
+
+	// Ensure an enforced but empty whitelist still denies everyone else.
+	routerConfig = &RouterConfig{EnforceWhitelists: true}
+	app = &AppConfig{
+		PathAccess: &PathAccessConfig{
+			Deny: map[string]string{"/": "1.2.3.5"},
+		},
+	}
+	routerConfig.AppConfigs = []*AppConfig{app}
+	addRootLocations(routerConfig.AppConfigs)
+	addAccessLocations(routerConfig)
+	if access := app.Locations[0].Access; len(access.Allow) != 0 || !access.DenyAll {
+		t.Errorf("Expected all other addresses to be denied, but got %+v.", access)
+	}
+}
+
+func TestAddAccessLocationsMissingBasicAuth(t *testing.T) {
+	// Ensure a path whose basic auth couldn't be loaded is closed rather than left open.
+	app := &AppConfig{
+		PathAccess: &PathAccessConfig{
+			Allow:      map[string]string{"/admin": "10.0.0.0/8"},
+			BasicAuth:  map[string]string{"/admin": "admins"},
+			BasicAuths: map[string]*BasicAuth{},
+		},
+	}
+	addRootLocations([]*AppConfig{app})
+	addAccessLocations(&RouterConfig{AppConfigs: []*AppConfig{app}})
+
+	expected := &LocationAccess{
+		Allow:   []string{},
+		Deny:    []string{},
+		DenyAll: true,
+	}
+	for _, location := range app.Locations {
+		if location.Path == "/admin" && !reflect.DeepEqual(expected, location.Access) {
+			t.Errorf("Expected access %+v, but got %+v.", expected, location.Access)
+		}
+	}
+}
+
+func TestBuildBasicAuth(t *testing.T) {
+	// Ensure a valid htpasswd Secret returns the expected basic auth.
+	authSecret := corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "admins-auth",
+			Namespace: deisNamespace,
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{
+			"htpasswd": []byte("foo:bar"),
+		},
+	}
+	expectedBasicAuth := newBasicAuth("deis-admins", "foo:bar")
+	actualBasicAuth, err := buildBasicAuth(&authSecret, "deis-admins")
+	if err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(expectedBasicAuth, actualBasicAuth) {
+		t.Errorf("Expected basic auth %+v does not match actual %+v.", expectedBasicAuth, actualBasicAuth)
+	}
+
+	// Ensure an invalid htpasswd Secret returns nil.
+	invalidAuthSecret := corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "admins-auth",
+			Namespace: deisNamespace,
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{
+			"foo": []byte("bar"),
+		},
+	}
+	invalidBasicAuth, err := buildBasicAuth(&invalidAuthSecret, "deis-admins")
+	if err != nil {
+		t.Error(err)
+	}
+	if invalidBasicAuth != nil {
+		t.Errorf("Expected invalid htpasswd secret to return nil.")
+	}
+}
+
+func TestACMECertName(t *testing.T) {
+	if got := ACMECertName("Foo.Example.com"); got != "acme-foo.example.com" {
+		t.Errorf("Expected acme-foo.example.com, got %s", got)
+	}
+}
+
+func TestMapAnnotations(t *testing.T) {
+	// Ensure rejected annotations leave their fields at the defaults without failing the model.
+	routerConfig, err := newRouterConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	appConfig, err := newAppConfig(routerConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	annotations := map[string]string{
+		"router.deis.io/connectTimeout": "foo",
+		"router.deis.io/tcpTimeout":     "bar",
+		"router.deis.io/maintenance":    "true",
+	}
+	err = mapAnnotations(annotations, "", appConfig, "service foo/foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if appConfig.ConnectTimeout != "30s" || appConfig.TCPTimeout != routerConfig.DefaultTimeout {
+		t.Errorf("Expected the default timeouts, got %s and %s", appConfig.ConnectTimeout, appConfig.TCPTimeout)
+	}
+	if !appConfig.Maintenance {
+		t.Errorf("Expected valid annotations to be applied.")
+	}
+}
+
+func TestMapDocumentAnnotation(t *testing.T) {
+	// Ensure a nested model can be given as a document, with flat annotations taking precedence.
+	routerConfig, err := newRouterConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	annotations := map[string]string{
+		"router.deis.io/nginx.ssl.hsts":         `{"enabled": true, "maxAge": 31536000, "preload": "yes"}`,
+		"router.deis.io/nginx.ssl.hsts.preload": "true",
+	}
+	err = mapAnnotations(annotations, "nginx", routerConfig, "deployment foo/foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hstsConfig := routerConfig.SSLConfig.HSTSConfig
+	if !hstsConfig.Enabled || hstsConfig.MaxAge != 31536000 || !hstsConfig.Preload {
+		t.Errorf("Expected the HSTS settings from the document, got %+v", hstsConfig)
+	}
+	if !contains(routerAnnotationKeys, "router.deis.io/nginx.ssl.hsts") {
+		t.Errorf("Expected router.deis.io/nginx.ssl.hsts to be a known annotation.")
+	}
+}
+
+func TestAnnotationsRoundTrip(t *testing.T) {
+	// Ensure the annotations models are dumped as are those they were built from, minus defaults.
+	routerConfig, err := newRouterConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	routerAnnotations := map[string]string{
+		"router.deis.io/nginx.bodySize":                     "2m",
+		"router.deis.io/nginx.ssl.hsts.enabled":             "true",
+		"router.deis.io/nginx.ssl.sessionTicketKeyRotation": "1d",
+		"router.deis.io/nginx.workerProcesses":              "auto",
+	}
+	if err := mapAnnotations(routerAnnotations, "nginx", routerConfig, "deployment foo/foo"); err != nil {
+		t.Fatal(err)
+	}
+	got, err := RouterAnnotations(routerConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	delete(routerAnnotations, "router.deis.io/nginx.workerProcesses")
+	if !reflect.DeepEqual(routerAnnotations, got) {
+		t.Errorf("Expected %v, but got %v", routerAnnotations, got)
+	}
+
+	appConfig, err := newAppConfig(routerConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appAnnotations := map[string]string{
+		"router.deis.io/connectTimeout": "10s",
+		"router.deis.io/whitelist":      "10.0.0.0/8,192.168.0.1",
+	}
+	if err := mapAnnotations(appAnnotations, "", appConfig, "service foo/foo"); err != nil {
+		t.Fatal(err)
+	}
+	got, err = AppAnnotations(routerConfig, appConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(appAnnotations, got) {
+		t.Errorf("Expected %v, but got %v", appAnnotations, got)
+	}
+}
+
+func TestKnownAnnotationKeys(t *testing.T) {
+	// Ensure the keys of each model are known under their own context only.
+	for _, key := range []string{"router.deis.io/maintenance", "router.deis.io/ssl.hsts.maxAge", "router.deis.io/stream.ports", "router.deis.io/nginx.connectTimeout"} {
+		if !contains(serviceAnnotationKeys, key) {
+			t.Errorf("Expected %s to be a known service annotation.", key)
+		}
+	}
+	for _, key := range []string{"router.deis.io/nginx.ssl.hsts.maxAge", "router.deis.io/nginx.acme.email"} {
+		if !contains(routerAnnotationKeys, key) {
+			t.Errorf("Expected %s to be a known router annotation.", key)
+		}
+	}
+	if contains(routerAnnotationKeys, "router.deis.io/maintenance") {
+		t.Errorf("Expected router.deis.io/maintenance not to be a known router annotation.")
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func TestGeneratedCertName(t *testing.T) {
+	if got := GeneratedCertName("Foo.Example.com"); got != "generated-foo.example.com" {
+		t.Errorf("Expected generated-foo.example.com, got %s", got)
+	}
+	if got := GeneratedCertName("*.example.com"); got != "generated-wildcard.example.com" {
+		t.Errorf("Expected generated-wildcard.example.com, got %s", got)
+	}
+}
+
+// newTestCertificate returns a valid self-signed certificate for the given DNS names, which
+// expires in an hour.
+func newTestCertificate(t *testing.T, dnsNames ...string) *Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: dnsNames[0]},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour).UTC().Truncate(time.Second),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate := newCertificate(
+		string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		encodeTestKey(t, key),
+	)
+	certificate.KeyType = "ecdsa"
+	certificate.NotAfter = template.NotAfter
+	return certificate
+}
+
+func newTestRSACertificate(t *testing.T, dnsNames ...string) *Certificate {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: dnsNames[0]},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(30 * time.Minute).UTC().Truncate(time.Second),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate := newCertificate(
+		string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})),
+	)
+	certificate.KeyType = "rsa"
+	certificate.NotAfter = template.NotAfter
+	return certificate
+}
+
+func newTestCA(t *testing.T) (*ecdsa.PrivateKey, *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-24 * time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, cert
+}
+
+// newTestLeaf returns the PEM-encoded key and certificate of a leaf signed by the given CA.
+func newTestLeaf(t *testing.T, caKey *ecdsa.PrivateKey, caCert *x509.Certificate, notBefore time.Time, notAfter time.Time, dnsNames ...string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: dnsNames[0]},
+		DNSNames:     dnsNames,
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, key.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encodeTestKey(t, key), string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+// newTestOCSPResponse returns a DER-encoded OCSP response, signed by the given CA, reporting the
+// certificate with the given serial number as good until nextUpdate.
+func newTestOCSPResponse(t *testing.T, caKey *ecdsa.PrivateKey, caCert *x509.Certificate, serialNumber int64, nextUpdate time.Time) []byte {
+	response, err := ocsp.CreateResponse(caCert, caCert, ocsp.Response{
+		Status:       ocsp.Good,
+		SerialNumber: big.NewInt(serialNumber),
+		ThisUpdate:   nextUpdate.Add(-2 * time.Hour),
+		NextUpdate:   nextUpdate,
+	}, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return response
+}
+
+func encodeTestKey(t *testing.T, key *ecdsa.PrivateKey) string {
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+}
+
+func BenchmarkMapAppAnnotations(b *testing.B) {
+	// Map the annotations of a large set of services, as each build does.
+	routerConfig, err := newRouterConfig()
+	if err != nil {
+		b.Fatal(err)
+	}
+	services := make([]map[string]string, 5000)
+	for i := range services {
+		services[i] = map[string]string{
+			"router.deis.io/domains":                 fmt.Sprintf("app-%d,app-%d.example.com", i, i),
+			"router.deis.io/certificates":            fmt.Sprintf("app-%d.example.com:app-%d", i, i),
+			"router.deis.io/whitelist":               "10.0.0.0/8,192.168.0.1",
+			"router.deis.io/connectTimeout":          "10s",
+			"router.deis.io/tcpTimeout":              "600s",
+			"router.deis.io/maintenance":             "false",
+			"router.deis.io/ssl.enforce":             "true",
+			"router.deis.io/ssl.hsts.enabled":        "true",
+			"router.deis.io/nginx.proxyBuffers.size": "8k",
+		}
+	}
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		for i, annotations := range services {
+			appConfig, err := newAppConfig(routerConfig)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if err := mapAnnotations(annotations, "", appConfig, fmt.Sprintf("service foo/app-%d", i)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
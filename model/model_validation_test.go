@@ -12,7 +12,7 @@ var (
 	// one defined in the non-test half of this package.  Why?  We want one that generates errors
 	// instead of merely outputting warnings.  That allows us to easily assert validation failures
 	// and also prevents us from clutter STDOUT with useless noise.
-	testModeler = modelerUtility.NewModeler("", modelerFieldTag, modelerConstraintTag, false)
+	testModeler = modelerUtility.NewModeler("", modelerFieldTag, modelerConstraintTag, false).WithDefaultTag(modelerDefaultTag)
 )
 
 func TestInvalidWorkerProcesses(t *testing.T) {
@@ -239,6 +239,30 @@ func TestValidAppWhitelist(t *testing.T) {
 	testValidValues(t, newTestAppConfig, "Whitelist", "whitelist", []string{"1.2.3.4", "0.0.0.0/0", "1.2.3.4,0.0.0.0/0", "1.2.3.4, 0.0.0.0/0"})
 }
 
+func TestInvalidPathAccessAllow(t *testing.T) {
+	testInvalidValues(t, newTestPathAccessConfig, "Allow", "allow", []string{"0", "/admin", "admin:1.2.3.4", "/admin:foobar", "/admin:1.2.3.4,foobar", "/admin;return:1.2.3.4", "/admin{}:1.2.3.4", "/admin\"x:1.2.3.4"})
+}
+
+func TestValidPathAccessAllow(t *testing.T) {
+	testValidValues(t, newTestPathAccessConfig, "Allow", "allow", []string{"/admin:1.2.3.4", "/admin:10.0.0.0/8 192.168.0.0/16", "/admin:1.2.3.4, /metrics:0.0.0.0/0"})
+}
+
+func TestInvalidPathAccessDeny(t *testing.T) {
+	testInvalidValues(t, newTestPathAccessConfig, "Deny", "deny", []string{"0", "/admin", "admin:1.2.3.4", "/admin:foobar", "/admin:1.2.3.4,foobar", "/admin;return:1.2.3.4", "/admin{}:1.2.3.4", "/admin\"x:1.2.3.4"})
+}
+
+func TestValidPathAccessDeny(t *testing.T) {
+	testValidValues(t, newTestPathAccessConfig, "Deny", "deny", []string{"/admin:1.2.3.4", "/admin:10.0.0.0/8 192.168.0.0/16", "/admin:1.2.3.4, /metrics:0.0.0.0/0"})
+}
+
+func TestInvalidPathAccessBasicAuth(t *testing.T) {
+	testInvalidValues(t, newTestPathAccessConfig, "BasicAuth", "basicAuth", []string{"0", "/admin", "admin:foobar", "/admin:foo_bar", "/admin;return:foobar", "/admin{:foobar"})
+}
+
+func TestValidPathAccessBasicAuth(t *testing.T) {
+	testValidValues(t, newTestPathAccessConfig, "BasicAuth", "basicAuth", []string{"/admin:foobar", "/admin:foo-bar,/metrics:foobar"})
+}
+
 func TestInvalidAppConnectTimeout(t *testing.T) {
 	testInvalidValues(t, newTestAppConfig, "ConnectTimeout", "connectTimeout", []string{"0", "-1", "foobar"})
 }
@@ -256,11 +280,19 @@ func TestValidAppTCPTimeout(t *testing.T) {
 }
 
 func TestInvalidCertMappings(t *testing.T) {
-	testInvalidValues(t, newTestAppConfig, "CertMappings", "certificates", []string{"0", "-1", "foobar"})
+	testInvalidValues(t, newTestAppConfig, "CertMappings", "certificates", []string{"0", "-1", "foobar", "foobar.com:foobar|", "foobar.com:|foobar"})
 }
 
 func TestValidCertMappings(t *testing.T) {
-	testValidValues(t, newTestAppConfig, "CertMappings", "certificates", []string{"foobar.com:foobar,*.foobar.deis.ninja:foobar-deis-ninja"})
+	testValidValues(t, newTestAppConfig, "CertMappings", "certificates", []string{"foobar.com:foobar,*.foobar.deis.ninja:foobar-deis-ninja", "foobar.com:foobar-rsa|foobar-ecdsa"})
+}
+
+func TestInvalidAppTLSPassthrough(t *testing.T) {
+	testInvalidValues(t, newTestAppConfig, "TLSPassthrough", "tlsPassthrough", []string{"0", "-1", "foobar"})
+}
+
+func TestValidAppTLSPassthrough(t *testing.T) {
+	testValidValues(t, newTestAppConfig, "TLSPassthrough", "tlsPassthrough", []string{"true", "false", "TRUE", "FALSE"})
 }
 
 func TestValidAppReferrerPolicy(t *testing.T) {
@@ -271,6 +303,86 @@ func TestInvalidAppReferrerPolicy(t *testing.T) {
 	testInvalidValues(t, newTestAppConfig, "ReferrerPolicy", "referrerPolicy", []string{"0", "-1", "foobar", ""})
 }
 
+func TestInvalidClientCertVerify(t *testing.T) {
+	testInvalidValues(t, newTestClientCertConfig, "Verify", "verify", []string{"0", "-1", "foobar", "true"})
+}
+
+func TestValidClientCertVerify(t *testing.T) {
+	testValidValues(t, newTestClientCertConfig, "Verify", "verify", []string{"off", "on", "optional", "optional_no_ca"})
+}
+
+func TestInvalidClientCertVerifyDepth(t *testing.T) {
+	testInvalidValues(t, newTestClientCertConfig, "VerifyDepth", "verifyDepth", []string{"-1", "foobar"})
+}
+
+func TestValidClientCertVerifyDepth(t *testing.T) {
+	testValidValues(t, newTestClientCertConfig, "VerifyDepth", "verifyDepth", []string{"0", "1", "2", "10"})
+}
+
+func TestInvalidClientCertCAMapping(t *testing.T) {
+	testInvalidValues(t, newTestClientCertConfig, "CAMapping", "ca", []string{"-1", "foo_bar", "foo bar"})
+}
+
+func TestValidClientCertCAMapping(t *testing.T) {
+	testValidValues(t, newTestClientCertConfig, "CAMapping", "ca", []string{"foobar", "foo-bar", "partners"})
+}
+
+func TestInvalidClientCertForwardHeaders(t *testing.T) {
+	testInvalidValues(t, newTestClientCertConfig, "ForwardHeaders", "forwardHeaders", []string{"0", "-1", "foobar"})
+}
+
+func TestValidClientCertForwardHeaders(t *testing.T) {
+	testValidValues(t, newTestClientCertConfig, "ForwardHeaders", "forwardHeaders", []string{"true", "false", "TRUE", "FALSE"})
+}
+
+func TestInvalidBackendProtocol(t *testing.T) {
+	testInvalidValues(t, newTestBackendConfig, "Protocol", "protocol", []string{"0", "-1", "foobar", "HTTP", "h2"})
+}
+
+func TestValidBackendProtocol(t *testing.T) {
+	testValidValues(t, newTestBackendConfig, "Protocol", "protocol", []string{"http", "https", "grpc", "grpcs", "h2c"})
+}
+
+func TestInvalidBackendPort(t *testing.T) {
+	testInvalidValues(t, newTestBackendConfig, "Port", "port", []string{"0", "-1", "foobar"})
+}
+
+func TestValidBackendPort(t *testing.T) {
+	testValidValues(t, newTestBackendConfig, "Port", "port", []string{"80", "443", "8443"})
+}
+
+func TestInvalidBackendSNIName(t *testing.T) {
+	testInvalidValues(t, newTestBackendConfig, "SNIName", "sniName", []string{"-1", "foo_bar", "foo bar", "foo..bar"})
+}
+
+func TestValidBackendSNIName(t *testing.T) {
+	testValidValues(t, newTestBackendConfig, "SNIName", "sniName", []string{"foobar", "foo-bar.default.svc", "api.example.com"})
+}
+
+func TestInvalidBackendCAMapping(t *testing.T) {
+	testInvalidValues(t, newTestBackendConfig, "CAMapping", "ca", []string{"-1", "foo_bar", "foo bar"})
+}
+
+func TestValidBackendCAMapping(t *testing.T) {
+	testValidValues(t, newTestBackendConfig, "CAMapping", "ca", []string{"foobar", "foo-bar"})
+}
+
+func TestInvalidBackendVerifyDepth(t *testing.T) {
+	testInvalidValues(t, newTestBackendConfig, "VerifyDepth", "verifyDepth", []string{"-1", "foobar"})
+}
+
+func TestValidBackendVerifyDepth(t *testing.T) {
+	testValidValues(t, newTestBackendConfig, "VerifyDepth", "verifyDepth", []string{"0", "1", "2", "10"})
+}
+
+func TestInvalidBackendCertMapping(t *testing.T) {
+	testInvalidValues(t, newTestBackendConfig, "CertMapping", "certificate", []string{"-1", "foo_bar", "foo bar"})
+}
+
+func TestValidBackendCertMapping(t *testing.T) {
+	testValidValues(t, newTestBackendConfig, "CertMapping", "certificate", []string{"foobar", "foo-bar"})
+}
+
 func TestInvalidBuilderConnectTimeout(t *testing.T) {
 	testInvalidValues(t, newTestBuilderConfig, "ConnectTimeout", "connectTimeout", []string{"0", "-1", "foobar"})
 }
@@ -287,6 +399,94 @@ func TestValidBuilderTCPTimeout(t *testing.T) {
 	testValidValues(t, newTestBuilderConfig, "TCPTimeout", "tcpTimeout", []string{"1", "2", "10", "1ms", "2s", "10m"})
 }
 
+func TestInvalidStreamPorts(t *testing.T) {
+	testInvalidValues(t, newTestStreamConfig, "Ports", "ports", []string{"0:5432", "5432", "5432:", ":5432", "5432:-1", "foobar", "5432:5432,foo", "70000:80", "5432:65536", "80:80", "443:8443", "1023:1023"})
+}
+
+func TestValidStreamPorts(t *testing.T) {
+	testValidValues(t, newTestStreamConfig, "Ports", "ports", []string{"5432:5432", "15432:5432", "5432:5432,1883:1883", "5432:5432, 1883:1883", "1024:1", "65535:65535"})
+}
+
+func TestInvalidStreamProtocol(t *testing.T) {
+	testInvalidValues(t, newTestStreamConfig, "Protocol", "protocol", []string{"0", "foobar", "TCP", "http"})
+}
+
+func TestValidStreamProtocol(t *testing.T) {
+	testValidValues(t, newTestStreamConfig, "Protocol", "protocol", []string{"tcp", "udp"})
+}
+
+func TestInvalidStreamConnectTimeout(t *testing.T) {
+	testInvalidValues(t, newTestStreamConfig, "ConnectTimeout", "connectTimeout", []string{"0", "-1", "foobar"})
+}
+
+func TestValidStreamConnectTimeout(t *testing.T) {
+	testValidValues(t, newTestStreamConfig, "ConnectTimeout", "connectTimeout", []string{"1", "2", "10", "1ms", "2s", "10m"})
+}
+
+func TestInvalidStreamTimeout(t *testing.T) {
+	testInvalidValues(t, newTestStreamConfig, "Timeout", "timeout", []string{"0", "-1", "foobar"})
+}
+
+func TestValidStreamTimeout(t *testing.T) {
+	testValidValues(t, newTestStreamConfig, "Timeout", "timeout", []string{"1", "2", "10", "1ms", "2s", "10m"})
+}
+
+func TestInvalidStreamProxyProtocol(t *testing.T) {
+	testInvalidValues(t, newTestStreamConfig, "ProxyProtocol", "proxyProtocol", []string{"0", "-1", "foobar"})
+}
+
+func TestValidStreamProxyProtocol(t *testing.T) {
+	testValidValues(t, newTestStreamConfig, "ProxyProtocol", "proxyProtocol", []string{"true", "false", "TRUE", "FALSE"})
+}
+
+func TestInvalidStreamWhitelist(t *testing.T) {
+	testInvalidValues(t, newTestStreamConfig, "Whitelist", "whitelist", []string{"0", "-1", "foobar", "10.0.0.0/33", "256.0.0.1"})
+}
+
+func TestValidStreamWhitelist(t *testing.T) {
+	testValidValues(t, newTestStreamConfig, "Whitelist", "whitelist", []string{"1.2.3.4", "10.0.0.0/8", "1.2.3.4,10.0.0.0/8", "1.2.3.4, 10.0.0.0/8"})
+}
+
+func TestInvalidACMEDirectoryURL(t *testing.T) {
+	testInvalidValues(t, newTestACMEConfig, "DirectoryURL", "directoryURL", []string{"0", "foobar", "ftp://example.com/directory", "https://"})
+}
+
+func TestValidACMEDirectoryURL(t *testing.T) {
+	testValidValues(t, newTestACMEConfig, "DirectoryURL", "directoryURL", []string{"https://acme-staging-v02.api.letsencrypt.org/directory", "https://pebble:14000/dir", "http://localhost:4001/directory"})
+}
+
+func TestInvalidACMEEmail(t *testing.T) {
+	testInvalidValues(t, newTestACMEConfig, "Email", "email", []string{"0", "foobar", "foo@", "@example.com", "foo@bar@example.com"})
+}
+
+func TestValidACMEEmail(t *testing.T) {
+	testValidValues(t, newTestACMEConfig, "Email", "email", []string{"admin@example.com", "foo.bar+acme@example.co.uk"})
+}
+
+func TestInvalidACMERenewBefore(t *testing.T) {
+	testInvalidValues(t, newTestACMEConfig, "RenewBefore", "renewBefore", []string{"0", "-1", "foobar"})
+}
+
+func TestValidACMERenewBefore(t *testing.T) {
+	testValidValues(t, newTestACMEConfig, "RenewBefore", "renewBefore", []string{"1", "30", "60"})
+}
+
+func TestInvalidACMEInsecureSkipVerify(t *testing.T) {
+	testInvalidValues(t, newTestACMEConfig, "InsecureSkipVerify", "insecureSkipVerify", []string{"0", "-1", "foobar"})
+}
+
+func TestValidACMEInsecureSkipVerify(t *testing.T) {
+	testValidValues(t, newTestACMEConfig, "InsecureSkipVerify", "insecureSkipVerify", []string{"true", "false", "TRUE", "FALSE"})
+}
+
+func TestInvalidAppACMEEnabled(t *testing.T) {
+	testInvalidValues(t, newTestAppACMEConfig, "Enabled", "enabled", []string{"0", "-1", "foobar"})
+}
+
+func TestValidAppACMEEnabled(t *testing.T) {
+	testValidValues(t, newTestAppACMEConfig, "Enabled", "enabled", []string{"true", "false", "TRUE", "FALSE"})
+}
+
 func TestInvalidSSLEnforce(t *testing.T) {
 	testInvalidValues(t, newTestSSLConfig, "Enforce", "enforce", []string{"0", "-1", "foobar"})
 }
@@ -375,6 +575,70 @@ func TestValidHSTSPreload(t *testing.T) {
 	testValidValues(t, newTestHSTSConfig, "Preload", "preload", []string{"true", "false", "TRUE", "FALSE"})
 }
 
+func TestInvalidDHParamSize(t *testing.T) {
+	testInvalidValues(t, newTestSSLConfig, "DHParamSize", "dhParamSize", []string{"0", "-1", "foobar", "512", "1024", "2047"})
+}
+
+func TestValidDHParamSize(t *testing.T) {
+	testValidValues(t, newTestSSLConfig, "DHParamSize", "dhParamSize", []string{"2048", "3072", "4096"})
+}
+
+func TestInvalidSelfSignedEnabled(t *testing.T) {
+	testInvalidValues(t, newTestSelfSignedConfig, "Enabled", "enabled", []string{"0", "-1", "foobar"})
+}
+
+func TestValidSelfSignedEnabled(t *testing.T) {
+	testValidValues(t, newTestSelfSignedConfig, "Enabled", "enabled", []string{"true", "false", "TRUE", "FALSE"})
+}
+
+func TestInvalidSelfSignedCAMapping(t *testing.T) {
+	testInvalidValues(t, newTestSelfSignedConfig, "CAMapping", "ca", []string{"-foo", "foo-", "foo_bar", "foo.bar"})
+}
+
+func TestValidSelfSignedCAMapping(t *testing.T) {
+	testValidValues(t, newTestSelfSignedConfig, "CAMapping", "ca", []string{"internal", "internal-ca", "Staging2"})
+}
+
+func TestInvalidSelfSignedValidDays(t *testing.T) {
+	testInvalidValues(t, newTestSelfSignedConfig, "ValidDays", "validDays", []string{"0", "-1", "foobar"})
+}
+
+func TestValidSelfSignedValidDays(t *testing.T) {
+	testValidValues(t, newTestSelfSignedConfig, "ValidDays", "validDays", []string{"1", "90", "365"})
+}
+
+func TestInvalidSessionTicketKeyRotation(t *testing.T) {
+	testInvalidValues(t, newTestSSLConfig, "SessionTicketKeyRotation", "sessionTicketKeyRotation", []string{"0", "-1", "foobar", "0h", "12", "1M", "1.5h"})
+}
+
+func TestValidSessionTicketKeyRotation(t *testing.T) {
+	testValidValues(t, newTestSSLConfig, "SessionTicketKeyRotation", "sessionTicketKeyRotation", []string{"30s", "90m", "12h", "1d", "1w"})
+}
+
+func TestInvalidOCSPStapling(t *testing.T) {
+	testInvalidValues(t, newTestOCSPConfig, "Stapling", "stapling", []string{"0", "-1", "foobar"})
+}
+
+func TestValidOCSPStapling(t *testing.T) {
+	testValidValues(t, newTestOCSPConfig, "Stapling", "stapling", []string{"true", "false", "TRUE", "FALSE"})
+}
+
+func TestInvalidOCSPVerify(t *testing.T) {
+	testInvalidValues(t, newTestOCSPConfig, "Verify", "verify", []string{"0", "-1", "foobar"})
+}
+
+func TestValidOCSPVerify(t *testing.T) {
+	testValidValues(t, newTestOCSPConfig, "Verify", "verify", []string{"true", "false", "TRUE", "FALSE"})
+}
+
+func TestInvalidOCSPResolver(t *testing.T) {
+	testInvalidValues(t, newTestOCSPConfig, "Resolver", "resolver", []string{"10.0.0.2;", "foo/bar", "10.0.0.2 ipv6=maybe"})
+}
+
+func TestValidOCSPResolver(t *testing.T) {
+	testValidValues(t, newTestOCSPConfig, "Resolver", "resolver", []string{"10.0.0.2", "10.0.0.2 10.0.0.3:5353", "[::1]:53", "kube-dns.kube-system.svc.cluster.local", "10.0.0.2 ipv6=off"})
+}
+
 func TestInvalidEarlyDataMethods(t *testing.T) {
 	testInvalidValues(t, newTestSSLConfig, "EarlyDataMethods", "earlyDataMethods", []string{"0", "-1", "foobar", "GET||HEAD", "|GET", "GET|", "get|head"})
 }
@@ -383,6 +647,14 @@ func TestValidEarlyDataMethods(t *testing.T) {
 	testValidValues(t, newTestSSLConfig, "EarlyDataMethods", "earlyDataMethods", []string{"", "GET", "GET|HEAD", "GET|HEAD|OPTIONS"})
 }
 
+func TestInvalidExpiryWarningDays(t *testing.T) {
+	testInvalidValues(t, newTestSSLConfig, "ExpiryWarningDays", "expiryWarningDays", []string{"0", "-1", "foobar", "01"})
+}
+
+func TestValidExpiryWarningDays(t *testing.T) {
+	testValidValues(t, newTestSSLConfig, "ExpiryWarningDays", "expiryWarningDays", []string{"1", "14", "90"})
+}
+
 func TestInvalidProxyBuffersEnabled(t *testing.T) {
 	testInvalidValues(t, newTestProxyBuffersConfig, "Enabled", "enabled", []string{"0", "-1", "foobar"})
 }
@@ -474,10 +746,38 @@ func newTestAppConfig() (interface{}, error) {
 	return newAppConfig(routerConfig)
 }
 
+func newTestPathAccessConfig() (interface{}, error) {
+	return newPathAccessConfig(), nil
+}
+
+func newTestClientCertConfig() (interface{}, error) {
+	return newClientCertConfig(), nil
+}
+
+func newTestBackendConfig() (interface{}, error) {
+	return newBackendConfig(), nil
+}
+
 func newTestBuilderConfig() (interface{}, error) {
 	return newBuilderConfig(), nil
 }
 
+func newTestStreamConfig() (interface{}, error) {
+	return newStreamConfig(), nil
+}
+
+func newTestACMEConfig() (interface{}, error) {
+	return newACMEConfig(), nil
+}
+
+func newTestAppACMEConfig() (interface{}, error) {
+	return newAppACMEConfig(), nil
+}
+
+func newTestSelfSignedConfig() (interface{}, error) {
+	return newSelfSignedConfig(), nil
+}
+
 func newTestSSLConfig() (interface{}, error) {
 	return newSSLConfig(), nil
 }
@@ -486,8 +786,12 @@ func newTestHSTSConfig() (interface{}, error) {
 	return newHSTSConfig(), nil
 }
 
+func newTestOCSPConfig() (interface{}, error) {
+	return newOCSPConfig(), nil
+}
+
 func newTestProxyBuffersConfig() (interface{}, error) {
-	return newProxyBuffersConfig(nil)
+	return newProxyBuffersConfig(nil), nil
 }
 
 func checkError(t *testing.T, value string, err error) {
@@ -0,0 +1,252 @@
+package monitor
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/teamhephy/router/model"
+	"github.com/teamhephy/router/utils"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// How often to check for expiring certificates when the configuration doesn't change.
+	checkInterval = time.Hour
+	// Name of the router's own service, on which warnings about the platform certificate are
+	// emitted.
+	routerServiceName = "deis-router"
+)
+
+var namespace = utils.GetOpt("POD_NAMESPACE", "default")
+
+// Expiry describes when the certificate securing one of an app's domains expires. Generated
+// tells certificates the router generated itself apart from those it was given.
+type Expiry struct {
+	Domain    string    `json:"domain"`
+	App       string    `json:"app"`
+	Namespace string    `json:"namespace"`
+	Service   string    `json:"service"`
+	NotAfter  time.Time `json:"notAfter"`
+	Generated bool      `json:"generated"`
+}
+
+// message describes the expiring certificate in logs and events.
+func (e Expiry) message() string {
+	kind := "certificate"
+	if e.Generated {
+		kind = "generated certificate"
+	}
+	return fmt.Sprintf("The %s for %s expires on %s.", kind, e.Domain, e.NotAfter.UTC().Format(time.RFC3339))
+}
+
+// Monitor keeps track of when the certificates in use expire. It exposes their expiry as metrics
+// and through a debug endpoint, and emits warning events on the services owning domains whose
+// certificates are about to expire. Every replica emits the same warnings under the same names on
+// the same day, so that only the first one emitted is kept.
+type Monitor struct {
+	kubeClient *kubernetes.Clientset
+	configs    chan *model.RouterConfig
+	mutex      sync.RWMutex
+	expiries   []Expiry
+	// When a warning was last emitted for each domain; warnings are emitted at most once per UTC
+	// day.
+	warned map[string]time.Time
+	// emit emits a warning about the expiry; it is replaced in tests.
+	emit func(expiry Expiry, now time.Time) error
+}
+
+// NewMonitor returns a Monitor that emits events using the given client.
+func NewMonitor(kubeClient *kubernetes.Clientset) *Monitor {
+	m := &Monitor{
+		kubeClient: kubeClient,
+		configs:    make(chan *model.RouterConfig, 1),
+		warned:     make(map[string]time.Time),
+	}
+	m.emit = m.emitEvent
+	return m
+}
+
+// Update hands the Monitor the latest router configuration. It never blocks; if the Monitor is
+// busy, any configuration it has not picked up yet is replaced.
+func (m *Monitor) Update(routerConfig *model.RouterConfig) {
+	for {
+		select {
+		case m.configs <- routerConfig:
+			return
+		default:
+		}
+		select {
+		case <-m.configs:
+		default:
+		}
+	}
+}
+
+// Run checks the certificates of the latest router configuration whenever it changes, and
+// periodically otherwise. It never returns.
+func (m *Monitor) Run() {
+	var routerConfig *model.RouterConfig
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case routerConfig = <-m.configs:
+		case <-ticker.C:
+		}
+		if routerConfig != nil {
+			m.check(routerConfig, time.Now())
+		}
+	}
+}
+
+// Serve exposes the metrics and debug endpoints on the given address. It only returns on error.
+func (m *Monitor) Serve(addr string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", m.serveMetrics)
+	mux.HandleFunc("/debug/certificates", m.serveCertificates)
+	return http.ListenAndServe(addr, mux)
+}
+
+func (m *Monitor) check(routerConfig *model.RouterConfig, now time.Time) {
+	expiries := buildExpiries(routerConfig)
+	m.mutex.Lock()
+	m.expiries = expiries
+	m.mutex.Unlock()
+	warnBefore := routerConfig.SSLConfig.ExpiryWarningDays
+	for _, expiry := range expiries {
+		if now.AddDate(0, 0, warnBefore).Before(expiry.NotAfter) {
+			continue
+		}
+		if warnedAt, ok := m.warned[expiry.Domain]; ok && day(warnedAt) == day(now) {
+			continue
+		}
+		log.Printf("WARN: %s\n", expiry.message())
+		if err := m.emit(expiry, now); err != nil {
+			log.Printf("WARN: Failed to emit an event about the expiring certificate for %s: %v\n", expiry.Domain, err)
+			continue
+		}
+		m.warned[expiry.Domain] = now
+	}
+}
+
+func (m *Monitor) emitEvent(expiry Expiry, now time.Time) error {
+	timestamp := metav1.NewTime(now)
+	event := &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      eventName(expiry, now),
+			Namespace: expiry.Namespace,
+		},
+		InvolvedObject: corev1.ObjectReference{
+			APIVersion: "v1",
+			Kind:       "Service",
+			Namespace:  expiry.Namespace,
+			Name:       expiry.Service,
+		},
+		Reason:         "CertificateExpiring",
+		Message:        expiry.message(),
+		Type:           corev1.EventTypeWarning,
+		Source:         corev1.EventSource{Component: "deis-router"},
+		FirstTimestamp: timestamp,
+		LastTimestamp:  timestamp,
+		Count:          1,
+	}
+	_, err := m.kubeClient.CoreV1().Events(expiry.Namespace).Create(event)
+	// Another replica may have emitted the same warning already.
+	if statusErr, ok := err.(*errors.StatusError); ok && statusErr.Status().Code == 409 {
+		return nil
+	}
+	return err
+}
+
+// eventName returns the name of the event warning about the expiry on the given day, which is the
+// same for every replica.
+func eventName(expiry Expiry, now time.Time) string {
+	hash := fnv.New32a()
+	hash.Write([]byte(expiry.Domain))
+	return fmt.Sprintf("%s.certificate-expiring.%08x.%s", expiry.Service, hash.Sum32(), day(now))
+}
+
+// day returns the UTC day of the time, such as "20200101".
+func day(t time.Time) string {
+	return t.UTC().Format("20060102")
+}
+
+func (m *Monitor) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	writeMetrics(w, m.expiries)
+}
+
+func (m *Monitor) serveCertificates(w http.ResponseWriter, r *http.Request) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	w.Header().Set("Content-Type", "application/json")
+	expiries := m.expiries
+	if expiries == nil {
+		expiries = []Expiry{}
+	}
+	if err := json.NewEncoder(w).Encode(expiries); err != nil {
+		log.Printf("WARN: Failed to write the list of certificates: %v\n", err)
+	}
+}
+
+// buildExpiries returns when the platform certificate, which also secures the default server, and
+// the certificate securing each of the apps' domains expire, soonest first.
+func buildExpiries(routerConfig *model.RouterConfig) []Expiry {
+	var expiries []Expiry
+	if certificate := routerConfig.PlatformCertificate; certificate != nil && !certificate.NotAfter.IsZero() {
+		domain := "platform"
+		if routerConfig.PlatformDomain != "" {
+			domain = fmt.Sprintf("*.%s", routerConfig.PlatformDomain)
+		}
+		expiries = append(expiries, Expiry{
+			Domain:    domain,
+			Namespace: namespace,
+			Service:   routerServiceName,
+			NotAfter:  certificate.NotAfter,
+		})
+	}
+	for _, appConfig := range routerConfig.AppConfigs {
+		for _, domain := range appConfig.Domains {
+			certificate := appConfig.Certificates[domain]
+			if certificate == nil || certificate.NotAfter.IsZero() {
+				continue
+			}
+			if !strings.Contains(domain, ".") && routerConfig.PlatformDomain != "" {
+				domain = fmt.Sprintf("%s.%s", domain, routerConfig.PlatformDomain)
+			}
+			expiries = append(expiries, Expiry{
+				Domain:    domain,
+				App:       appConfig.Name,
+				Namespace: appConfig.Namespace,
+				Service:   appConfig.ServiceName,
+				NotAfter:  certificate.NotAfter,
+				Generated: certificate.Generated,
+			})
+		}
+	}
+	sort.SliceStable(expiries, func(i, j int) bool {
+		return expiries[i].NotAfter.Before(expiries[j].NotAfter)
+	})
+	return expiries
+}
+
+func writeMetrics(w io.Writer, expiries []Expiry) {
+	fmt.Fprintln(w, "# HELP router_certificate_expiry_timestamp_seconds Time at which the certificate securing a domain expires.")
+	fmt.Fprintln(w, "# TYPE router_certificate_expiry_timestamp_seconds gauge")
+	for _, expiry := range expiries {
+		fmt.Fprintf(w, "router_certificate_expiry_timestamp_seconds{domain=%q,app=%q,namespace=%q,generated=\"%t\"} %d\n", expiry.Domain, expiry.App, expiry.Namespace, expiry.Generated, expiry.NotAfter.Unix())
+	}
+}
@@ -0,0 +1,174 @@
+package monitor
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/teamhephy/router/model"
+)
+
+var now = time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+func TestBuildExpiries(t *testing.T) {
+	routerConfig := newTestRouterConfig()
+	expiries := buildExpiries(routerConfig)
+	want := []Expiry{
+		{Domain: "www.example.com", App: "foo", Namespace: "foo", Service: "foo", NotAfter: now.AddDate(0, 0, 7), Generated: true},
+		{Domain: "foo.example.com", App: "foo", Namespace: "foo", Service: "foo", NotAfter: now.AddDate(0, 0, 60)},
+	}
+	if !reflect.DeepEqual(expiries, want) {
+		t.Errorf("Expected expiries %+v, got %+v", want, expiries)
+	}
+}
+
+func TestBuildExpiriesPlatform(t *testing.T) {
+	// Ensure the platform certificate, securing the default server, is checked as well.
+	routerConfig := newTestRouterConfig()
+	routerConfig.PlatformCertificate = &model.Certificate{NotAfter: now.AddDate(0, 0, 3)}
+	expiries := buildExpiries(routerConfig)
+	want := Expiry{Domain: "*.example.com", Namespace: namespace, Service: "deis-router", NotAfter: now.AddDate(0, 0, 3)}
+	if len(expiries) != 3 || expiries[0] != want {
+		t.Errorf("Expected the platform certificate's expiry %+v first, got %+v", want, expiries)
+	}
+}
+
+func TestEventName(t *testing.T) {
+	// Replicas must name the same warning alike, so that only one of them is kept, but warnings
+	// about other domains or on other days apart.
+	expiry := Expiry{Domain: "www.example.com", Service: "foo"}
+	name := eventName(expiry, now)
+	if again := eventName(expiry, now.Add(23*time.Hour)); again != name {
+		t.Errorf("Expected the same name on the same day, got %s and %s", name, again)
+	}
+	if other := eventName(expiry, now.AddDate(0, 0, 1)); other == name {
+		t.Errorf("Expected another name on another day, got %s", other)
+	}
+	if other := eventName(Expiry{Domain: "*.example.com", Service: "foo"}, now); other == name {
+		t.Errorf("Expected another name for another domain, got %s", other)
+	}
+	if !regexp.MustCompile(`^[a-z0-9]([-a-z0-9.]*[a-z0-9])?$`).MatchString(name) {
+		t.Errorf("Expected a valid event name, got %s", name)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	m := NewMonitor(nil)
+	var warned []string
+	m.emit = func(expiry Expiry, now time.Time) error {
+		warned = append(warned, expiry.Domain)
+		return nil
+	}
+	routerConfig := newTestRouterConfig()
+
+	m.check(routerConfig, now)
+	if want := []string{"www.example.com"}; !reflect.DeepEqual(warned, want) {
+		t.Errorf("Expected warnings for %v, got %v", want, warned)
+	}
+	// Warnings are not repeated within a day.
+	m.check(routerConfig, now.Add(time.Hour))
+	if len(warned) != 1 {
+		t.Errorf("Expected no repeated warning within a day, got %v", warned)
+	}
+	m.check(routerConfig, now.AddDate(0, 0, 1))
+	if len(warned) != 2 {
+		t.Errorf("Expected a repeated warning after a day, got %v", warned)
+	}
+	// Raising the threshold warns about the other domain as well.
+	routerConfig.SSLConfig.ExpiryWarningDays = 90
+	m.check(routerConfig, now.AddDate(0, 0, 1))
+	if want := "foo.example.com"; warned[len(warned)-1] != want {
+		t.Errorf("Expected a warning for %s, got %v", want, warned)
+	}
+}
+
+func TestCheckRetriesFailedWarnings(t *testing.T) {
+	m := NewMonitor(nil)
+	attempts := 0
+	m.emit = func(expiry Expiry, now time.Time) error {
+		attempts++
+		return errors.New("boom")
+	}
+	routerConfig := newTestRouterConfig()
+	m.check(routerConfig, now)
+	m.check(routerConfig, now.Add(time.Hour))
+	if attempts != 2 {
+		t.Errorf("Expected a failed warning to be retried, got %d attempts", attempts)
+	}
+}
+
+func TestWriteMetrics(t *testing.T) {
+	var buf bytes.Buffer
+	writeMetrics(&buf, buildExpiries(newTestRouterConfig()))
+	want := `# HELP router_certificate_expiry_timestamp_seconds Time at which the certificate securing a domain expires.
+# TYPE router_certificate_expiry_timestamp_seconds gauge
+router_certificate_expiry_timestamp_seconds{domain="www.example.com",app="foo",namespace="foo",generated="true"} 1578441600
+router_certificate_expiry_timestamp_seconds{domain="foo.example.com",app="foo",namespace="foo",generated="false"} 1583020800
+`
+	if got := buf.String(); got != want {
+		t.Errorf("Expected metrics:\n%s\ngot:\n%s", want, got)
+	}
+}
+
+func TestServeCertificates(t *testing.T) {
+	m := NewMonitor(nil)
+	m.emit = func(expiry Expiry, now time.Time) error { return nil }
+
+	recorder := httptest.NewRecorder()
+	m.serveCertificates(recorder, httptest.NewRequest("GET", "/debug/certificates", nil))
+	if got := recorder.Body.String(); got != "[]\n" {
+		t.Errorf("Expected an empty list before the first check, got %q", got)
+	}
+
+	m.check(newTestRouterConfig(), now)
+	recorder = httptest.NewRecorder()
+	m.serveCertificates(recorder, httptest.NewRequest("GET", "/debug/certificates", nil))
+	var expiries []Expiry
+	if err := json.Unmarshal(recorder.Body.Bytes(), &expiries); err != nil {
+		t.Fatal(err)
+	}
+	if want := buildExpiries(newTestRouterConfig()); !reflect.DeepEqual(expiries, want) {
+		t.Errorf("Expected expiries %+v, got %+v", want, expiries)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	m := NewMonitor(nil)
+	first := &model.RouterConfig{}
+	second := &model.RouterConfig{}
+	// Neither update may block, and only the latest configuration should be picked up.
+	m.Update(first)
+	m.Update(second)
+	if got := <-m.configs; got != second {
+		t.Errorf("Expected the latest configuration to be picked up.")
+	}
+	select {
+	case <-m.configs:
+		t.Errorf("Expected no other configuration to be picked up.")
+	default:
+	}
+}
+
+func newTestRouterConfig() *model.RouterConfig {
+	return &model.RouterConfig{
+		PlatformDomain: "example.com",
+		SSLConfig:      &model.SSLConfig{ExpiryWarningDays: 14},
+		AppConfigs: []*model.AppConfig{
+			{
+				Name:        "foo",
+				Namespace:   "foo",
+				ServiceName: "foo",
+				Domains:     []string{"foo", "www.example.com", "bar.example.com"},
+				Certificates: map[string]*model.Certificate{
+					"foo":             {NotAfter: now.AddDate(0, 0, 60)},
+					"www.example.com": {NotAfter: now.AddDate(0, 0, 7), Generated: true},
+				},
+			},
+		},
+	}
+}
@@ -12,7 +12,7 @@ import (
 )
 
 const (
-	confTemplate = `{{ $routerConfig := . }}daemon off;
+	confTemplate = `{{ $routerConfig := . }}{{ $tlsPassthrough := false }}{{ range $appConfig := $routerConfig.AppConfigs }}{{ if $appConfig.TLSPassthrough }}{{ $tlsPassthrough = true }}{{ end }}{{ end }}{{ $handovers := list false }}{{ if $tlsPassthrough }}{{ $handovers = list false true }}{{ end }}daemon off;
 pid /tmp/nginx.pid;
 worker_processes {{ $routerConfig.WorkerProcesses }};
 
@@ -102,6 +102,8 @@ http {
 		default $server_port;
 		8080 80;
 		6443 443;
+		# Connections handed over from the stream block arrive on a unix socket, which has no port.
+		'' 443;
 	}
 	# 2. If the X-Forwarded-Port header has been set already (e.g. by a load balancer), use its
 	# value, otherwise, the port we're forwarding for is the $standard_server_port we determined
@@ -118,18 +120,11 @@ http {
 
 
 	{{ $sslConfig := $routerConfig.SSLConfig }}
-	{{ $hstsConfig := $sslConfig.HSTSConfig }}{{ if $hstsConfig.Enabled }}
+	{{ $hstsEnabled := false }}{{ range $appConfig := $routerConfig.AppConfigs }}{{ if $appConfig.SSLConfig.HSTSConfig.Enabled }}{{ $hstsEnabled = true }}{{ end }}{{ end }}{{ if $hstsEnabled }}
 	# HSTS instructs the browser to replace all HTTP links with HTTPS links for this domain until maxAge seconds from now.
-	# The $sts variable is used later in each server block.
-	map $access_scheme $sts {
-		'https' 'max-age={{ $hstsConfig.MaxAge }}{{ if $hstsConfig.IncludeSubDomains }}; includeSubDomains{{ end }}{{ if $hstsConfig.Preload }}; preload{{ end }}';
-	}
-	{{ end }}
-	{{ if ne $sslConfig.EarlyDataMethods "" }}
-	# Only allow early data (TLSv1.3 0-RTT) for select methods
-	map $request_method $ssl_block_early_data {
-		default $ssl_early_data;
-		"~^{{ $sslConfig.EarlyDataMethods }}$" 0;
+	# Each server using HSTS sets $hsts_policy to its own policy. The $sts variable is used later in each server block.
+	map "$access_scheme:$hsts_policy" $sts {
+		"~^https:(?<policy>.+)$" $policy;
 	}
 	{{ end }}
 
@@ -140,10 +135,6 @@ http {
 		}
 	{{ end }}
 
-	{{/* Since HSTS headers are not permitted on HTTP requests, 301 redirects to HTTPS resources are also necessary. */}}
-	{{/* This means we force HTTPS if HSTS is enabled. */}}
-	{{ $enforceSecure := or $sslConfig.Enforce $hstsConfig.Enabled }}
-
 	{{ if $routerConfig.DefaultServiceEnabled }}
 	server {
 		listen 8080 default_server{{ if $routerConfig.UseProxyProtocol }} proxy_protocol{{ end }};
@@ -178,9 +169,13 @@ http {
 	{{ else }}
 
 	# Default server handles requests for unmapped hostnames, including healthchecks
-	server {
-		listen 8080 default_server reuseport{{ if $routerConfig.UseProxyProtocol }} proxy_protocol{{ end }};
-		listen 6443 default_server ssl {{ if $routerConfig.HTTP2Enabled }}http2{{ end }} {{ if $routerConfig.UseProxyProtocol }}proxy_protocol{{ end }};
+	{{ range $handover := $handovers }}server {
+		{{ if $handover }}# TLS connections are handed over from the stream block using the PROXY protocol, which is
+		# only trusted here, so that plain HTTP connections keep honoring X-Forwarded-For.
+		listen unix:/tmp/router-https.sock default_server ssl {{ if $routerConfig.HTTP2Enabled }}http2{{ end }} proxy_protocol;
+		set_real_ip_from unix:;
+		real_ip_header proxy_protocol;{{ else }}listen 8080 default_server reuseport{{ if $routerConfig.UseProxyProtocol }} proxy_protocol{{ end }};
+		{{ if not $tlsPassthrough }}listen 6443 default_server ssl {{ if $routerConfig.HTTP2Enabled }}http2{{ end }} {{ if $routerConfig.UseProxyProtocol }}proxy_protocol{{ end }};{{ end }}{{ end }}
 
 		set $app_name "router-default-vhost";
 		ssl_protocols {{ $sslConfig.Protocols }};
@@ -190,6 +185,16 @@ http {
 		{{ if $routerConfig.PlatformCertificate }}
 		ssl_certificate /opt/router/ssl/platform.crt;
 		ssl_certificate_key /opt/router/ssl/platform.key;
+		{{ range $alternate := $routerConfig.PlatformCertificate.Alternates }}ssl_certificate /opt/router/ssl/platform.{{ $alternate.KeyType }}.crt;
+		ssl_certificate_key /opt/router/ssl/platform.{{ $alternate.KeyType }}.key;
+		{{ end }}
+		{{ $ocspConfig := $sslConfig.OCSPConfig }}{{ if $ocspConfig.Stapling }}
+		ssl_stapling on;
+		{{ if $ocspConfig.Verify }}ssl_stapling_verify on;{{ end }}
+		{{ if ne $routerConfig.PlatformCertificate.TrustedChain "" }}ssl_trusted_certificate /opt/router/ssl/platform.chain.crt;{{ end }}
+		{{ if ne $routerConfig.PlatformCertificate.OCSPResponse "" }}ssl_stapling_file /opt/router/ssl/platform.ocsp;{{ end }}
+		{{ if ne $ocspConfig.Resolver "" }}resolver {{ $ocspConfig.Resolver }};{{ end }}
+		{{ end }}
 		{{ else }}
 		ssl_certificate /opt/router/ssl/default/default.crt;
 		ssl_certificate_key /opt/router/ssl/default/default.key;
@@ -197,6 +202,8 @@ http {
 		{{ if ne $sslConfig.SessionCache "" }}ssl_session_cache {{ $sslConfig.SessionCache }};
 		ssl_session_timeout {{ $sslConfig.SessionTimeout }};{{ end }}
 		ssl_session_tickets {{ if $sslConfig.UseSessionTickets }}on{{ else }}off{{ end }};
+		{{ if $sslConfig.UseSessionTickets }}{{ range $i, $key := $sslConfig.SessionTicketKeys }}ssl_session_ticket_key /opt/router/ssl/tickets/{{ $i }}.key;
+		{{ end }}{{ end }}
 		ssl_buffer_size {{ $sslConfig.BufferSize }};
 		{{ if ne $sslConfig.DHParam "" }}ssl_dhparam /opt/router/ssl/dhparam.pem;{{ end }}
 		{{ if ne $routerConfig.ReferrerPolicy "" }}
@@ -212,7 +219,7 @@ http {
 			return 404;
 		}
 	}
-	{{ end }}
+	{{ end }}{{ end }}
 
 	# Healthcheck on 9090 -- never uses proxy_protocol
 	server {
@@ -240,12 +247,15 @@ http {
 		}
 	}
 
-	{{range $appConfig := $routerConfig.AppConfigs}}{{range $domain := $appConfig.Domains}}server {
-		listen 8080{{ if $routerConfig.UseProxyProtocol }} proxy_protocol{{ end }};
+	{{range $appConfig := $routerConfig.AppConfigs}}{{range $domain := $appConfig.Domains}}{{ $terminatesTLS := and (index $appConfig.Certificates $domain) (not $appConfig.TLSPassthrough) }}{{ range $handover := $handovers }}{{ if or (not $handover) $terminatesTLS }}server {
+		{{ if $handover }}listen unix:/tmp/router-https.sock ssl {{ if $routerConfig.HTTP2Enabled }}http2{{ end }} proxy_protocol;
+		set_real_ip_from unix:;
+		real_ip_header proxy_protocol;{{ else }}listen 8080{{ if $routerConfig.UseProxyProtocol }} proxy_protocol{{ end }};{{ end }}
 		server_name {{ if and $routerConfig.EnableRegexDomains (contains $domain $appConfig.RegexDomain)}}~^{{$domain}}\.(?<domain>.+)$ ~^{{$appConfig.RegexDomain}}\.(?<domain>.+)${{ else if contains "." $domain }}{{ $domain }}{{ else if ne $routerConfig.PlatformDomain "" }}{{ $domain }}.{{ $routerConfig.PlatformDomain }}{{ else }}~^{{ $domain }}\.(?<domain>.+)${{ end }};
 		server_name_in_redirect off;
 		port_in_redirect off;
 		set $app_name "{{ $appConfig.Name }}";
+		{{ $appSSLConfig := $appConfig.SSLConfig }}{{ $appHSTSConfig := $appSSLConfig.HSTSConfig }}{{ if $appHSTSConfig.Enabled }}set $hsts_policy 'max-age={{ $appHSTSConfig.MaxAge }}{{ if $appHSTSConfig.IncludeSubDomains }}; includeSubDomains{{ end }}{{ if $appHSTSConfig.Preload }}; preload{{ end }}';{{ end }}
 
 		{{ if $routerConfig.LoadModsecurityModule -}}
 		# Turning on modsecurity if modsecurity module loaded
@@ -253,19 +263,37 @@ http {
 		modsecurity_rules_file /opt/router/conf/modsecurity.conf;
 		{{- end }}
 
-		{{ if index $appConfig.Certificates $domain }}
-		listen 6443 ssl {{ if $routerConfig.HTTP2Enabled }}http2{{ end }} {{ if $routerConfig.UseProxyProtocol }}proxy_protocol{{ end }};
-		ssl_protocols {{ $sslConfig.Protocols }};
-		{{ if ne $sslConfig.Ciphers "" }}ssl_ciphers {{ $sslConfig.Ciphers }};{{ end }}
+		{{ if and $terminatesTLS (or $handover (not $tlsPassthrough)) }}
+		{{ if not $handover }}listen 6443 ssl {{ if $routerConfig.HTTP2Enabled }}http2{{ end }} {{ if $routerConfig.UseProxyProtocol }}proxy_protocol{{ end }};{{ end }}
+		ssl_protocols {{ $appSSLConfig.Protocols }};
+		{{ if ne $appSSLConfig.Ciphers "" }}ssl_ciphers {{ $appSSLConfig.Ciphers }};{{ end }}
 		ssl_prefer_server_ciphers on;
-		ssl_early_data {{ if ne $sslConfig.EarlyDataMethods "" }}on{{ else }}off{{ end }};
+		ssl_early_data {{ if ne $appSSLConfig.EarlyDataMethods "" }}on{{ else }}off{{ end }};
 		ssl_certificate /opt/router/ssl/{{ $domain }}.crt;
 		ssl_certificate_key /opt/router/ssl/{{ $domain }}.key;
-		{{ if ne $sslConfig.SessionCache "" }}ssl_session_cache {{ $sslConfig.SessionCache }};
-		ssl_session_timeout {{ $sslConfig.SessionTimeout }};{{ end }}
-		ssl_session_tickets {{ if $sslConfig.UseSessionTickets }}on{{ else }}off{{ end }};
-		ssl_buffer_size {{ $sslConfig.BufferSize }};
+		{{ $certificate := index $appConfig.Certificates $domain }}{{ range $alternate := $certificate.Alternates }}ssl_certificate /opt/router/ssl/{{ $domain }}.{{ $alternate.KeyType }}.crt;
+		ssl_certificate_key /opt/router/ssl/{{ $domain }}.{{ $alternate.KeyType }}.key;
+		{{ end }}
+		{{ $ocspConfig := $appSSLConfig.OCSPConfig }}{{ if $ocspConfig.Stapling }}
+		ssl_stapling on;
+		{{ if $ocspConfig.Verify }}ssl_stapling_verify on;{{ end }}
+		{{ if ne $certificate.TrustedChain "" }}ssl_trusted_certificate /opt/router/ssl/{{ $domain }}.chain.crt;{{ end }}
+		{{ if ne $certificate.OCSPResponse "" }}ssl_stapling_file /opt/router/ssl/{{ $domain }}.ocsp;{{ end }}
+		{{ if ne $ocspConfig.Resolver "" }}resolver {{ $ocspConfig.Resolver }};{{ end }}
+		{{ end }}
+		{{ if ne $appSSLConfig.SessionCache "" }}ssl_session_cache {{ $appSSLConfig.SessionCache }};
+		ssl_session_timeout {{ $appSSLConfig.SessionTimeout }};{{ end }}
+		ssl_session_tickets {{ if $appSSLConfig.UseSessionTickets }}on{{ else }}off{{ end }};
+		{{ if $appSSLConfig.UseSessionTickets }}{{ range $i, $key := $sslConfig.SessionTicketKeys }}ssl_session_ticket_key /opt/router/ssl/tickets/{{ $i }}.key;
+		{{ end }}{{ end }}
+		ssl_buffer_size {{ $appSSLConfig.BufferSize }};
 		{{ if ne $sslConfig.DHParam "" }}ssl_dhparam /opt/router/ssl/dhparam.pem;{{ end }}
+		{{ $clientCertConfig := $appConfig.ClientCertConfig }}{{ if ne $clientCertConfig.Verify "off" }}
+		{{ if ne $clientCertConfig.CA "" }}ssl_client_certificate /opt/router/ssl/{{ $clientCertConfig.Name }}-ca.crt;{{ end }}
+		{{ if ne $clientCertConfig.CRL "" }}ssl_crl /opt/router/ssl/{{ $clientCertConfig.Name }}-ca.crl;{{ end }}
+		ssl_verify_client {{ $clientCertConfig.Verify }};
+		ssl_verify_depth {{ $clientCertConfig.VerifyDepth }};
+		{{ end }}
 		{{ end }}
 
 		{{ if or $routerConfig.EnforceWhitelists (or (ne (len $routerConfig.DefaultWhitelist) 0) (ne (len $appConfig.Whitelist) 0)) }}
@@ -276,11 +304,29 @@ http {
 
 		vhost_traffic_status_filter_by_set_key {{ $appConfig.Name }} application::*;
 
-		if ($ssl_block_early_data) {
+		{{ if $appConfig.ACMEConfig.Enabled }}
+		# Responses to ACME HTTP-01 challenges are written here by the router itself.
+		location ^~ /.well-known/acme-challenge/ {
+			allow all;
+			root /opt/router/acme;
+			default_type text/plain;
+		}
+		{{ end }}
+
+		{{ if ne $appSSLConfig.EarlyDataMethods "" }}
+		# Only allow early data (TLSv1.3 0-RTT) for select methods
+		set $early_data_request "$ssl_early_data:$request_method";
+		if ($early_data_request ~ "^1:(?!({{ $appSSLConfig.EarlyDataMethods }})$)") {
 			return 425;
 		}
+		{{ end }}
 
-		{{range $location := $appConfig.Locations}}
+		{{ $hasGRPC := false }}{{ if $appConfig.TLSPassthrough }}
+		# TLS is terminated by the application itself, so plain HTTP requests can only be redirected.
+		location / {
+			return 301 https://$host$request_uri;
+		}
+		{{ else }}{{range $location := $appConfig.Locations}}
 			location {{ $location.Path }} {
 				{{ if $routerConfig.RequestIDs }}
 				add_header X-Request-Id $request_id always;
@@ -290,7 +336,34 @@ http {
 				{{ if (and (ne $appConfig.ReferrerPolicy "")  (ne $appConfig.ReferrerPolicy "none")) }}add_header Referrer-Policy {{ $appConfig.ReferrerPolicy }};
 				{{ else if (and (ne $routerConfig.ReferrerPolicy "") (and (ne $appConfig.ReferrerPolicy "none") (ne $routerConfig.ReferrerPolicy "none"))) }}add_header Referrer-Policy {{ $routerConfig.ReferrerPolicy }};{{ end }}
 
+				{{ if $location.Access }}{{ range $denyEntry := $location.Access.Deny }}deny {{ $denyEntry }};
+				{{ end }}{{ range $allowEntry := $location.Access.Allow }}allow {{ $allowEntry }};
+				{{ end }}{{ if or (ne (len $location.Access.Allow) 0) $location.Access.DenyAll }}deny all;{{ end }}
+				{{ if $location.Access.BasicAuth }}auth_basic "{{ $appConfig.Name }}";
+				auth_basic_user_file /opt/router/auth/{{ $location.Access.BasicAuth.Name }}.htpasswd;{{ end }}
+				{{ end }}
+
 				{{ if $location.App.Maintenance }}return 503;{{ else if $location.App.Available }}
+				{{ $backendConfig := $location.App.BackendConfig }}{{ $grpc := eq $backendConfig.Protocol "grpc" "grpcs" "h2c" }}{{ $upstreamModule := "proxy" }}{{ if $grpc }}{{ $upstreamModule = "grpc" }}{{ $hasGRPC = true }}
+				grpc_set_header Host $host;
+				grpc_set_header X-Forwarded-For $remote_addr;
+				grpc_set_header X-Forwarded-Proto $access_scheme;
+				grpc_set_header X-Forwarded-Port $forwarded_port;
+				grpc_connect_timeout {{ $location.App.ConnectTimeout }};
+				grpc_send_timeout {{ $location.App.TCPTimeout }};
+				grpc_read_timeout {{ $location.App.TCPTimeout }};
+				{{ if $routerConfig.RequestIDs }}
+				grpc_set_header X-Request-Id $request_id;
+				grpc_set_header X-Correlation-Id $correlation_id;
+				{{ end }}
+				{{ if $appConfig.ClientCertConfig.ForwardHeaders }}
+				grpc_set_header X-SSL-Client-Verify $ssl_client_verify;
+				grpc_set_header X-SSL-Client-DN $ssl_client_s_dn;
+				grpc_set_header X-SSL-Client-Fingerprint $ssl_client_fingerprint;
+				{{ end }}
+				{{ if ne $backendConfig.Protocol "h2c" }}error_page 502 503 = @grpc_unavailable;
+				error_page 504 = @grpc_deadline_exceeded;{{ end }}
+				{{ else }}
 				proxy_buffering {{ if $location.App.Nginx.ProxyBuffersConfig.Enabled }}on{{ else }}off{{ end }};
 				proxy_buffer_size {{ $location.App.Nginx.ProxyBuffersConfig.Size }};
 				proxy_buffers {{ $location.App.Nginx.ProxyBuffersConfig.Number }} {{ $location.App.Nginx.ProxyBuffersConfig.Size }};
@@ -306,24 +379,64 @@ http {
 				proxy_http_version 1.1;
 				proxy_set_header Upgrade $http_upgrade;
 				proxy_set_header Connection $connection_upgrade;
-				{{ if ne $sslConfig.EarlyDataMethods "" }}proxy_set_header Early-Data $ssl_early_data;{{ end }}
+				{{ if ne $appSSLConfig.EarlyDataMethods "" }}proxy_set_header Early-Data $ssl_early_data;{{ end }}
 				{{ if $routerConfig.RequestIDs }}
 				proxy_set_header X-Request-Id $request_id;
 				proxy_set_header X-Correlation-Id $correlation_id;
 				{{ end }}
+				{{ if $appConfig.ClientCertConfig.ForwardHeaders }}
+				proxy_set_header X-SSL-Client-Verify $ssl_client_verify;
+				proxy_set_header X-SSL-Client-DN $ssl_client_s_dn;
+				proxy_set_header X-SSL-Client-Fingerprint $ssl_client_fingerprint;
+				{{ end }}
 				{{ if and $routerConfig.RequestStartHeader (not $appConfig.DisableRequestStartHeader) }}
 				proxy_set_header X-Request-Start "t=${msec}";
 				{{ end }}
+				{{ end }}
 
-				{{ if or $enforceSecure $location.App.SSLConfig.Enforce }}if ($access_scheme !~* "^https|wss$") {
+				{{/* Client certificates are only verified over TLS, so plain HTTP requests must never reach the app. */}}
+				{{ if eq $appConfig.ClientCertConfig.Verify "on" "optional" }}if ($scheme != "https") {
+					return 301 https://$host$request_uri;
+				}{{ end }}
+
+				{{/* Since HSTS headers are not permitted on HTTP requests, 301 redirects to HTTPS resources are also necessary. */}}
+				{{/* This means we force HTTPS if HSTS is enabled. */}}
+				{{ if or $appSSLConfig.Enforce $appHSTSConfig.Enabled $location.App.SSLConfig.Enforce }}if ($access_scheme !~* "^https|wss$") {
 					return 301 $uri_scheme://$host$request_uri;
 				}{{ end }}
 
-				{{ if $hstsConfig.Enabled }}add_header Strict-Transport-Security $sts always;{{ end }}
+				{{ if $appHSTSConfig.Enabled }}add_header Strict-Transport-Security $sts always;{{ end }}
+
+				{{ if eq $backendConfig.Protocol "https" "grpcs" }}
+				{{ if ne $backendConfig.SNIName "" }}{{ $upstreamModule }}_ssl_server_name on;
+				{{ $upstreamModule }}_ssl_name {{ $backendConfig.SNIName }};{{ end }}
+				{{ if ne $backendConfig.CA "" }}{{ $upstreamModule }}_ssl_verify on;
+				{{ $upstreamModule }}_ssl_verify_depth {{ $backendConfig.VerifyDepth }};
+				{{ $upstreamModule }}_ssl_trusted_certificate /opt/router/ssl/{{ $backendConfig.Name }}.upstream-ca.crt;
+				{{ if ne $backendConfig.CRL "" }}{{ $upstreamModule }}_ssl_crl /opt/router/ssl/{{ $backendConfig.Name }}.upstream-ca.crl;{{ end }}{{ end }}
+				{{ if $backendConfig.ClientCertificate }}{{ $upstreamModule }}_ssl_certificate /opt/router/ssl/{{ $backendConfig.Name }}.upstream.crt;
+				{{ $upstreamModule }}_ssl_certificate_key /opt/router/ssl/{{ $backendConfig.Name }}.upstream.key;{{ end }}
+				{{ end }}
 
-				proxy_pass http://{{$location.App.ServiceIP}}:80;{{ else }}return 503;{{ end }}
+				{{ if $grpc }}grpc_pass {{ if eq $backendConfig.Protocol "grpcs" }}grpcs{{ else }}grpc{{ end }}://{{$location.App.ServiceIP}}:{{ $backendConfig.Port }};{{ else }}proxy_pass {{ $backendConfig.Protocol }}://{{$location.App.ServiceIP}}:{{ $backendConfig.Port }};{{ end }}{{ else }}return 503;{{ end }}
 			}
-		{{end}}
+		{{end}}{{ end }}
+
+		{{ if $hasGRPC }}
+		# Map errors generated by the router itself to gRPC status codes.
+		location @grpc_unavailable {
+			default_type application/grpc;
+			add_header grpc-status 14;
+			add_header grpc-message "unavailable";
+			return 204;
+		}
+		location @grpc_deadline_exceeded {
+			default_type application/grpc;
+			add_header grpc-status 4;
+			add_header grpc-message "deadline exceeded";
+			return 204;
+		}
+		{{ end }}
 
 		{{ if $appConfig.Maintenance }}error_page 503 @maintenance;
 			location @maintenance {
@@ -332,17 +445,63 @@ http {
 			}
 		{{ end }}
 	}
-
+	{{ end }}{{ end }}
 	{{end}}{{end}}
 }
 
-{{ if $routerConfig.BuilderConfig }}{{ $builderConfig := $routerConfig.BuilderConfig }}stream {
+{{ if or $routerConfig.BuilderConfig $routerConfig.StreamConfigs $tlsPassthrough }}stream {
+	{{ if $routerConfig.UseProxyProtocol }}{{ range $realIPCIDR := $routerConfig.ProxyRealIPCIDRs -}}
+	set_real_ip_from {{ $realIPCIDR }};
+	{{ end }}{{ end -}}
+	{{ if $tlsPassthrough }}set_real_ip_from unix:;
+
+	# Route TLS connections by SNI. Connections for apps that terminate TLS themselves are passed
+	# through untouched; all others are handed over to the http block to be terminated there.
+	map $ssl_preread_server_name $tls_upstream {
+		hostnames;
+		default unix:/tmp/router-https.sock;
+		{{ range $appConfig := $routerConfig.AppConfigs }}{{ if $appConfig.TLSPassthrough }}{{ range $domain := $appConfig.Domains }}{{ if contains "." $domain }}{{ $domain }}{{ else if ne $routerConfig.PlatformDomain "" }}{{ $domain }}.{{ $routerConfig.PlatformDomain }}{{ else }}~^{{ $domain }}\.{{ end }} unix:/tmp/router-passthrough-{{ $appConfig.BackendConfig.Name }}.sock;
+		{{ end }}{{ end }}{{ end }}
+	}
+
+	server {
+		listen 6443{{ if $routerConfig.UseProxyProtocol }} proxy_protocol{{ end }};
+		ssl_preread on;
+		proxy_protocol on;
+		proxy_pass $tls_upstream;
+	}
+	{{ range $appConfig := $routerConfig.AppConfigs }}{{ if $appConfig.TLSPassthrough }}
+	# {{ $appConfig.Name }}
 	server {
+		listen unix:/tmp/router-passthrough-{{ $appConfig.BackendConfig.Name }}.sock proxy_protocol;
+		{{ if or $routerConfig.EnforceWhitelists (or (ne (len $routerConfig.DefaultWhitelist) 0) (ne (len $appConfig.Whitelist) 0)) }}
+		{{ if or (eq (len $appConfig.Whitelist) 0) (eq $routerConfig.WhitelistMode "extend") }}{{ range $whitelistEntry := $routerConfig.DefaultWhitelist }}allow {{ $whitelistEntry }};{{ end }}{{ end }}
+		{{ range $whitelistEntry := $appConfig.Whitelist }}allow {{ $whitelistEntry }};{{ end }}
+		deny all;
+		{{ end }}
+		proxy_connect_timeout {{ $appConfig.ConnectTimeout }};
+		proxy_timeout {{ $appConfig.TCPTimeout }};
+		proxy_pass {{ $appConfig.ServiceIP }}:{{ $appConfig.BackendConfig.Port }};
+	}
+	{{ end }}{{ end }}{{ end -}}
+	{{ if $routerConfig.BuilderConfig }}{{ $builderConfig := $routerConfig.BuilderConfig }}server {
 		listen 2222 {{ if $routerConfig.UseProxyProtocol }}proxy_protocol{{ end }};
 		proxy_connect_timeout {{ $builderConfig.ConnectTimeout }};
 		proxy_timeout {{ $builderConfig.TCPTimeout }};
 		proxy_pass {{$builderConfig.ServiceIP}}:2222;
+	}{{ end }}
+	{{ range $streamConfig := $routerConfig.StreamConfigs }}{{ range $routerPort, $servicePort := $streamConfig.Ports }}
+	# {{ $streamConfig.Name }}
+	server {
+		listen {{ $routerPort }}{{ if eq $streamConfig.Protocol "udp" }} udp{{ else if $routerConfig.UseProxyProtocol }} proxy_protocol{{ end }};
+		{{ if $streamConfig.Whitelist }}{{ range $whitelistEntry := $streamConfig.Whitelist }}allow {{ $whitelistEntry }};
+		{{ end }}deny all;
+		{{ end }}{{ if ne $streamConfig.Protocol "udp" }}proxy_connect_timeout {{ $streamConfig.ConnectTimeout }};
+		{{ end }}proxy_timeout {{ $streamConfig.Timeout }};
+		{{ if and $streamConfig.ProxyProtocol (ne $streamConfig.Protocol "udp") }}proxy_protocol on;
+		{{ end }}proxy_pass {{ $streamConfig.ServiceIP }}:{{ $servicePort }};
 	}
+	{{ end }}{{ end }}
 }{{ end }}
 `
 )
@@ -359,6 +518,24 @@ func WriteCerts(routerConfig *model.RouterConfig, sslPath string) error {
 	if err != nil {
 		return err
 	}
+	allCRLsGlob, err := filepath.Glob(filepath.Join(sslPath, "*.crl"))
+	if err != nil {
+		return err
+	}
+	allOCSPResponsesGlob, err := filepath.Glob(filepath.Join(sslPath, "*.ocsp"))
+	if err != nil {
+		return err
+	}
+	for _, crl := range allCRLsGlob {
+		if err := os.Remove(crl); err != nil {
+			return err
+		}
+	}
+	for _, ocspResponse := range allOCSPResponsesGlob {
+		if err := os.Remove(ocspResponse); err != nil {
+			return err
+		}
+	}
 	for _, cert := range allCertsGlob {
 		if err := os.Remove(cert); err != nil {
 			return err
@@ -384,11 +561,63 @@ func WriteCerts(routerConfig *model.RouterConfig, sslPath string) error {
 				}
 			}
 		}
+		if appConfig.ClientCertConfig != nil && appConfig.ClientCertConfig.CA != "" {
+			err = writeClientCA(appConfig.ClientCertConfig, sslPath)
+			if err != nil {
+				return err
+			}
+		}
+		if appConfig.BackendConfig != nil {
+			err = writeBackendCerts(appConfig.BackendConfig, sslPath)
+			if err != nil {
+				return err
+			}
+		}
 	}
 	return nil
 }
 
+func writeClientCA(clientCertConfig *model.ClientCertConfig, sslPath string) error {
+	caPath := filepath.Join(sslPath, fmt.Sprintf("%s-ca.crt", clientCertConfig.Name))
+	err := ioutil.WriteFile(caPath, []byte(clientCertConfig.CA), 0644)
+	if err != nil {
+		return err
+	}
+	if clientCertConfig.CRL != "" {
+		crlPath := filepath.Join(sslPath, fmt.Sprintf("%s-ca.crl", clientCertConfig.Name))
+		return ioutil.WriteFile(crlPath, []byte(clientCertConfig.CRL), 0644)
+	}
+	return nil
+}
+
+// writeCert writes the certificate and its key, followed by each of its alternates, which are
+// distinguished by their key type, and the trusted chain and OCSP response, if any.
 func writeCert(context string, certificate *model.Certificate, sslPath string) error {
+	err := writeKeyPair(context, certificate, sslPath)
+	if err != nil {
+		return err
+	}
+	for _, alternate := range certificate.Alternates {
+		err = writeKeyPair(fmt.Sprintf("%s.%s", context, alternate.KeyType), alternate, sslPath)
+		if err != nil {
+			return err
+		}
+	}
+	if certificate.TrustedChain != "" {
+		chainPath := filepath.Join(sslPath, fmt.Sprintf("%s.chain.crt", context))
+		err = ioutil.WriteFile(chainPath, []byte(certificate.TrustedChain), 0644)
+		if err != nil {
+			return err
+		}
+	}
+	if certificate.OCSPResponse != "" {
+		ocspPath := filepath.Join(sslPath, fmt.Sprintf("%s.ocsp", context))
+		return ioutil.WriteFile(ocspPath, []byte(certificate.OCSPResponse), 0644)
+	}
+	return nil
+}
+
+func writeKeyPair(context string, certificate *model.Certificate, sslPath string) error {
 	certPath := filepath.Join(sslPath, fmt.Sprintf("%s.crt", context))
 	keyPath := filepath.Join(sslPath, fmt.Sprintf("%s.key", context))
 	err := ioutil.WriteFile(certPath, []byte(certificate.Cert), 0644)
@@ -398,6 +627,88 @@ func writeCert(context string, certificate *model.Certificate, sslPath string) e
 	return ioutil.WriteFile(keyPath, []byte(certificate.Key), 0600)
 }
 
+func writeBackendCerts(backendConfig *model.BackendConfig, sslPath string) error {
+	if backendConfig.ClientCertificate != nil {
+		err := writeCert(fmt.Sprintf("%s.upstream", backendConfig.Name), backendConfig.ClientCertificate, sslPath)
+		if err != nil {
+			return err
+		}
+	}
+	if backendConfig.CA != "" {
+		caPath := filepath.Join(sslPath, fmt.Sprintf("%s.upstream-ca.crt", backendConfig.Name))
+		err := ioutil.WriteFile(caPath, []byte(backendConfig.CA), 0644)
+		if err != nil {
+			return err
+		}
+	}
+	if backendConfig.CRL != "" {
+		crlPath := filepath.Join(sslPath, fmt.Sprintf("%s.upstream-ca.crl", backendConfig.Name))
+		return ioutil.WriteFile(crlPath, []byte(backendConfig.CRL), 0644)
+	}
+	return nil
+}
+
+// WriteHtpasswds writes htpasswd files for basic auth protected locations to file from router
+// configuration.
+func WriteHtpasswds(routerConfig *model.RouterConfig, authPath string) error {
+	err := os.MkdirAll(authPath, 0755)
+	if err != nil {
+		return err
+	}
+	// Start by deleting all htpasswd files. This will ensure files we no longer need are deleted.
+	// Files that are still needed will simply be re-written.
+	allHtpasswdsGlob, err := filepath.Glob(filepath.Join(authPath, "*.htpasswd"))
+	if err != nil {
+		return err
+	}
+	for _, htpasswd := range allHtpasswdsGlob {
+		if err := os.Remove(htpasswd); err != nil {
+			return err
+		}
+	}
+	for _, appConfig := range routerConfig.AppConfigs {
+		for _, location := range appConfig.Locations {
+			if location.Access != nil && location.Access.BasicAuth != nil {
+				basicAuth := location.Access.BasicAuth
+				htpasswdPath := filepath.Join(authPath, fmt.Sprintf("%s.htpasswd", basicAuth.Name))
+				err = ioutil.WriteFile(htpasswdPath, []byte(basicAuth.Htpasswd), 0600)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// WriteACMEChallenges writes the responses to pending ACME HTTP-01 challenges to files named
+// after their tokens, so that every replica answers challenges, whichever one is obtaining the
+// certificate.
+func WriteACMEChallenges(routerConfig *model.RouterConfig, challengePath string) error {
+	err := os.MkdirAll(challengePath, 0755)
+	if err != nil {
+		return err
+	}
+	// Start by deleting all responses. This will ensure responses to challenges that are no longer
+	// pending are deleted.
+	allResponsesGlob, err := filepath.Glob(filepath.Join(challengePath, "*"))
+	if err != nil {
+		return err
+	}
+	for _, response := range allResponsesGlob {
+		if err := os.Remove(response); err != nil {
+			return err
+		}
+	}
+	for token, response := range routerConfig.ACMEChallenges {
+		err = ioutil.WriteFile(filepath.Join(challengePath, token), []byte(response), 0644)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // WriteDHParam writes router DHParam to file from router configuration.
 func WriteDHParam(routerConfig *model.RouterConfig, sslPath string) error {
 	dhParamPath := filepath.Join(sslPath, "dhparam.pem")
@@ -415,10 +726,48 @@ func WriteDHParam(routerConfig *model.RouterConfig, sslPath string) error {
 	return nil
 }
 
+// WriteSessionTicketKeys writes the router's session ticket keys to file from router
+// configuration, naming them by their position so that the newest key, used for encrypting
+// tickets, comes first.
+func WriteSessionTicketKeys(routerConfig *model.RouterConfig, ticketsPath string) error {
+	err := os.MkdirAll(ticketsPath, 0700)
+	if err != nil {
+		return err
+	}
+	// Start by deleting all keys. This will ensure keys we no longer need are deleted.
+	allKeysGlob, err := filepath.Glob(filepath.Join(ticketsPath, "*.key"))
+	if err != nil {
+		return err
+	}
+	for _, key := range allKeysGlob {
+		if err := os.Remove(key); err != nil {
+			return err
+		}
+	}
+	for i, key := range routerConfig.SSLConfig.SessionTicketKeys {
+		keyPath := filepath.Join(ticketsPath, fmt.Sprintf("%d.key", i))
+		err = ioutil.WriteFile(keyPath, []byte(key), 0600)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// templateFuncs returns the functions available to the template: those of sprig, along with list,
+// which the version of sprig in use lacks.
+func templateFuncs() template.FuncMap {
+	funcs := sprig.TxtFuncMap()
+	funcs["list"] = func(items ...interface{}) []interface{} {
+		return items
+	}
+	return funcs
+}
+
 // WriteConfig dynamically produces valid nginx configuration by combining a Router configuration
 // object with a data-driven template.
 func WriteConfig(routerConfig *model.RouterConfig, filePath string) error {
-	tmpl, err := template.New("nginx").Funcs(sprig.TxtFuncMap()).Parse(confTemplate)
+	tmpl, err := template.New("nginx").Funcs(templateFuncs()).Parse(confTemplate)
 	if err != nil {
 		return err
 	}
@@ -5,13 +5,14 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"reflect"
 	"regexp"
+	"strings"
 	"testing"
 	"text/template"
 
-	"github.com/Masterminds/sprig"
 	"github.com/teamhephy/router/model"
 )
 
@@ -110,6 +111,222 @@ func TestWriteCert(t *testing.T) {
 	}
 }
 
+func TestWriteCertAlternates(t *testing.T) {
+	// Ensure alternates are written next to the certificate, named after their key type.
+	certificate := model.Certificate{
+		Cert:       "foo",
+		Key:        "bar",
+		KeyType:    "rsa",
+		Alternates: []*model.Certificate{{Cert: "biz", Key: "baz", KeyType: "ecdsa"}},
+	}
+
+	sslPath, err := ioutil.TempDir("", "test")
+	if err != nil {
+		t.Error(err)
+	}
+	defer os.RemoveAll(sslPath)
+
+	err = writeCert("test", &certificate, sslPath)
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = checkCertAndKey(filepath.Join(sslPath, "test.crt"), filepath.Join(sslPath, "test.key"), "foo", "bar")
+	if err != nil {
+		t.Error(err)
+	}
+	err = checkCertAndKey(filepath.Join(sslPath, "test.ecdsa.crt"), filepath.Join(sslPath, "test.ecdsa.key"), "biz", "baz")
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestWriteCertOCSP(t *testing.T) {
+	// Ensure the trusted chain and OCSP response are written next to the certificate.
+	certificate := model.Certificate{
+		Cert:         "foo",
+		Key:          "bar",
+		TrustedChain: "biz",
+		OCSPResponse: "baz",
+	}
+
+	sslPath, err := ioutil.TempDir("", "test")
+	if err != nil {
+		t.Error(err)
+	}
+	defer os.RemoveAll(sslPath)
+
+	err = writeCert("test", &certificate, sslPath)
+	if err != nil {
+		t.Error(err)
+	}
+
+	for file, expectedContents := range map[string]string{"test.chain.crt": "biz", "test.ocsp": "baz"} {
+		contents, err := ioutil.ReadFile(filepath.Join(sslPath, file))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if string(contents) != expectedContents {
+			t.Errorf("Expected %s to contain '%s', got '%s'", file, expectedContents, contents)
+		}
+	}
+}
+
+func TestWriteClientCA(t *testing.T) {
+	sslPath, err := ioutil.TempDir("", "test")
+	if err != nil {
+		t.Error(err)
+	}
+	defer os.RemoveAll(sslPath)
+
+	clientCertConfig := model.ClientCertConfig{
+		Name: "deis-partners",
+		CA:   "foo",
+		CRL:  "bar",
+	}
+	err = writeClientCA(&clientCertConfig, sslPath)
+	if err != nil {
+		t.Error(err)
+	}
+
+	for path, expected := range map[string]string{"deis-partners-ca.crt": "foo", "deis-partners-ca.crl": "bar"} {
+		actual, err := ioutil.ReadFile(filepath.Join(sslPath, path))
+		if err != nil {
+			t.Error(err)
+		}
+		if !reflect.DeepEqual(expected, string(actual)) {
+			t.Errorf("Expected %s contents, %s, does not match actual contents, %s.", path, expected, string(actual))
+		}
+	}
+}
+
+func TestWriteBackendCerts(t *testing.T) {
+	sslPath, err := ioutil.TempDir("", "test")
+	if err != nil {
+		t.Error(err)
+	}
+	defer os.RemoveAll(sslPath)
+
+	backendConfig := model.BackendConfig{
+		Name:              "deis-foo",
+		CA:                "foo",
+		ClientCertificate: &model.Certificate{Cert: "biz", Key: "baz"},
+	}
+	err = writeBackendCerts(&backendConfig, sslPath)
+	if err != nil {
+		t.Error(err)
+	}
+
+	caPath := filepath.Join(sslPath, "deis-foo.upstream-ca.crt")
+	actualCA, err := ioutil.ReadFile(caPath)
+	if err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual("foo", string(actualCA)) {
+		t.Errorf("Expected deis-foo.upstream-ca.crt contents, foo, does not match actual contents, %s.", string(actualCA))
+	}
+
+	crtPath := filepath.Join(sslPath, "deis-foo.upstream.crt")
+	keyPath := filepath.Join(sslPath, "deis-foo.upstream.key")
+	err = checkCertAndKey(crtPath, keyPath, "biz", "baz")
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestWriteHtpasswds(t *testing.T) {
+	authPath, err := ioutil.TempDir("", "test")
+	if err != nil {
+		t.Error(err)
+	}
+	defer os.RemoveAll(authPath)
+
+	// Create an extra htpasswd file to ensure it is correctly removed.
+	extraPath := filepath.Join(authPath, "extra.htpasswd")
+	err = ioutil.WriteFile(extraPath, []byte("foo"), 0600)
+	if err != nil {
+		t.Error(err)
+	}
+
+	expectedHtpasswd := "foo:bar"
+	routerConfig := model.RouterConfig{
+		AppConfigs: []*model.AppConfig{
+			{
+				Locations: []*model.Location{
+					{Path: "/"},
+					{
+						Path: "/admin",
+						Access: &model.LocationAccess{
+							BasicAuth: &model.BasicAuth{
+								Name:     "deis-admins",
+								Htpasswd: expectedHtpasswd,
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	err = WriteHtpasswds(&routerConfig, authPath)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if _, err := os.Stat(extraPath); err == nil {
+		t.Errorf("Expected extra.htpasswd to be removed, but the file was found.")
+	}
+
+	htpasswdPath := filepath.Join(authPath, "deis-admins.htpasswd")
+	actualHtpasswd, err := ioutil.ReadFile(htpasswdPath)
+	if err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(expectedHtpasswd, string(actualHtpasswd)) {
+		t.Errorf("Expected deis-admins.htpasswd contents, %s, does not match actual contents, %s.", expectedHtpasswd, string(actualHtpasswd))
+	}
+
+	expectedPerm := "-rw-------" // 0600
+	info, _ := os.Stat(htpasswdPath)
+	actualPerm := info.Mode().String()
+	if !reflect.DeepEqual(expectedPerm, actualPerm) {
+		t.Errorf("Expected permission on deis-admins.htpasswd, %s, does not match actual, %s.", expectedPerm, actualPerm)
+	}
+}
+
+func TestWriteACMEChallenges(t *testing.T) {
+	challengePath, err := ioutil.TempDir("", "test")
+	if err != nil {
+		t.Fatalf("Encountered an error: %v", err)
+	}
+	defer os.RemoveAll(challengePath)
+
+	// Create a response to a challenge that is no longer pending to ensure it is removed.
+	stalePath := filepath.Join(challengePath, "stale")
+	if err := ioutil.WriteFile(stalePath, []byte("stale.response"), 0644); err != nil {
+		t.Fatalf("Encountered an error: %v", err)
+	}
+
+	routerConfig := &model.RouterConfig{
+		ACMEChallenges: map[string]string{"token": "token.thumbprint"},
+	}
+	if err := WriteACMEChallenges(routerConfig, challengePath); err != nil {
+		t.Fatalf("Encountered an error: %v", err)
+	}
+
+	if _, err := os.Stat(stalePath); err == nil {
+		t.Errorf("Expected the stale response to be removed, but the file was found.")
+	}
+	actual, err := ioutil.ReadFile(filepath.Join(challengePath, "token"))
+	if err != nil {
+		t.Fatalf("Encountered an error: %v", err)
+	}
+	if string(actual) != "token.thumbprint" {
+		t.Errorf("Expected the response token.thumbprint, got %s", actual)
+	}
+}
+
 func TestWriteDHParam(t *testing.T) {
 	// Ensure sslPath/dhparam.pem exists with the contents of routerConfig.SSLConfig.DHParam and is 0644
 	sslPath, err := ioutil.TempDir("", "test")
@@ -171,6 +388,51 @@ func TestWriteDHParam(t *testing.T) {
 	}
 }
 
+func TestWriteSessionTicketKeys(t *testing.T) {
+	ticketsPath, err := ioutil.TempDir("", "test")
+	if err != nil {
+		t.Error(err)
+	}
+	defer os.RemoveAll(ticketsPath)
+
+	// Create an extra key to ensure it is correctly removed.
+	extraPath := filepath.Join(ticketsPath, "2.key")
+	err = ioutil.WriteFile(extraPath, []byte("foo"), 0600)
+	if err != nil {
+		t.Error(err)
+	}
+
+	expectedKeys := []string{"foo", "bar"}
+	routerConfig := model.RouterConfig{
+		SSLConfig: &model.SSLConfig{
+			SessionTicketKeys: expectedKeys,
+		},
+	}
+	err = WriteSessionTicketKeys(&routerConfig, ticketsPath)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if _, err := os.Stat(extraPath); err == nil {
+		t.Errorf("Expected 2.key to be removed, but the file was found.")
+	}
+	for i, expectedKey := range expectedKeys {
+		keyPath := filepath.Join(ticketsPath, fmt.Sprintf("%d.key", i))
+		actualKey, err := ioutil.ReadFile(keyPath)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if expectedKey != string(actualKey) {
+			t.Errorf("Expected %d.key contents, %s, does not match actual contents, %s.", i, expectedKey, string(actualKey))
+		}
+		info, _ := os.Stat(keyPath)
+		if actualPerm := info.Mode().String(); actualPerm != "-rw-------" {
+			t.Errorf("Expected permission on %d.key, -rw-------, does not match actual, %s.", i, actualPerm)
+		}
+	}
+}
+
 func TestWriteConfig(t *testing.T) {
 	routerConfig := model.RouterConfig{}
 
@@ -261,6 +523,7 @@ func TestDisableServerTokens(t *testing.T) {
 				IncludeSubDomains: false,
 				Preload:           false,
 			},
+			OCSPConfig: &model.OCSPConfig{},
 		},
 
 		DisableServerTokens: true,
@@ -268,7 +531,7 @@ func TestDisableServerTokens(t *testing.T) {
 
 	var b bytes.Buffer
 
-	tmpl, err := template.New("nginx").Funcs(sprig.TxtFuncMap()).Parse(confTemplate)
+	tmpl, err := template.New("nginx").Funcs(templateFuncs()).Parse(confTemplate)
 
 	if err != nil {
 		t.Fatalf("Encountered an error: %v", err)
@@ -287,3 +550,615 @@ func TestDisableServerTokens(t *testing.T) {
 	}
 
 }
+
+func TestPathAccess(t *testing.T) {
+	routerConfig := newTestRouterConfig()
+	appConfig := newTestAppConfig("deis/foo", "foo.example.com")
+	appConfig.Locations = append(appConfig.Locations, &model.Location{
+		App:  appConfig,
+		Path: "/admin",
+		Access: &model.LocationAccess{
+			Allow: []string{"10.0.0.0/8"},
+			Deny:  []string{"10.1.2.3"},
+			BasicAuth: &model.BasicAuth{
+				Name:     "deis-admins",
+				Htpasswd: "foo:bar",
+			},
+		},
+	})
+	routerConfig.AppConfigs = []*model.AppConfig{appConfig}
+
+	b := renderTestConfig(t, routerConfig)
+
+	validDirective := regexp.MustCompile(`(?s)location /admin \{\s*deny 10\.1\.2\.3;\s*allow 10\.0\.0\.0/8;\s*deny all;\s*auth_basic "deis/foo";\s*auth_basic_user_file /opt/router/auth/deis-admins\.htpasswd;`)
+	if !validDirective.MatchString(b) {
+		t.Errorf("Expected: path access rules in the /admin location. Actual: no match")
+	}
+}
+
+func TestPathAccessDenyOnlyWhitelisted(t *testing.T) {
+	routerConfig := newTestRouterConfig()
+	routerConfig.EnforceWhitelists = true
+	appConfig := newTestAppConfig("deis/foo", "foo.example.com")
+	appConfig.Whitelist = []string{"10.0.0.0/8"}
+	appConfig.Locations = append(appConfig.Locations, &model.Location{
+		App:  appConfig,
+		Path: "/admin",
+		Access: &model.LocationAccess{
+			Allow:   []string{"10.0.0.0/8"},
+			Deny:    []string{"10.1.2.3"},
+			DenyAll: true,
+		},
+	}, &model.Location{
+		App:  appConfig,
+		Path: "/closed",
+		Access: &model.LocationAccess{
+			Deny:    []string{"10.1.2.3"},
+			DenyAll: true,
+		},
+	})
+	routerConfig.AppConfigs = []*model.AppConfig{appConfig}
+
+	b := renderTestConfig(t, routerConfig)
+
+	validDirective := regexp.MustCompile(`(?s)location /admin \{\s*deny 10\.1\.2\.3;\s*allow 10\.0\.0\.0/8;\s*deny all;`)
+	if !validDirective.MatchString(b) {
+		t.Errorf("Expected: the whitelist and deny all in the /admin location. Actual: no match")
+	}
+	validDirective = regexp.MustCompile(`(?s)location /closed \{\s*deny 10\.1\.2\.3;\s*deny all;`)
+	if !validDirective.MatchString(b) {
+		t.Errorf("Expected: deny all in the /closed location. Actual: no match")
+	}
+}
+
+func TestClientCertVerification(t *testing.T) {
+	routerConfig := newTestRouterConfig()
+	appConfig := newTestAppConfig("deis/foo", "foo.example.com")
+	appConfig.Certificates["foo.example.com"] = &model.Certificate{Cert: "foo", Key: "bar"}
+	appConfig.ClientCertConfig = &model.ClientCertConfig{
+		Verify:         "optional",
+		VerifyDepth:    2,
+		ForwardHeaders: true,
+		Name:           "deis-partners",
+		CA:             "foo",
+		CRL:            "bar",
+	}
+	routerConfig.AppConfigs = []*model.AppConfig{appConfig}
+
+	b := renderTestConfig(t, routerConfig)
+
+	for _, directive := range []string{
+		`ssl_client_certificate /opt/router/ssl/deis-partners-ca\.crt;`,
+		`ssl_crl /opt/router/ssl/deis-partners-ca\.crl;`,
+		`ssl_verify_client optional;`,
+		`ssl_verify_depth 2;`,
+		`proxy_set_header X-SSL-Client-DN \$ssl_client_s_dn;`,
+		`proxy_set_header X-SSL-Client-Fingerprint \$ssl_client_fingerprint;`,
+		`if \(\$scheme != "https"\) \{`,
+	} {
+		validDirective := regexp.MustCompile(`(?m)^\s*` + directive + `$`)
+		if !validDirective.MatchString(b) {
+			t.Errorf("Expected: '%s' in the configuration. Actual: no match", directive)
+		}
+	}
+
+	// Ensure plain HTTP isn't redirected when clients aren't verified.
+	appConfig.ClientCertConfig.Verify = "optional_no_ca"
+	b = renderTestConfig(t, routerConfig)
+	if strings.Contains(b, `if ($scheme != "https")`) {
+		t.Errorf("Expected: no redirect to HTTPS in the configuration. Actual: found one")
+	}
+}
+
+func TestBackendTLS(t *testing.T) {
+	routerConfig := newTestRouterConfig()
+	appConfig := newTestAppConfig("deis/foo", "foo.example.com")
+	appConfig.BackendConfig = &model.BackendConfig{
+		Protocol:          "https",
+		Port:              "8443",
+		SNIName:           "foo.deis.svc",
+		VerifyDepth:       2,
+		Name:              "deis-foo",
+		CA:                "foo",
+		ClientCertificate: &model.Certificate{Cert: "foo", Key: "bar"},
+	}
+	routerConfig.AppConfigs = []*model.AppConfig{appConfig}
+
+	b := renderTestConfig(t, routerConfig)
+
+	for _, directive := range []string{
+		`proxy_ssl_server_name on;`,
+		`proxy_ssl_name foo\.deis\.svc;`,
+		`proxy_ssl_verify on;`,
+		`proxy_ssl_verify_depth 2;`,
+		`proxy_ssl_trusted_certificate /opt/router/ssl/deis-foo\.upstream-ca\.crt;`,
+		`proxy_ssl_certificate /opt/router/ssl/deis-foo\.upstream\.crt;`,
+		`proxy_ssl_certificate_key /opt/router/ssl/deis-foo\.upstream\.key;`,
+		`proxy_pass https://10\.0\.0\.1:8443;`,
+	} {
+		validDirective := regexp.MustCompile(`(?m)^\s*` + directive + `$`)
+		if !validDirective.MatchString(b) {
+			t.Errorf("Expected: '%s' in the configuration. Actual: no match", directive)
+		}
+	}
+}
+
+func TestGRPCBackend(t *testing.T) {
+	routerConfig := newTestRouterConfig()
+	appConfig := newTestAppConfig("deis/foo", "foo.example.com")
+	appConfig.BackendConfig = &model.BackendConfig{
+		Protocol:    "grpcs",
+		Port:        "443",
+		SNIName:     "foo.deis.svc",
+		VerifyDepth: 1,
+		Name:        "deis-foo",
+		CA:          "foo",
+	}
+	routerConfig.AppConfigs = []*model.AppConfig{appConfig}
+
+	b := renderTestConfig(t, routerConfig)
+
+	for _, directive := range []string{
+		`grpc_connect_timeout 30s;`,
+		`grpc_read_timeout 1300s;`,
+		`grpc_ssl_name foo\.deis\.svc;`,
+		`grpc_ssl_verify on;`,
+		`grpc_ssl_trusted_certificate /opt/router/ssl/deis-foo\.upstream-ca\.crt;`,
+		`error_page 502 503 = @grpc_unavailable;`,
+		`location @grpc_unavailable \{`,
+		`add_header grpc-status 14;`,
+		`grpc_pass grpcs://10\.0\.0\.1:443;`,
+	} {
+		validDirective := regexp.MustCompile(`(?m)^\s*` + directive + `$`)
+		if !validDirective.MatchString(b) {
+			t.Errorf("Expected: '%s' in the configuration. Actual: no match", directive)
+		}
+	}
+	if regexp.MustCompile(`(?m)^\s*proxy_pass `).MatchString(b) {
+		t.Errorf("Expected: no 'proxy_pass' in the configuration of a gRPC back end. Actual: match")
+	}
+
+	// An h2c back end is proxied using cleartext gRPC.
+	appConfig.BackendConfig.Protocol = "h2c"
+	appConfig.BackendConfig.Port = "80"
+	b = renderTestConfig(t, routerConfig)
+	if !regexp.MustCompile(`(?m)^\s*grpc_pass grpc://10\.0\.0\.1:80;$`).MatchString(b) {
+		t.Errorf("Expected: 'grpc_pass grpc://10.0.0.1:80;' in the configuration. Actual: no match")
+	}
+	// Plain HTTP/2 clients of an h2c back end get real HTTP errors rather than gRPC statuses.
+	if regexp.MustCompile(`(?m)^\s*error_page .*@grpc_`).MatchString(b) {
+		t.Errorf("Expected: no gRPC error mapping for an h2c back end. Actual: match")
+	}
+}
+
+func TestStreams(t *testing.T) {
+	routerConfig := newTestRouterConfig()
+	routerConfig.BuilderConfig = &model.BuilderConfig{
+		ConnectTimeout: "10s",
+		TCPTimeout:     "1200s",
+		ServiceIP:      "10.0.0.2",
+	}
+	routerConfig.StreamConfigs = []*model.StreamConfig{
+		{
+			Name:           "tools/postgres",
+			Ports:          map[string]string{"15432": "5432"},
+			Protocol:       "tcp",
+			ConnectTimeout: "5s",
+			Timeout:        "1h",
+			ProxyProtocol:  true,
+			Whitelist:      []string{"10.0.0.0/8"},
+			ServiceIP:      "10.0.0.3",
+		},
+		{
+			Name:           "kube-system/kube-dns",
+			Ports:          map[string]string{"53": "53"},
+			Protocol:       "udp",
+			ConnectTimeout: "10s",
+			Timeout:        "10m",
+			ProxyProtocol:  true,
+			ServiceIP:      "10.0.0.4",
+		},
+	}
+
+	b := renderTestConfig(t, routerConfig)
+
+	for _, directive := range []string{
+		`proxy_pass 10\.0\.0\.2:2222;`,
+		`listen 15432;`,
+		`allow 10\.0\.0\.0/8;`,
+		`deny all;`,
+		`proxy_connect_timeout 5s;`,
+		`proxy_timeout 1h;`,
+		`proxy_protocol on;`,
+		`proxy_pass 10\.0\.0\.3:5432;`,
+		`listen 53 udp;`,
+		`proxy_pass 10\.0\.0\.4:53;`,
+	} {
+		validDirective := regexp.MustCompile(`(?m)^\s*` + directive + `$`)
+		if !validDirective.MatchString(b) {
+			t.Errorf("Expected: '%s' in the configuration. Actual: no match", directive)
+		}
+	}
+	// The PROXY protocol is only sent to TCP services.
+	if n := len(regexp.MustCompile(`(?m)^\s*proxy_protocol on;$`).FindAllString(b, -1)); n != 1 {
+		t.Errorf("Expected: 1 'proxy_protocol on;' in the configuration. Actual: %d", n)
+	}
+
+	// Streams are rendered without the builder, too.
+	routerConfig.BuilderConfig = nil
+	b = renderTestConfig(t, routerConfig)
+	if regexp.MustCompile(`(?m)^\s*listen 2222`).MatchString(b) {
+		t.Errorf("Expected: no 'listen 2222' in the configuration. Actual: match")
+	}
+	if !regexp.MustCompile(`(?m)^\s*listen 15432;$`).MatchString(b) {
+		t.Errorf("Expected: 'listen 15432;' in the configuration. Actual: no match")
+	}
+}
+
+func TestTLSPassthrough(t *testing.T) {
+	routerConfig := newTestRouterConfig()
+	routerConfig.PlatformDomain = "example.com"
+	terminated := newTestAppConfig("deis/foo", "foo.example.com")
+	terminated.Certificates["foo.example.com"] = &model.Certificate{}
+	passthrough := newTestAppConfig("deis/bar", "bar")
+	passthrough.TLSPassthrough = true
+	passthrough.Whitelist = []string{"1.2.3.4"}
+	passthrough.BackendConfig.Name = "deis-bar"
+	passthrough.BackendConfig.Port = "443"
+	routerConfig.AppConfigs = []*model.AppConfig{terminated, passthrough}
+
+	b := renderTestConfig(t, routerConfig)
+
+	for _, directive := range []string{
+		`set_real_ip_from unix:;`,
+		`real_ip_header proxy_protocol;`,
+		`listen unix:/tmp/router-https\.sock default_server ssl http2 proxy_protocol;`,
+		`listen unix:/tmp/router-https\.sock ssl http2 proxy_protocol;`,
+		`return 301 https://\$host\$request_uri;`,
+		`default unix:/tmp/router-https\.sock;`,
+		`bar\.example\.com unix:/tmp/router-passthrough-deis-bar\.sock;`,
+		`listen 6443;`,
+		`ssl_preread on;`,
+		`proxy_pass \$tls_upstream;`,
+		`listen unix:/tmp/router-passthrough-deis-bar\.sock proxy_protocol;`,
+		`allow 1\.2\.3\.4;`,
+		`proxy_pass 10\.0\.0\.1:443;`,
+	} {
+		validDirective := regexp.MustCompile(`(?m)^\s*` + directive + `$`)
+		if !validDirective.MatchString(b) {
+			t.Errorf("Expected: '%s' in the configuration. Actual: no match", directive)
+		}
+	}
+	if regexp.MustCompile(`(?m)^\s*listen 6443 .*ssl`).MatchString(b) {
+		t.Errorf("Expected: no TLS listener on port 6443 in the http block. Actual: match")
+	}
+	// Only connections handed over from the stream block honor the PROXY protocol; plain HTTP
+	// connections still honor X-Forwarded-For.
+	if !regexp.MustCompile(`(?m)^\treal_ip_header X-Forwarded-For;$`).MatchString(b) {
+		t.Errorf("Expected: 'real_ip_header X-Forwarded-For;' in the http block. Actual: no match")
+	}
+	if n := len(regexp.MustCompile(`(?m)^\s*real_ip_header proxy_protocol;$`).FindAllString(b, -1)); n != 2 {
+		t.Errorf("Expected: 2 'real_ip_header proxy_protocol;' in the configuration. Actual: %d", n)
+	}
+	if regexp.MustCompile(`(?s)listen 8080;[^}]*real_ip_header`).MatchString(b) {
+		t.Errorf("Expected: no real_ip_header in the servers listening on port 8080. Actual: match")
+	}
+
+	// Without any passthrough apps, TLS is terminated on port 6443 directly.
+	passthrough.TLSPassthrough = false
+	b = renderTestConfig(t, routerConfig)
+	if !regexp.MustCompile(`(?m)^\s*listen 6443 ssl http2 ;$`).MatchString(b) {
+		t.Errorf("Expected: 'listen 6443 ssl http2 ;' in the configuration. Actual: no match")
+	}
+	if strings.Contains(b, "ssl_preread") {
+		t.Errorf("Expected: no 'ssl_preread' in the configuration. Actual: match")
+	}
+}
+
+// newTestPassthroughConfig returns a router configuration with a TLS passthrough app and a stream,
+// using only files that exist in the router's image.
+func newTestPassthroughConfig() *model.RouterConfig {
+	routerConfig := newTestRouterConfig()
+	routerConfig.PlatformDomain = "example.com"
+	passthrough := newTestAppConfig("deis/bar", "bar")
+	passthrough.TLSPassthrough = true
+	passthrough.BackendConfig.Name = "deis-bar"
+	passthrough.BackendConfig.Port = "443"
+	routerConfig.AppConfigs = []*model.AppConfig{passthrough}
+	routerConfig.StreamConfigs = []*model.StreamConfig{
+		{Name: "deis-baz", Ports: map[string]string{"5432": "5432"}, Protocol: "tcp", ConnectTimeout: "10s", Timeout: "10m", ServiceIP: "10.0.0.2"},
+	}
+	return routerConfig
+}
+
+func TestConfigModulesBuilt(t *testing.T) {
+	// Ensure nginx is built with the modules providing the directives the configuration uses.
+	dockerfile, err := ioutil.ReadFile("../rootfs/Dockerfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := renderTestConfig(t, newTestPassthroughConfig())
+	for directive, flag := range map[string]string{
+		"ssl_preread on;":        "--with-stream_ssl_preread_module",
+		"set_real_ip_from unix:": "--with-http_realip_module",
+		" http2 ":                "--with-http_v2_module",
+		"stream {":               "--with-stream ",
+	} {
+		if !strings.Contains(b, directive) {
+			t.Errorf("Expected: '%s' in the configuration. Actual: no match", directive)
+		}
+		if !strings.Contains(string(dockerfile), flag) {
+			t.Errorf("Expected: nginx configured with '%s' for '%s'. Actual: no match", flag, directive)
+		}
+	}
+}
+
+func TestNginxAcceptsConfig(t *testing.T) {
+	// This only runs where nginx is installed as in the router's image; see make test-nginx-config.
+	if _, err := os.Stat(nginxBinary); err != nil {
+		t.Skipf("%s is not available", nginxBinary)
+	}
+	dir, err := ioutil.TempDir("", "nginx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	confPath := filepath.Join(dir, "nginx.conf")
+	if err := WriteConfig(newTestPassthroughConfig(), confPath); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := exec.Command(nginxBinary, "-t", "-c", confPath).CombinedOutput(); err != nil {
+		t.Errorf("Expected nginx to accept the configuration, got %v: %s", err, out)
+	}
+}
+
+func TestACMEChallenges(t *testing.T) {
+	routerConfig := newTestRouterConfig()
+	appConfig := newTestAppConfig("deis/foo", "foo.example.com")
+	appConfig.Whitelist = []string{"1.2.3.4"}
+	routerConfig.AppConfigs = []*model.AppConfig{appConfig}
+	challengeLocation := regexp.MustCompile(`(?m)^\s*location \^~ /\.well-known/acme-challenge/ \{$`)
+
+	b := renderTestConfig(t, routerConfig)
+	if challengeLocation.MatchString(b) {
+		t.Errorf("Expected: no ACME challenge location in the configuration. Actual: match")
+	}
+
+	appConfig.ACMEConfig.Enabled = true
+	b = renderTestConfig(t, routerConfig)
+	if !challengeLocation.MatchString(b) {
+		t.Errorf("Expected: an ACME challenge location in the configuration. Actual: no match")
+	}
+	// The certificate authority must be able to reach the challenge location regardless of whitelists.
+	for _, directive := range []string{
+		`allow all;`,
+		`root /opt/router/acme;`,
+	} {
+		validDirective := regexp.MustCompile(`(?m)^\s*` + directive + `$`)
+		if !validDirective.MatchString(b) {
+			t.Errorf("Expected: '%s' in the configuration. Actual: no match", directive)
+		}
+	}
+}
+
+func TestCertificateAlternates(t *testing.T) {
+	routerConfig := newTestRouterConfig()
+	routerConfig.PlatformCertificate = &model.Certificate{
+		Cert:       "foo",
+		Key:        "bar",
+		KeyType:    "ecdsa",
+		Alternates: []*model.Certificate{{Cert: "biz", Key: "baz", KeyType: "rsa"}},
+	}
+	appConfig := newTestAppConfig("deis/foo", "foo.example.com")
+	appConfig.Certificates["foo.example.com"] = &model.Certificate{
+		Cert:       "foo",
+		Key:        "bar",
+		KeyType:    "rsa",
+		Alternates: []*model.Certificate{{Cert: "biz", Key: "baz", KeyType: "ecdsa"}},
+	}
+	routerConfig.AppConfigs = []*model.AppConfig{appConfig}
+
+	b := renderTestConfig(t, routerConfig)
+
+	for _, directive := range []string{
+		`ssl_certificate /opt/router/ssl/platform\.crt;`,
+		`ssl_certificate /opt/router/ssl/platform\.rsa\.crt;`,
+		`ssl_certificate_key /opt/router/ssl/platform\.rsa\.key;`,
+		`ssl_certificate /opt/router/ssl/foo\.example\.com\.crt;`,
+		`ssl_certificate /opt/router/ssl/foo\.example\.com\.ecdsa\.crt;`,
+		`ssl_certificate_key /opt/router/ssl/foo\.example\.com\.ecdsa\.key;`,
+	} {
+		validDirective := regexp.MustCompile(`(?m)^\s*` + directive + `$`)
+		if !validDirective.MatchString(b) {
+			t.Errorf("Expected: '%s' in the configuration. Actual: no match", directive)
+		}
+	}
+}
+
+func TestOCSPStapling(t *testing.T) {
+	routerConfig := newTestRouterConfig()
+	appConfig := newTestAppConfig("deis/foo", "foo.example.com")
+	appConfig.Certificates["foo.example.com"] = &model.Certificate{Cert: "foo", Key: "bar", TrustedChain: "biz", OCSPResponse: "baz"}
+	routerConfig.AppConfigs = []*model.AppConfig{appConfig}
+	stapling := regexp.MustCompile(`(?m)^\s*ssl_stapling on;$`)
+
+	b := renderTestConfig(t, routerConfig)
+	if stapling.MatchString(b) {
+		t.Errorf("Expected: no OCSP stapling in the configuration. Actual: match")
+	}
+
+	// Stapling can be enabled for a single app.
+	appConfig.SSLConfig.OCSPConfig.Stapling = true
+	appConfig.SSLConfig.OCSPConfig.Verify = true
+	appConfig.SSLConfig.OCSPConfig.Resolver = "10.0.0.10"
+	b = renderTestConfig(t, routerConfig)
+	for _, directive := range []string{
+		`ssl_stapling on;`,
+		`ssl_stapling_verify on;`,
+		`ssl_trusted_certificate /opt/router/ssl/foo\.example\.com\.chain\.crt;`,
+		`ssl_stapling_file /opt/router/ssl/foo\.example\.com\.ocsp;`,
+		`resolver 10\.0\.0\.10;`,
+	} {
+		validDirective := regexp.MustCompile(`(?m)^\s*` + directive + `$`)
+		if !validDirective.MatchString(b) {
+			t.Errorf("Expected: '%s' in the configuration. Actual: no match", directive)
+		}
+	}
+	if n := len(stapling.FindAllString(b, -1)); n != 1 {
+		t.Errorf("Expected: OCSP stapling for the app only. Actual: %d matches", n)
+	}
+}
+
+func TestAppSSLConfig(t *testing.T) {
+	routerConfig := newTestRouterConfig()
+	legacyConfig := newTestAppConfig("deis/legacy", "legacy.example.com")
+	legacyConfig.Certificates["legacy.example.com"] = &model.Certificate{Cert: "foo", Key: "bar"}
+	legacyConfig.SSLConfig.Protocols = "TLSv1 TLSv1.1 TLSv1.2"
+	legacyConfig.SSLConfig.EarlyDataMethods = ""
+	modernConfig := newTestAppConfig("deis/modern", "modern.example.com")
+	modernConfig.Certificates["modern.example.com"] = &model.Certificate{Cert: "foo", Key: "bar"}
+	modernConfig.SSLConfig.Protocols = "TLSv1.2 TLSv1.3"
+	modernConfig.SSLConfig.EarlyDataMethods = "GET|HEAD"
+	modernConfig.SSLConfig.HSTSConfig = &model.HSTSConfig{Enabled: true, MaxAge: 1234, Preload: true}
+	routerConfig.AppConfigs = []*model.AppConfig{legacyConfig, modernConfig}
+
+	b := renderTestConfig(t, routerConfig)
+
+	legacyServer := b[strings.Index(b, "legacy.example.com;"):strings.Index(b, "modern.example.com;")]
+	modernServer := b[strings.Index(b, "modern.example.com;"):]
+	for _, directive := range []string{
+		`ssl_protocols TLSv1 TLSv1\.1 TLSv1\.2;`,
+		`ssl_early_data off;`,
+	} {
+		if !regexp.MustCompile(`(?m)^\s*` + directive + `$`).MatchString(legacyServer) {
+			t.Errorf("Expected: '%s' in the legacy app's server. Actual: no match", directive)
+		}
+	}
+	for _, directive := range []string{
+		`ssl_protocols TLSv1\.2 TLSv1\.3;`,
+		`ssl_early_data on;`,
+		`if \(\$early_data_request ~ "\^1:\(\?!\(GET\|HEAD\)\$\)"\) \{`,
+		`set \$hsts_policy 'max-age=1234; preload';`,
+		`add_header Strict-Transport-Security \$sts always;`,
+		`if \(\$access_scheme !~\* "\^https\|wss\$"\) \{`,
+	} {
+		if !regexp.MustCompile(`(?m)^\s*` + directive + `$`).MatchString(modernServer) {
+			t.Errorf("Expected: '%s' in the modern app's server. Actual: no match", directive)
+		}
+	}
+	for _, directive := range []string{`hsts_policy`, `Strict-Transport-Security`, `early_data_request`} {
+		if strings.Contains(legacyServer, directive) {
+			t.Errorf("Expected: no '%s' in the legacy app's server. Actual: match", directive)
+		}
+	}
+	if !regexp.MustCompile(`(?m)^\s*map "\$access_scheme:\$hsts_policy" \$sts \{$`).MatchString(b) {
+		t.Errorf("Expected: the HSTS map in the configuration. Actual: no match")
+	}
+}
+
+func TestSessionTicketKeys(t *testing.T) {
+	routerConfig := newTestRouterConfig()
+	routerConfig.SSLConfig.UseSessionTickets = true
+	routerConfig.SSLConfig.SessionTicketKeys = []string{"foo", "bar"}
+	appConfig := newTestAppConfig("deis/foo", "foo.example.com")
+	appConfig.Certificates["foo.example.com"] = &model.Certificate{Cert: "foo", Key: "bar"}
+	appConfig.SSLConfig.UseSessionTickets = false
+	routerConfig.AppConfigs = []*model.AppConfig{appConfig}
+	keys := regexp.MustCompile(`(?m)^\s*ssl_session_ticket_key /opt/router/ssl/tickets/(\d+)\.key;$`)
+
+	// The keys are used by the default server, but not by the app, which disables tickets.
+	b := renderTestConfig(t, routerConfig)
+	matches := keys.FindAllStringSubmatch(b, -1)
+	if len(matches) != 2 || matches[0][1] != "0" || matches[1][1] != "1" {
+		t.Errorf("Expected: keys 0 and 1 once each in the configuration. Actual: %v", matches)
+	}
+
+	appConfig.SSLConfig.UseSessionTickets = true
+	b = renderTestConfig(t, routerConfig)
+	if n := len(keys.FindAllString(b, -1)); n != 4 {
+		t.Errorf("Expected: both keys in both servers. Actual: %d matches", n)
+	}
+}
+
+func newTestRouterConfig() *model.RouterConfig {
+	return &model.RouterConfig{
+		WorkerProcesses:          "auto",
+		MaxWorkerConnections:     "768",
+		TrafficStatusZoneSize:    "1m",
+		DefaultTimeout:           "1300s",
+		ServerNameHashMaxSize:    "512",
+		ServerNameHashBucketSize: "64",
+		GzipConfig: &model.GzipConfig{
+			Enabled: false,
+		},
+		BodySize:                "1m",
+		LargeHeaderBuffersCount: "4",
+		LargeHeaderBuffersSize:  "32k",
+		ProxyRealIPCIDRs:        []string{"10.0.0.0/8"},
+		ErrorLogLevel:           "error",
+		WhitelistMode:           "extend",
+		SSLConfig: &model.SSLConfig{
+			Protocols:         "TLSv1.2 TLSv1.3",
+			SessionTimeout:    "10m",
+			UseSessionTickets: true,
+			BufferSize:        "4k",
+			HSTSConfig:        &model.HSTSConfig{},
+			OCSPConfig:        &model.OCSPConfig{},
+		},
+		HTTP2Enabled: true,
+		ProxyBuffersConfig: &model.ProxyBuffersConfig{
+			Number:   8,
+			Size:     "4k",
+			BusySize: "8k",
+		},
+	}
+}
+
+func newTestAppConfig(name string, domain string) *model.AppConfig {
+	appConfig := &model.AppConfig{
+		Name:           name,
+		Domains:        []string{domain},
+		ConnectTimeout: "30s",
+		TCPTimeout:     "1300s",
+		ServiceIP:      "10.0.0.1",
+		Certificates:   make(map[string]*model.Certificate),
+		Available:      true,
+		SSLConfig: &model.SSLConfig{
+			HSTSConfig: &model.HSTSConfig{},
+			OCSPConfig: &model.OCSPConfig{},
+		},
+		ClientCertConfig: &model.ClientCertConfig{
+			Verify:      "off",
+			VerifyDepth: 1,
+		},
+		BackendConfig: &model.BackendConfig{
+			Protocol:    "http",
+			Port:        "80",
+			VerifyDepth: 1,
+			Name:        "deis-foo",
+		},
+		ACMEConfig: &model.AppACMEConfig{},
+		Nginx: &model.NginxAppConfig{
+			ProxyBuffersConfig: &model.ProxyBuffersConfig{
+				Number:   8,
+				Size:     "4k",
+				BusySize: "8k",
+			},
+		},
+	}
+	appConfig.Locations = []*model.Location{{App: appConfig, Path: "/"}}
+	return appConfig
+}
+
+func renderTestConfig(t *testing.T, routerConfig *model.RouterConfig) string {
+	var b bytes.Buffer
+	tmpl, err := template.New("nginx").Funcs(templateFuncs()).Parse(confTemplate)
+	if err != nil {
+		t.Fatalf("Encountered an error: %v", err)
+	}
+	err = tmpl.Execute(&b, routerConfig)
+	if err != nil {
+		t.Fatalf("Encountered an error: %v", err)
+	}
+	return b.String()
+}
@@ -0,0 +1,100 @@
+// Package reference renders the annotations the router understands, as described by the model's
+// struct tags, as Markdown documentation and as a JSON Schema, so that neither is maintained by
+// hand.
+package reference
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/teamhephy/router/model"
+)
+
+const (
+	schemaURI = "http://json-schema.org/draft-07/schema#"
+	// Notice heading generated Markdown, telling readers not to edit it.
+	generatedNotice = "<!-- Generated by `make annotation-reference` from the model's struct tags. DO NOT EDIT. -->"
+)
+
+// WriteMarkdown writes a Markdown table of the annotations of each group, with their types,
+// defaults, and constraints.
+func WriteMarkdown(w io.Writer, groups []*model.AnnotationGroup) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n\n# Annotation Reference\n\n", generatedNotice)
+	fmt.Fprintln(&b, "| Component | Resource Type | Annotation | Type | Default Value | Constraint |")
+	fmt.Fprintln(&b, "|-----------|---------------|------------|------|---------------|------------|")
+	for _, group := range groups {
+		for _, field := range group.Fields {
+			defaultValue := ""
+			if field.HasDefault {
+				defaultValue = codeSpan(fmt.Sprintf("\"%s\"", field.Default))
+			}
+			constraint := ""
+			if field.Constraint != "" {
+				constraint = codeSpan(field.Constraint)
+			}
+			fmt.Fprintf(&b, "| %s | %s | %s | %s | %s | %s |\n", group.Component, group.ResourceType, field.Key, field.Type, defaultValue, constraint)
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// codeSpan returns s as a Markdown code span that can be used in a table cell.
+func codeSpan(s string) string {
+	s = strings.Replace(s, "|", "\\|", -1)
+	if strings.Contains(s, "`") {
+		return fmt.Sprintf("`` %s ``", s)
+	}
+	return fmt.Sprintf("`%s`", s)
+}
+
+// JSONSchema returns a JSON Schema with a definition for the annotations of each group. Since
+// annotations are all strings, each property is a string, with the type of value it takes given
+// as x-value-type.
+func JSONSchema(groups []*model.AnnotationGroup) map[string]interface{} {
+	definitions := make(map[string]interface{}, len(groups))
+	for _, group := range groups {
+		properties := make(map[string]interface{}, len(group.Fields))
+		for _, field := range group.Fields {
+			property := map[string]interface{}{
+				"type":         "string",
+				"x-value-type": field.Type,
+			}
+			if field.Constraint != "" {
+				property["pattern"] = field.Constraint
+			}
+			if field.HasDefault {
+				property["default"] = field.Default
+			}
+			properties[field.Key] = property
+		}
+		definitions[definitionName(group)] = map[string]interface{}{
+			"description":          fmt.Sprintf("Annotations on the %s %s.", group.Component, group.ResourceType),
+			"type":                 "object",
+			"properties":           properties,
+			"additionalProperties": map[string]interface{}{"type": "string"},
+		}
+	}
+	return map[string]interface{}{
+		"$schema":     schemaURI,
+		"title":       "Router annotations",
+		"definitions": definitions,
+	}
+}
+
+// WriteJSONSchema writes the JSON Schema for the annotations of the groups as indented JSON.
+func WriteJSONSchema(w io.Writer, groups []*model.AnnotationGroup) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(JSONSchema(groups))
+}
+
+// definitionName returns the name of the definition for the group's annotations, such as
+// "deis-router-deployment".
+func definitionName(group *model.AnnotationGroup) string {
+	return strings.Replace(fmt.Sprintf("%s %s", group.Component, group.ResourceType), " ", "-", -1)
+}
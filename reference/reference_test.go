@@ -0,0 +1,124 @@
+package reference
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/teamhephy/router/model"
+	modelerUtility "github.com/teamhephy/router/utils/modeler"
+)
+
+func TestGeneratedFilesUpToDate(t *testing.T) {
+	// Ensure the committed reference reflects the model's current struct tags.
+	groups, err := model.AnnotationReference()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var markdown, schema bytes.Buffer
+	if err := WriteMarkdown(&markdown, groups); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteJSONSchema(&schema, groups); err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string][]byte{
+		"../docs/annotations.md":          markdown.Bytes(),
+		"../docs/annotations.schema.json": schema.Bytes(),
+	} {
+		got, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(want, got) {
+			t.Errorf("%s is out of date; run make annotation-reference to regenerate it.", path)
+		}
+	}
+}
+
+func TestAnnotationReference(t *testing.T) {
+	groups, err := model.AnnotationReference()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := make(map[string]map[string]bool, len(groups))
+	for _, group := range groups {
+		name := definitionName(group)
+		keys[name] = make(map[string]bool, len(group.Fields))
+		for _, field := range group.Fields {
+			keys[name][field.Key] = true
+		}
+	}
+	app := keys["routable-application-service"]
+	// Streams are configured on application services too.
+	if !app["router.deis.io/stream.ports"] {
+		t.Errorf("Expected the stream annotations among the application service's")
+	}
+	// Fields only the router's deployment sets must not be offered to applications.
+	for _, key := range []string{"ssl.dhParamSize", "ssl.expiryWarningDays", "ssl.sessionTicketKeyRotation"} {
+		if app["router.deis.io/"+key] {
+			t.Errorf("Expected router.deis.io/%s not to be among the application service's annotations", key)
+		}
+		if !keys["deis-router-deployment"]["router.deis.io/nginx."+key] {
+			t.Errorf("Expected router.deis.io/nginx.%s among the router deployment's annotations", key)
+		}
+	}
+}
+
+func TestJSONSchema(t *testing.T) {
+	groups := []*model.AnnotationGroup{
+		{
+			Component:    "deis-builder",
+			ResourceType: "service",
+			Fields: []modelerUtility.FieldDescription{
+				{Key: "router.deis.io/nginx.a", Type: "integer", Constraint: "^\\d+$", Default: "1", HasDefault: true},
+				{Key: "router.deis.io/nginx.b", Type: "list of strings"},
+			},
+		},
+	}
+	var b bytes.Buffer
+	if err := WriteJSONSchema(&b, groups); err != nil {
+		t.Fatal(err)
+	}
+	var schema struct {
+		Definitions map[string]struct {
+			Properties map[string]map[string]string `json:"properties"`
+		} `json:"definitions"`
+	}
+	if err := json.Unmarshal(b.Bytes(), &schema); err != nil {
+		t.Fatal(err)
+	}
+	properties := schema.Definitions["deis-builder-service"].Properties
+	a := properties["router.deis.io/nginx.a"]
+	if a["type"] != "string" || a["x-value-type"] != "integer" || a["pattern"] != "^\\d+$" || a["default"] != "1" {
+		t.Errorf("Unexpected property %v", a)
+	}
+	if _, ok := properties["router.deis.io/nginx.b"]["default"]; ok {
+		t.Errorf("Expected no default for a field without one, got %v", properties["router.deis.io/nginx.b"])
+	}
+}
+
+func TestMarkdownPatterns(t *testing.T) {
+	// Ensure every pattern in the schema compiles, and survives being put in a table cell.
+	groups, err := model.AnnotationReference()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, group := range groups {
+		for _, field := range group.Fields {
+			if _, err := regexp.Compile(field.Constraint); err != nil {
+				t.Errorf("Constraint of %s doesn't compile: %v", field.Key, err)
+			}
+		}
+	}
+	cell := codeSpan("^(a|b)$")
+	if cell != "`^(a\\|b)$`" {
+		t.Errorf("Expected the pipe to be escaped, got %s", cell)
+	}
+	if cell := codeSpan("a`b"); !strings.HasPrefix(cell, "`` ") {
+		t.Errorf("Expected a double-backtick code span, got %s", cell)
+	}
+}
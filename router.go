@@ -6,8 +6,13 @@ import (
 	"reflect"
 	"strconv"
 
+	"github.com/teamhephy/router/acme"
+	"github.com/teamhephy/router/dhparam"
 	"github.com/teamhephy/router/model"
+	"github.com/teamhephy/router/monitor"
 	"github.com/teamhephy/router/nginx"
+	"github.com/teamhephy/router/selfsigned"
+	"github.com/teamhephy/router/tickets"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/util/flowcontrol"
@@ -31,6 +36,19 @@ func main() {
 	if err != nil {
 		log.Fatalf("Failed to create client: %v.", err)
 	}
+	acmeManager := acme.NewManager(kubeClient)
+	go acmeManager.Run()
+	certIssuer := selfsigned.NewIssuer(kubeClient)
+	go certIssuer.Run()
+	dhParamGenerator := dhparam.NewGenerator(kubeClient)
+	go dhParamGenerator.Run()
+	ticketKeyRotator := tickets.NewRotator(kubeClient)
+	go ticketKeyRotator.Run()
+	certMonitor := monitor.NewMonitor(kubeClient)
+	go certMonitor.Run()
+	go func() {
+		log.Fatalf("Failed to serve certificate metrics: %v", certMonitor.Serve(":9091"))
+	}()
 	rateLimiter := flowcontrol.NewTokenBucketRateLimiter(0.1, 1)
 	known := &model.RouterConfig{}
 	// Main loop
@@ -55,6 +73,21 @@ func main() {
 			log.Printf("Failed to write dhparam; continuing with existing dhparam and configuration: %v", err)
 			continue
 		}
+		err = nginx.WriteSessionTicketKeys(routerConfig, "/opt/router/ssl/tickets")
+		if err != nil {
+			log.Printf("Failed to write session ticket keys; continuing with existing session ticket keys and configuration: %v", err)
+			continue
+		}
+		err = nginx.WriteHtpasswds(routerConfig, "/opt/router/auth")
+		if err != nil {
+			log.Printf("Failed to write htpasswd files; continuing with existing htpasswd files and configuration: %v", err)
+			continue
+		}
+		err = nginx.WriteACMEChallenges(routerConfig, "/opt/router/acme/.well-known/acme-challenge")
+		if err != nil {
+			log.Printf("Failed to write ACME challenge responses; continuing with existing challenge responses and configuration: %v", err)
+			continue
+		}
 		err = nginx.WriteConfig(routerConfig, "/opt/router/conf/nginx.conf")
 		if err != nil {
 			log.Printf("Failed to write new nginx configuration; continuing with existing configuration: %v", err)
@@ -66,5 +99,10 @@ func main() {
 			continue
 		}
 		known = routerConfig
+		acmeManager.Update(routerConfig)
+		certIssuer.Update(routerConfig)
+		certMonitor.Update(routerConfig)
+		dhParamGenerator.Update(routerConfig)
+		ticketKeyRotator.Update(routerConfig)
 	}
 }
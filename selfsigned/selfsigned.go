@@ -0,0 +1,329 @@
+package selfsigned
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"os"
+	"time"
+
+	"github.com/teamhephy/router/model"
+	"github.com/teamhephy/router/utils"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	leaseConfigMapName = "deis-router-selfsigned"
+	// Annotations on the lease config map recording which replica generates certificates, and when
+	// it last renewed its lease on doing so.
+	holderAnnotation  = "router.deis.io/selfSignedHolder"
+	renewedAnnotation = "router.deis.io/selfSignedRenewedAt"
+	// How long a replica's lease on generating certificates lasts unless renewed, which it is before
+	// generating each certificate.
+	leaseDuration = 5 * time.Minute
+	// How often to check for certificates in need of renewal when the configuration doesn't change.
+	checkInterval = time.Hour
+	// How far back to date certificates, so that clients with clocks running behind accept them.
+	backdate = time.Hour
+)
+
+var namespace = utils.GetOpt("POD_NAMESPACE", "default")
+
+// Issuer generates and renews certificates for the domains of apps that have none, signing them
+// with an internal CA or, failing that, with their own keys. Certificates are stored as
+// cert-bearing secrets in the apps' namespaces, from which the model picks them up and marks them
+// as generated. Only the replica holding the lease recorded on the lease config map generates
+// certificates, so that replicas don't overwrite each other's.
+type Issuer struct {
+	kubeClient *kubernetes.Clientset
+	identity   string
+	configs    chan *model.RouterConfig
+}
+
+// NewIssuer returns an Issuer that stores certificates using the given client and identifies its
+// replica by its hostname, which is the name of its pod.
+func NewIssuer(kubeClient *kubernetes.Clientset) *Issuer {
+	identity, err := os.Hostname()
+	if err != nil {
+		log.Printf("WARN: Failed to determine the hostname identifying this replica: %v\n", err)
+	}
+	return &Issuer{
+		kubeClient: kubeClient,
+		identity:   identity,
+		configs:    make(chan *model.RouterConfig, 1),
+	}
+}
+
+// Update hands the Issuer the latest router configuration. It never blocks; if the Issuer is busy,
+// any configuration it has not picked up yet is replaced.
+func (i *Issuer) Update(routerConfig *model.RouterConfig) {
+	for {
+		select {
+		case i.configs <- routerConfig:
+			return
+		default:
+		}
+		select {
+		case <-i.configs:
+		default:
+		}
+	}
+}
+
+// Run generates and renews certificates for the latest router configuration whenever it changes,
+// and periodically otherwise. It never returns.
+func (i *Issuer) Run() {
+	var routerConfig *model.RouterConfig
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case routerConfig = <-i.configs:
+		case <-ticker.C:
+		}
+		if routerConfig != nil && routerConfig.SelfSignedConfig.Enabled {
+			i.sync(routerConfig)
+		}
+	}
+}
+
+func (i *Issuer) sync(routerConfig *model.RouterConfig) {
+	selfSignedConfig := routerConfig.SelfSignedConfig
+	var ca *tls.Certificate
+	if selfSignedConfig.CAMapping != "" {
+		var err error
+		ca, err = i.getCA(selfSignedConfig.CAMapping)
+		if err != nil {
+			log.Printf("WARN: Failed to load the internal CA for generating certificates: %v\n", err)
+			return
+		}
+	}
+	for _, appConfig := range routerConfig.AppConfigs {
+		for _, domain := range appConfig.SelfSignedDomains {
+			if !needsRenewal(appConfig.Certificates[domain], ca, time.Now()) {
+				continue
+			}
+			// Another replica may be generating certificates; it stores them for this one to use.
+			if !i.lead(time.Now()) {
+				return
+			}
+			err := i.issue(ca, selfSignedConfig.ValidDays, appConfig.Namespace, domain)
+			if err != nil {
+				log.Printf("WARN: Failed to generate a certificate for %s: %v\n", domain, err)
+				continue
+			}
+			if ca == nil {
+				log.Printf("INFO: Generated a self-signed certificate for %s.\n", domain)
+			} else {
+				log.Printf("INFO: Generated a certificate for %s signed by the internal CA %s.\n", domain, selfSignedConfig.CAMapping)
+			}
+		}
+	}
+}
+
+// lead takes or renews this replica's lease on generating certificates, returning whether it holds
+// the lease. Replicas coordinate through the lease config map's resource version, so only one of
+// them takes an expired lease.
+func (i *Issuer) lead(now time.Time) bool {
+	configMapClient := i.kubeClient.CoreV1().ConfigMaps(namespace)
+	configMap, err := configMapClient.Get(leaseConfigMapName, metav1.GetOptions{})
+	if err != nil {
+		statusErr, ok := err.(*errors.StatusError)
+		if !ok || statusErr.Status().Code != 404 {
+			log.Printf("WARN: Failed to look up the k8s config map %s: %v\n", leaseConfigMapName, err)
+			return false
+		}
+		configMap = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      leaseConfigMapName,
+				Namespace: namespace,
+				Labels: map[string]string{
+					"heritage": "deis",
+				},
+			},
+		}
+	}
+	if !acquire(configMap, i.identity, now) {
+		return false
+	}
+	if configMap.ResourceVersion == "" {
+		_, err = configMapClient.Create(configMap)
+	} else {
+		_, err = configMapClient.Update(configMap)
+	}
+	if err != nil {
+		// Another replica may have been quicker; it generates certificates instead.
+		if statusErr, ok := err.(*errors.StatusError); !ok || statusErr.Status().Code != 409 {
+			log.Printf("WARN: Failed to take the lease on generating certificates: %v\n", err)
+		}
+		return false
+	}
+	return true
+}
+
+// acquire records the replica as the holder of the lease in the config map's annotations, unless
+// another replica holds a lease that hasn't expired yet. It returns whether it did so.
+func acquire(configMap *corev1.ConfigMap, identity string, now time.Time) bool {
+	holder := configMap.Annotations[holderAnnotation]
+	if holder != "" && holder != identity {
+		renewedAt, err := time.Parse(time.RFC3339, configMap.Annotations[renewedAnnotation])
+		if err == nil && now.Sub(renewedAt) < leaseDuration {
+			return false
+		}
+	}
+	if configMap.Annotations == nil {
+		configMap.Annotations = make(map[string]string)
+	}
+	configMap.Annotations[holderAnnotation] = identity
+	configMap.Annotations[renewedAnnotation] = now.UTC().Format(time.RFC3339)
+	return true
+}
+
+func (i *Issuer) getCA(caMapping string) (*tls.Certificate, error) {
+	secretName := fmt.Sprintf("%s-cert", caMapping)
+	secret, err := i.kubeClient.CoreV1().Secrets(namespace).Get(secretName, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return parseCA(secret.Data["tls.crt"], secret.Data["tls.key"])
+}
+
+func (i *Issuer) issue(ca *tls.Certificate, validDays int, ns string, domain string) error {
+	certPEM, keyPEM, err := generate(rand.Reader, ca, validDays, domain, time.Now())
+	if err != nil {
+		return err
+	}
+	data := map[string][]byte{
+		"tls.crt": certPEM,
+		"tls.key": keyPEM,
+	}
+	if ca != nil {
+		data["ca.crt"] = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Leaf.Raw})
+	}
+	return i.saveSecret(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s-cert", model.GeneratedCertName(domain)),
+			Namespace: ns,
+			Labels: map[string]string{
+				"heritage": "deis",
+			},
+		},
+		Type: corev1.SecretTypeTLS,
+		Data: data,
+	})
+}
+
+func (i *Issuer) saveSecret(secret *corev1.Secret) error {
+	secretClient := i.kubeClient.CoreV1().Secrets(secret.Namespace)
+	_, err := secretClient.Update(secret)
+	if err == nil {
+		return nil
+	}
+	statusErr, ok := err.(*errors.StatusError)
+	if !ok || statusErr.Status().Code != 404 {
+		return err
+	}
+	_, err = secretClient.Create(secret)
+	return err
+}
+
+// parseCA returns the CA conveyed by the given PEM-encoded certificate and key, with its
+// certificate parsed.
+func parseCA(certPEM []byte, keyPEM []byte) (*tls.Certificate, error) {
+	ca, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	ca.Leaf, err = x509.ParseCertificate(ca.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	if !ca.Leaf.IsCA {
+		return nil, fmt.Errorf("the certificate for %s is not a CA certificate", ca.Leaf.Subject.CommonName)
+	}
+	return &ca, nil
+}
+
+// needsRenewal returns whether the certificate is missing, unparseable, not signed by the CA (or,
+// without one, not self-signed), or has less than a third of its lifetime left.
+func needsRenewal(certificate *model.Certificate, ca *tls.Certificate, now time.Time) bool {
+	if certificate == nil {
+		return true
+	}
+	block, _ := pem.Decode([]byte(certificate.Cert))
+	if block == nil {
+		return true
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return true
+	}
+	parent := cert
+	if ca != nil {
+		parent = ca.Leaf
+	}
+	if err := parent.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature); err != nil {
+		return true
+	}
+	lifetime := cert.NotAfter.Sub(cert.NotBefore)
+	return cert.NotAfter.Sub(now) < lifetime/3
+}
+
+// generate returns a PEM-encoded certificate valid for validDays from now for the domain, along
+// with its key. The certificate is signed by the CA, or is self-signed if there is none.
+func generate(random io.Reader, ca *tls.Certificate, validDays int, domain string, now time.Time) ([]byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), random)
+	if err != nil {
+		return nil, nil, err
+	}
+	serialNumber, err := rand.Int(random, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject: pkix.Name{
+			CommonName:   domain,
+			Organization: []string{"Deis Router (generated)"},
+		},
+		DNSNames:              []string{domain},
+		NotBefore:             now.Add(-backdate),
+		NotAfter:              now.AddDate(0, 0, validDays),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	parent := template
+	var signer crypto.Signer = key
+	if ca != nil {
+		parent = ca.Leaf
+		var ok bool
+		signer, ok = ca.PrivateKey.(crypto.Signer)
+		if !ok {
+			return nil, nil, fmt.Errorf("the key of the internal CA can't be used for signing")
+		}
+	}
+	der, err := x509.CreateCertificate(random, template, parent, key.Public(), signer)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
@@ -0,0 +1,186 @@
+package selfsigned
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/teamhephy/router/model"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var now = time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+func TestGenerateSelfSigned(t *testing.T) {
+	certPEM, keyPEM, err := generate(rand.Reader, nil, 90, "www.example.com", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := parseTestCertificate(t, certPEM, keyPEM)
+	if err := cert.VerifyHostname("www.example.com"); err != nil {
+		t.Error(err)
+	}
+	if want := now.AddDate(0, 0, 90); !cert.NotAfter.Equal(want) {
+		t.Errorf("Expected the certificate to expire on %s, got %s", want, cert.NotAfter)
+	}
+	if err := cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature); err != nil {
+		t.Errorf("Expected the certificate to be self-signed: %v", err)
+	}
+}
+
+func TestGenerateSignedByCA(t *testing.T) {
+	ca := newTestCA(t)
+	certPEM, keyPEM, err := generate(rand.Reader, ca, 90, "*.example.com", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := parseTestCertificate(t, certPEM, keyPEM)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Leaf)
+	_, err = cert.Verify(x509.VerifyOptions{DNSName: "www.example.com", Roots: roots, CurrentTime: now})
+	if err != nil {
+		t.Errorf("Expected the certificate to be signed by the CA: %v", err)
+	}
+}
+
+func TestParseCA(t *testing.T) {
+	certPEM, keyPEM, err := generate(rand.Reader, nil, 90, "www.example.com", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseCA(certPEM, keyPEM); err == nil {
+		t.Errorf("Expected an error parsing a certificate that is not a CA certificate, but did not receive any error")
+	}
+	if _, err := parseCA([]byte("foo"), []byte("bar")); err == nil {
+		t.Errorf("Expected an error parsing an invalid CA, but did not receive any error")
+	}
+}
+
+func TestNeedsRenewal(t *testing.T) {
+	ca := newTestCA(t)
+	selfSigned := newTestCertificate(t, nil)
+	signed := newTestCertificate(t, ca)
+
+	tests := []struct {
+		description string
+		certificate *model.Certificate
+		ca          *tls.Certificate
+		now         time.Time
+		want        bool
+	}{
+		{"missing certificate", nil, nil, now, true},
+		{"unparseable certificate", &model.Certificate{Cert: "foo"}, nil, now, true},
+		{"fresh self-signed certificate", selfSigned, nil, now, false},
+		{"fresh certificate signed by the CA", signed, ca, now, false},
+		{"self-signed certificate once a CA is configured", selfSigned, ca, now, true},
+		{"certificate signed by a CA no longer configured", signed, nil, now, true},
+		{"certificate with more than a third of its lifetime left", selfSigned, nil, now.AddDate(0, 0, 59), false},
+		{"certificate with less than a third of its lifetime left", selfSigned, nil, now.AddDate(0, 0, 61), true},
+	}
+	for _, test := range tests {
+		if got := needsRenewal(test.certificate, test.ca, test.now); got != test.want {
+			t.Errorf("Expected needsRenewal to be %t for a %s, got %t", test.want, test.description, got)
+		}
+	}
+}
+
+func TestAcquire(t *testing.T) {
+	lease := func(holder string, renewedAt time.Time) *corev1.ConfigMap {
+		return &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Annotations: map[string]string{
+					holderAnnotation:  holder,
+					renewedAnnotation: renewedAt.Format(time.RFC3339),
+				},
+			},
+		}
+	}
+
+	tests := []struct {
+		description string
+		configMap   *corev1.ConfigMap
+		want        bool
+	}{
+		{"missing lease", &corev1.ConfigMap{}, true},
+		{"own lease", lease("router-a", now.Add(-time.Minute)), true},
+		{"other replica's lease", lease("router-b", now.Add(-time.Minute)), false},
+		{"other replica's expired lease", lease("router-b", now.Add(-leaseDuration)), true},
+	}
+	for _, test := range tests {
+		got := acquire(test.configMap, "router-a", now)
+		if got != test.want {
+			t.Errorf("Expected acquire to be %t for a %s, got %t", test.want, test.description, got)
+		}
+		if holder := test.configMap.Annotations[holderAnnotation]; got && holder != "router-a" {
+			t.Errorf("Expected the lease to be held by router-a for a %s, got %s", test.description, holder)
+		}
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	i := NewIssuer(nil)
+	first := &model.RouterConfig{}
+	second := &model.RouterConfig{}
+	// Neither update may block, and only the latest configuration should be picked up.
+	i.Update(first)
+	i.Update(second)
+	if got := <-i.configs; got != second {
+		t.Errorf("Expected the latest configuration to be picked up.")
+	}
+	select {
+	case <-i.configs:
+		t.Errorf("Expected no other configuration to be picked up.")
+	default:
+	}
+}
+
+// newTestCertificate returns a certificate for www.example.com valid for 90 days from now, signed
+// by the CA or self-signed.
+func newTestCertificate(t *testing.T, ca *tls.Certificate) *model.Certificate {
+	certPEM, keyPEM, err := generate(rand.Reader, ca, 90, "www.example.com", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &model.Certificate{Cert: string(certPEM), Key: string(keyPEM)}
+}
+
+// newTestCA returns a CA able to sign certificates.
+func newTestCA(t *testing.T) *tls.Certificate {
+	certPEM, keyPEM, err := generate(rand.Reader, nil, 365, "Test CA", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Turn the generated certificate into a CA certificate by re-signing it as one.
+	cert := parseTestCertificate(t, certPEM, keyPEM)
+	cert.IsCA = true
+	cert.KeyUsage = x509.KeyUsageCertSign
+	keyPair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, cert, cert, cert.PublicKey, keyPair.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := parseCA(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ca
+}
+
+func parseTestCertificate(t *testing.T, certPEM []byte, keyPEM []byte) *x509.Certificate {
+	keyPair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(keyPair.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
@@ -0,0 +1,191 @@
+package tickets
+
+import (
+	"crypto/rand"
+	"io"
+	"log"
+	"time"
+
+	"github.com/teamhephy/router/model"
+	"github.com/teamhephy/router/utils"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	secretName = "deis-router-session-ticket-keys"
+	// Annotation recording when the keys were last rotated.
+	rotatedAnnotation = "router.deis.io/rotatedAt"
+	// How many keys to keep, besides the staged one: the current one plus those it replaced, which
+	// are still used for decrypting tickets issued before the last rotations.
+	keyCount = 3
+	// How often to check whether the keys are due for rotation.
+	checkInterval = time.Minute
+)
+
+var namespace = utils.GetOpt("POD_NAMESPACE", "default")
+
+// Rotator creates the session ticket keys shared by all router replicas and rotates them on a
+// schedule. Keys are stored in a secret in the router's namespace, from which the model picks
+// them up. Replicas coordinate through the secret's resource version, so each rotation happens
+// only once.
+type Rotator struct {
+	kubeClient *kubernetes.Clientset
+	configs    chan *model.RouterConfig
+}
+
+// NewRotator returns a Rotator that stores session ticket keys using the given client.
+func NewRotator(kubeClient *kubernetes.Clientset) *Rotator {
+	return &Rotator{
+		kubeClient: kubeClient,
+		configs:    make(chan *model.RouterConfig, 1),
+	}
+}
+
+// Update hands the Rotator the latest router configuration. It never blocks; if the Rotator is
+// busy, any configuration it has not picked up yet is replaced.
+func (r *Rotator) Update(routerConfig *model.RouterConfig) {
+	for {
+		select {
+		case r.configs <- routerConfig:
+			return
+		default:
+		}
+		select {
+		case <-r.configs:
+		default:
+		}
+	}
+}
+
+// Run creates and rotates session ticket keys for the latest router configuration whenever it
+// changes, and periodically otherwise. It never returns.
+func (r *Rotator) Run() {
+	var routerConfig *model.RouterConfig
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case routerConfig = <-r.configs:
+		case <-ticker.C:
+		}
+		if routerConfig != nil && usesSessionTickets(routerConfig) {
+			if err := r.sync(routerConfig.SSLConfig.SessionTicketKeyRotation, time.Now()); err != nil {
+				log.Printf("WARN: Failed to rotate the session ticket keys: %v\n", err)
+			}
+		}
+	}
+}
+
+func (r *Rotator) sync(rotation time.Duration, now time.Time) error {
+	secretClient := r.kubeClient.CoreV1().Secrets(namespace)
+	secret, err := secretClient.Get(secretName, metav1.GetOptions{})
+	if err != nil {
+		statusErr, ok := err.(*errors.StatusError)
+		if !ok || statusErr.Status().Code != 404 {
+			return err
+		}
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      secretName,
+				Namespace: namespace,
+				Labels: map[string]string{
+					"heritage": "deis",
+				},
+			},
+		}
+	}
+	if !rotate(secret, rotation, now, rand.Reader) {
+		return nil
+	}
+	if secret.ResourceVersion == "" {
+		_, err = secretClient.Create(secret)
+	} else {
+		_, err = secretClient.Update(secret)
+	}
+	// Another replica may have been quicker; its keys will be picked up instead.
+	if statusErr, ok := err.(*errors.StatusError); ok && statusErr.Status().Code == 409 {
+		return nil
+	}
+	if err == nil {
+		log.Println("INFO: Rotated the session ticket keys.")
+	}
+	return err
+}
+
+// rotate promotes the secret's staged key to the front of its keys, dropping the oldest ones, and
+// stages a new key, if the keys were last rotated longer ago than the rotation interval or are
+// missing or malformed. Staged keys are only used for decrypting tickets until the next rotation,
+// so that all replicas have picked them up by the time any replica encrypts tickets with them. It
+// returns whether it rotated the keys.
+func rotate(secret *corev1.Secret, rotation time.Duration, now time.Time, random io.Reader) bool {
+	keys := secret.Data["keys"]
+	if len(keys) == 0 || len(keys)%model.SessionTicketKeySize != 0 {
+		keys = nil
+	}
+	staged := secret.Data["staged"]
+	if len(staged) != model.SessionTicketKeySize {
+		staged = nil
+	}
+	if keys != nil && staged != nil {
+		rotatedAt, err := time.Parse(time.RFC3339, secret.Annotations[rotatedAnnotation])
+		if err == nil && now.Sub(rotatedAt) < rotation {
+			return false
+		}
+	}
+	var promoted []byte
+	switch {
+	case keys == nil:
+		// Without any key to encrypt tickets with, a new one is used right away.
+		var err error
+		if promoted, err = newKey(random); err != nil {
+			log.Printf("WARN: Failed to generate a session ticket key: %v\n", err)
+			return false
+		}
+	case staged != nil:
+		promoted = staged
+	}
+	nextStaged, err := newKey(random)
+	if err != nil {
+		log.Printf("WARN: Failed to generate a session ticket key: %v\n", err)
+		return false
+	}
+	keys = append(append([]byte{}, promoted...), keys...)
+	if len(keys) > keyCount*model.SessionTicketKeySize {
+		keys = keys[:keyCount*model.SessionTicketKeySize]
+	}
+	if secret.Annotations == nil {
+		secret.Annotations = make(map[string]string)
+	}
+	secret.Annotations[rotatedAnnotation] = now.UTC().Format(time.RFC3339)
+	if secret.Data == nil {
+		secret.Data = make(map[string][]byte)
+	}
+	secret.Data["keys"] = keys
+	secret.Data["staged"] = nextStaged
+	return true
+}
+
+// newKey returns a new random session ticket key.
+func newKey(random io.Reader) ([]byte, error) {
+	key := make([]byte, model.SessionTicketKeySize)
+	if _, err := io.ReadFull(random, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// usesSessionTickets returns whether any of the router's servers uses session tickets.
+func usesSessionTickets(routerConfig *model.RouterConfig) bool {
+	if routerConfig.SSLConfig.UseSessionTickets {
+		return true
+	}
+	for _, appConfig := range routerConfig.AppConfigs {
+		if appConfig.SSLConfig.UseSessionTickets {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,121 @@
+package tickets
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/teamhephy/router/model"
+	corev1 "k8s.io/api/core/v1"
+)
+
+var now = time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+func TestRotate(t *testing.T) {
+	secret := &corev1.Secret{}
+	random := strings.NewReader(strings.Repeat("a", model.SessionTicketKeySize) +
+		strings.Repeat("b", model.SessionTicketKeySize) +
+		strings.Repeat("c", model.SessionTicketKeySize) +
+		strings.Repeat("d", model.SessionTicketKeySize) +
+		strings.Repeat("e", model.SessionTicketKeySize))
+
+	// A missing key is created right away, and another one staged.
+	if !rotate(secret, time.Hour, now, random) {
+		t.Fatalf("Expected a key to be created.")
+	}
+	if want := strings.Repeat("a", model.SessionTicketKeySize); string(secret.Data["keys"]) != want {
+		t.Errorf("Expected keys %q, got %q", want, secret.Data["keys"])
+	}
+	if want := strings.Repeat("b", model.SessionTicketKeySize); string(secret.Data["staged"]) != want {
+		t.Errorf("Expected the staged key %q, got %q", want, secret.Data["staged"])
+	}
+	if want := "2020-01-01T00:00:00Z"; secret.Annotations[rotatedAnnotation] != want {
+		t.Errorf("Expected rotation time %s, got %s", want, secret.Annotations[rotatedAnnotation])
+	}
+
+	// Keys are not rotated before they are due.
+	if rotate(secret, time.Hour, now.Add(59*time.Minute), random) {
+		t.Errorf("Expected no rotation before the interval has passed.")
+	}
+
+	// Staged keys are promoted to the front, and only the newest ones are kept.
+	for i := 1; i <= 3; i++ {
+		if !rotate(secret, time.Hour, now.Add(time.Duration(i)*time.Hour), random) {
+			t.Fatalf("Expected rotation %d to happen.", i)
+		}
+	}
+	want := strings.Repeat("d", model.SessionTicketKeySize) +
+		strings.Repeat("c", model.SessionTicketKeySize) +
+		strings.Repeat("b", model.SessionTicketKeySize)
+	if string(secret.Data["keys"]) != want {
+		t.Errorf("Expected keys %q, got %q", want, secret.Data["keys"])
+	}
+	if want := strings.Repeat("e", model.SessionTicketKeySize); string(secret.Data["staged"]) != want {
+		t.Errorf("Expected the staged key %q, got %q", want, secret.Data["staged"])
+	}
+}
+
+func TestRotateStagesMissingKey(t *testing.T) {
+	// Keys without a staged one keep encrypting with the current key until the staged one is due.
+	current := bytes.Repeat([]byte{1}, model.SessionTicketKeySize)
+	secret := &corev1.Secret{
+		Data: map[string][]byte{"keys": current},
+	}
+	secret.Annotations = map[string]string{rotatedAnnotation: now.Format(time.RFC3339)}
+	random := bytes.NewReader(bytes.Repeat([]byte{2}, model.SessionTicketKeySize))
+	if !rotate(secret, time.Hour, now, random) {
+		t.Fatalf("Expected a key to be staged.")
+	}
+	if !bytes.Equal(secret.Data["keys"], current) {
+		t.Errorf("Expected keys %q, got %q", current, secret.Data["keys"])
+	}
+	if want := bytes.Repeat([]byte{2}, model.SessionTicketKeySize); !bytes.Equal(secret.Data["staged"], want) {
+		t.Errorf("Expected the staged key %q, got %q", want, secret.Data["staged"])
+	}
+}
+
+func TestRotateReplacesMalformedKeys(t *testing.T) {
+	secret := &corev1.Secret{
+		Data: map[string][]byte{"keys": []byte("foo")},
+	}
+	secret.Annotations = map[string]string{rotatedAnnotation: now.Format(time.RFC3339)}
+	random := bytes.NewReader(append(bytes.Repeat([]byte{1}, model.SessionTicketKeySize), bytes.Repeat([]byte{2}, model.SessionTicketKeySize)...))
+	if !rotate(secret, time.Hour, now, random) {
+		t.Fatalf("Expected malformed keys to be replaced.")
+	}
+	if want := bytes.Repeat([]byte{1}, model.SessionTicketKeySize); !bytes.Equal(secret.Data["keys"], want) {
+		t.Errorf("Expected keys %q, got %q", want, secret.Data["keys"])
+	}
+}
+
+func TestUsesSessionTickets(t *testing.T) {
+	routerConfig := &model.RouterConfig{
+		SSLConfig:  &model.SSLConfig{},
+		AppConfigs: []*model.AppConfig{{SSLConfig: &model.SSLConfig{}}},
+	}
+	if usesSessionTickets(routerConfig) {
+		t.Errorf("Expected no session tickets to be used.")
+	}
+	routerConfig.AppConfigs[0].SSLConfig.UseSessionTickets = true
+	if !usesSessionTickets(routerConfig) {
+		t.Errorf("Expected session tickets to be used by the app.")
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	r := NewRotator(nil)
+	first := &model.RouterConfig{}
+	second := &model.RouterConfig{}
+	// Neither update may block, and only the latest configuration should be picked up.
+	r.Update(first)
+	r.Update(second)
+	if got := <-r.configs; got != second {
+		t.Errorf("Expected the latest configuration to be picked up.")
+	}
+	select {
+	case <-r.configs:
+		t.Errorf("Expected no other configuration to be picked up.")
+	default:
+	}
+}
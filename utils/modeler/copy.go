@@ -0,0 +1,93 @@
+package modeler
+
+import (
+	"reflect"
+)
+
+// copyKey identifies a pointer or map that was already copied. The type is part of it, as a
+// pointer to a struct and one to its first field share an address.
+type copyKey struct {
+	pointer uintptr
+	rt      reflect.Type
+}
+
+// DeepCopy returns a copy of the provided value, such as a pointer to a model, that shares nothing
+// with it that could be modified through either: what pointers, slices, maps, and interfaces refer
+// to is copied too. Anything referred to more than once, including through cycles, is copied once,
+// so the copy has the same shape as the original. Unexported struct fields, such as those of a
+// time.Time, are copied as they are.
+func DeepCopy(v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	return deepCopy(reflect.ValueOf(v)).Interface()
+}
+
+func deepCopy(v reflect.Value) reflect.Value {
+	copies := make(map[copyKey]reflect.Value)
+	return copyValue(v, copies)
+}
+
+func copyValue(v reflect.Value, copies map[copyKey]reflect.Value) reflect.Value {
+	rt := v.Type()
+	switch rt.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return reflect.Zero(rt)
+		}
+		key := copyKey{pointer: v.Pointer(), rt: rt}
+		if c, ok := copies[key]; ok {
+			return c
+		}
+		c := reflect.New(rt.Elem())
+		copies[key] = c
+		c.Elem().Set(copyValue(v.Elem(), copies))
+		return c
+	case reflect.Struct:
+		c := reflect.New(rt).Elem()
+		c.Set(v)
+		for i := 0; i < rt.NumField(); i++ {
+			if c.Field(i).CanSet() {
+				c.Field(i).Set(copyValue(v.Field(i), copies))
+			}
+		}
+		return c
+	case reflect.Slice:
+		if v.IsNil() {
+			return reflect.Zero(rt)
+		}
+		c := reflect.MakeSlice(rt, v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(copyValue(v.Index(i), copies))
+		}
+		return c
+	case reflect.Array:
+		c := reflect.New(rt).Elem()
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(copyValue(v.Index(i), copies))
+		}
+		return c
+	case reflect.Map:
+		if v.IsNil() {
+			return reflect.Zero(rt)
+		}
+		key := copyKey{pointer: v.Pointer(), rt: rt}
+		if c, ok := copies[key]; ok {
+			return c
+		}
+		c := reflect.MakeMapWithSize(rt, v.Len())
+		copies[key] = c
+		for _, k := range v.MapKeys() {
+			c.SetMapIndex(copyValue(k, copies), copyValue(v.MapIndex(k), copies))
+		}
+		return c
+	case reflect.Interface:
+		if v.IsNil() {
+			return reflect.Zero(rt)
+		}
+		c := reflect.New(rt).Elem()
+		c.Set(copyValue(v.Elem(), copies))
+		return c
+	}
+	return v
+}
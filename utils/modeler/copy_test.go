@@ -0,0 +1,58 @@
+package modeler
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+type CopySampleModel struct {
+	Name     string
+	Tags     []string
+	Labels   map[string]string
+	Sub      *CopySampleModel
+	Parent   *CopySampleModel
+	Created  time.Time
+	Anything interface{}
+	private  *int
+}
+
+func TestDeepCopy(t *testing.T) {
+	n := 1
+	original := &CopySampleModel{
+		Name:     "foo",
+		Tags:     []string{"a", "b"},
+		Labels:   map[string]string{"a": "b"},
+		Sub:      &CopySampleModel{Name: "bar"},
+		Created:  time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		Anything: []int{1},
+		private:  &n,
+	}
+	original.Sub.Parent = original
+	copied := DeepCopy(original).(*CopySampleModel)
+	if !reflect.DeepEqual(original, copied) {
+		t.Errorf("Expected %+v, but got %+v", original, copied)
+	}
+	// Nothing is shared, except through unexported fields, and cycles are kept.
+	copied.Tags[0] = "changed"
+	copied.Labels["a"] = "changed"
+	copied.Sub.Name = "changed"
+	copied.Anything.([]int)[0] = 2
+	if original.Tags[0] != "a" || original.Labels["a"] != "b" || original.Sub.Name != "bar" || original.Anything.([]int)[0] != 1 {
+		t.Errorf("Expected the original to be unchanged, but got %+v", original)
+	}
+	if copied.Sub.Parent != copied {
+		t.Error("Expected the cycle to lead back to the copy.")
+	}
+	if copied.private != original.private {
+		t.Error("Expected unexported fields to be copied as they are.")
+	}
+	// Nil slices and maps stay nil, and empty ones empty.
+	empty := DeepCopy(&CopySampleModel{Tags: []string{}}).(*CopySampleModel)
+	if empty.Tags == nil || len(empty.Tags) != 0 || empty.Labels != nil {
+		t.Errorf("Expected an empty slice and a nil map, but got %#v and %#v", empty.Tags, empty.Labels)
+	}
+	if DeepCopy(nil) != nil {
+		t.Error("Expected nil to be copied as nil.")
+	}
+}
@@ -0,0 +1,128 @@
+package modeler
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// FieldDescription describes a field of a model in terms of the map key it is populated from.
+// Type names the kind of value the key takes, such as "integer" or "list of strings". Default is
+// the value the field has unless the key is given, and is only meaningful if HasDefault is set.
+type FieldDescription struct {
+	Key        string
+	Type       string
+	Constraint string
+	Default    string
+	HasDefault bool
+}
+
+// Describe returns a description of each field of the provided model that can be populated from a
+// map, sorted by key. The model's values, where set, are taken to be the defaults. Like KnownKeys,
+// it accepts nil pointers, in which case no defaults are described.
+func (m *Modeler) Describe(initialContext string, model interface{}) ([]FieldDescription, error) {
+	rt := reflect.TypeOf(model)
+	if rt == nil {
+		return nil, newNilLiteralModelError()
+	}
+	if rt.Kind() != reflect.Ptr {
+		return nil, newNonPointerModelError(rt)
+	}
+	if rt.Elem().Kind() != reflect.Struct {
+		return nil, newNonStructPointerModelError(rt)
+	}
+	var descriptions []FieldDescription
+	if err := m.describe(initialContext, rt, reflect.ValueOf(model), &descriptions); err != nil {
+		return nil, err
+	}
+	sort.Slice(descriptions, func(i, j int) bool {
+		return descriptions[i].Key < descriptions[j].Key
+	})
+	return descriptions, nil
+}
+
+func (m *Modeler) describe(context string, rt reflect.Type, rv reflect.Value, descriptions *[]FieldDescription) error {
+	// Nested models must be struct pointers, just as mapToModel requires.
+	if rt.Kind() != reflect.Ptr {
+		return newNonPointerModelError(rt)
+	}
+	var elem reflect.Value
+	if rv.IsValid() && !rv.IsNil() {
+		elem = rv.Elem()
+	}
+	rt = rt.Elem()
+	for i := 0; i < rt.NumField(); i++ {
+		rf := rt.Field(i)
+		fieldTagValue := m.fieldTagValue(rf)
+		if fieldTagValue == "" {
+			continue
+		}
+		var field reflect.Value
+		if elem.IsValid() {
+			field = elem.Field(i)
+		}
+		if isNested(rf.Type) {
+			if err := m.describe(m.nestedContext(context, fieldTagValue), rf.Type, field, descriptions); err != nil {
+				return err
+			}
+			continue
+		}
+		typeName, err := describeType(rf.Type)
+		if err != nil {
+			return err
+		}
+		description := FieldDescription{
+			Key:        m.key(context, fieldTagValue),
+			Type:       typeName,
+			Constraint: rf.Tag.Get(m.constraintTag),
+		}
+		if field.IsValid() {
+			description.Default, description.HasDefault, err = m.formatField(field)
+			if err != nil {
+				return err
+			}
+		}
+		*descriptions = append(*descriptions, description)
+	}
+	return nil
+}
+
+// describeType names the kind of value fields of the type are populated from.
+func describeType(rt reflect.Type) (string, error) {
+	if isModelSlice(rt) {
+		return "list of objects", nil
+	}
+	if isUnmarshaler(rt) {
+		return "string", nil
+	}
+	if rt == durationType {
+		return "duration", nil
+	}
+	switch rt.Kind() {
+	case reflect.Ptr:
+		return describeType(rt.Elem())
+	case reflect.String:
+		return "string", nil
+	case reflect.Bool:
+		return "boolean", nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "integer", nil
+	case reflect.Float32, reflect.Float64:
+		return "number", nil
+	case reflect.Slice:
+		if isScalar(rt.Elem()) {
+			elem, err := describeType(rt.Elem())
+			return fmt.Sprintf("list of %ss", elem), err
+		}
+	case reflect.Map:
+		if isScalar(rt.Key()) && isScalar(rt.Elem()) {
+			key, err := describeType(rt.Key())
+			if err != nil {
+				return "", err
+			}
+			elem, err := describeType(rt.Elem())
+			return fmt.Sprintf("map of %ss to %ss", key, elem), err
+		}
+	}
+	return "", UnsupportedTypeError{Type: rt}
+}
@@ -0,0 +1,190 @@
+package modeler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// decodeDocument decodes a JSON or YAML document. Numbers are kept as json.Number, so that they
+// can be turned back into strings exactly as written.
+func decodeDocument(document string) (interface{}, error) {
+	j, err := yaml.ToJSON([]byte(document))
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(j))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// asObject returns a decoded document value that must be an object, given either as such or as a
+// document of its own.
+func asObject(value interface{}) (map[string]interface{}, error) {
+	if document, ok := value.(string); ok {
+		var err error
+		if value, err = decodeDocument(document); err != nil {
+			return nil, err
+		}
+	}
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected a JSON or YAML object")
+	}
+	return object, nil
+}
+
+// asObjects returns a decoded document value that must be an array of objects, given either as
+// such or as a document of its own.
+func asObjects(value interface{}) ([]map[string]interface{}, error) {
+	if document, ok := value.(string); ok {
+		var err error
+		if value, err = decodeDocument(document); err != nil {
+			return nil, err
+		}
+	}
+	array, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected a JSON or YAML array of objects")
+	}
+	objects := make([]map[string]interface{}, len(array))
+	for i, elem := range array {
+		object, ok := elem.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("expected element %d to be an object", i)
+		}
+		objects[i] = object
+	}
+	return objects, nil
+}
+
+// encodeDocumentValue returns the string a value decoded from a document would have been given as
+// in a flat map, so that it is validated and parsed the same way. Scalars are given as is, arrays
+// of scalars as lists, and objects of scalars as maps.
+func encodeDocumentValue(value interface{}) (string, error) {
+	switch value := value.(type) {
+	case string:
+		return value, nil
+	case json.Number:
+		return value.String(), nil
+	case bool:
+		return strconv.FormatBool(value), nil
+	case []interface{}:
+		entries := make([]string, len(value))
+		for i, elem := range value {
+			entry, err := encodeScalar(elem)
+			if err != nil {
+				return "", err
+			}
+			entries[i] = quoteEntry(entry)
+		}
+		return strings.Join(entries, ","), nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		pairs := make([]string, len(keys))
+		for i, key := range keys {
+			elem, err := encodeScalar(value[key])
+			if err != nil {
+				return "", fmt.Errorf("%s: %v", key, err)
+			}
+			pairs[i] = fmt.Sprintf("%s:%s", quoteEntry(key), quoteEntry(elem))
+		}
+		return strings.Join(pairs, ","), nil
+	}
+	return "", fmt.Errorf("unsupported value %v", value)
+}
+
+// encodeScalar returns the string an element of an array or object would have been given as.
+func encodeScalar(value interface{}) (string, error) {
+	switch value.(type) {
+	case []interface{}, map[string]interface{}:
+		return "", fmt.Errorf("nested arrays and objects are not supported here")
+	}
+	return encodeDocumentValue(value)
+}
+
+// quoteEntry quotes a list entry, key, or value if it couldn't otherwise be told apart from what
+// surrounds it.
+func quoteEntry(s string) string {
+	if s != "" && strings.TrimSpace(s) == s && !strings.ContainsAny(s, ",:\"\\") {
+		return s
+	}
+	return fmt.Sprintf("\"%s\"", strings.NewReplacer("\\", "\\\\", "\"", "\\\"").Replace(s))
+}
+
+// isModelSlice returns whether a field of the type holds a slice of models, which can only be
+// populated from a document.
+func isModelSlice(rt reflect.Type) bool {
+	return rt.Kind() == reflect.Slice && isNested(rt.Elem()) && !isUnmarshaler(rt)
+}
+
+// formatField returns the string a field would be populated from, and whether it is set at all.
+// Slices of models are given as JSON arrays of objects, and other fields as formatValue does.
+func (m *Modeler) formatField(field reflect.Value) (string, bool, error) {
+	if !isModelSlice(field.Type()) {
+		return formatValue(field)
+	}
+	if field.IsNil() {
+		return "", false, nil
+	}
+	objects := make([]map[string]interface{}, field.Len())
+	for i := range objects {
+		object, err := m.modelToObject(field.Index(i))
+		if err != nil {
+			return "", false, err
+		}
+		objects[i] = object
+	}
+	b, err := json.Marshal(objects)
+	return string(b), true, err
+}
+
+// modelToObject returns the object a model would be populated from if given as a document, with
+// every value that is set given as a string.
+func (m *Modeler) modelToObject(rv reflect.Value) (map[string]interface{}, error) {
+	if rv.Kind() == reflect.Ptr {
+		rv = rv.Elem()
+	}
+	object := make(map[string]interface{})
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		fieldTagValue := m.fieldTagValue(rt.Field(i))
+		if fieldTagValue == "" {
+			continue
+		}
+		field := rv.Field(i)
+		if isNested(field.Type()) {
+			if field.Kind() == reflect.Ptr && field.IsNil() {
+				continue
+			}
+			nested, err := m.modelToObject(field)
+			if err != nil {
+				return nil, err
+			}
+			object[fieldTagValue] = nested
+			continue
+		}
+		stringVal, ok, err := m.formatField(field)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			object[fieldTagValue] = stringVal
+		}
+	}
+	return object, nil
+}
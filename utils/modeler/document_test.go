@@ -0,0 +1,255 @@
+package modeler
+
+import (
+	"reflect"
+	"testing"
+)
+
+type RouteModel struct {
+	Path    string            `sample:"path" constraint:"^/"`
+	Port    int               `sample:"port"`
+	Methods []string          `sample:"methods"`
+	Headers map[string]string `sample:"headers"`
+}
+
+type RoutesModel struct {
+	Routes       []*RouteModel   `sample:"routes"`
+	DefaultRoute *RouteModel     `sample:"defaultRoute"`
+	Fallbacks    []RouteModel    `sample:"fallbacks"`
+	Sub          *RoutesSubModel `sample:"sub"`
+}
+
+type RoutesSubModel struct {
+	Enabled bool        `sample:"enabled"`
+	Route   *RouteModel `sample:"route"`
+}
+
+func newRoutesModel() *RoutesModel {
+	return &RoutesModel{
+		DefaultRoute: &RouteModel{},
+		Sub:          &RoutesSubModel{Route: &RouteModel{}},
+	}
+}
+
+func TestJSONDocument(t *testing.T) {
+	data := map[string]string{
+		prefix + "/defaultRoute": `{"path": "/", "port": 8080, "methods": ["GET", "a, b"], "headers": {"X-Foo": "bar:baz"}}`,
+	}
+	routesModel := newRoutesModel()
+	if err := m.MapToModel(data, "", routesModel); err != nil {
+		t.Fatal(err)
+	}
+	want := &RouteModel{
+		Path:    "/",
+		Port:    8080,
+		Methods: []string{"GET", "a, b"},
+		Headers: map[string]string{"X-Foo": "bar:baz"},
+	}
+	if !reflect.DeepEqual(want, routesModel.DefaultRoute) {
+		t.Errorf("Expected %+v, but got %+v", want, routesModel.DefaultRoute)
+	}
+}
+
+func TestYAMLDocument(t *testing.T) {
+	data := map[string]string{
+		prefix + "/sub": "enabled: true\nroute:\n  path: /yaml\n  port: 80\n",
+	}
+	routesModel := newRoutesModel()
+	if err := m.MapToModel(data, "", routesModel); err != nil {
+		t.Fatal(err)
+	}
+	checkBoolField(t, "true", routesModel.Sub.Enabled)
+	checkStringField(t, "/yaml", routesModel.Sub.Route.Path)
+	checkIntField(t, "80", routesModel.Sub.Route.Port)
+}
+
+func TestFlatKeysTakePrecedence(t *testing.T) {
+	data := map[string]string{
+		prefix + "/sub":            `{"enabled": false, "route": {"path": "/doc", "port": 80}}`,
+		prefix + "/sub.enabled":    "true",
+		prefix + "/sub.route.port": "8080",
+	}
+	routesModel := newRoutesModel()
+	if err := m.MapToModel(data, "", routesModel); err != nil {
+		t.Fatal(err)
+	}
+	checkBoolField(t, "true", routesModel.Sub.Enabled)
+	checkStringField(t, "/doc", routesModel.Sub.Route.Path)
+	checkIntField(t, "8080", routesModel.Sub.Route.Port)
+
+	// A document for a nested model takes precedence over the one for its parent, too.
+	data = map[string]string{
+		prefix + "/sub":       `{"route": {"path": "/parent"}}`,
+		prefix + "/sub.route": `{"port": 9090}`,
+	}
+	routesModel = newRoutesModel()
+	if err := m.MapToModel(data, "", routesModel); err != nil {
+		t.Fatal(err)
+	}
+	checkStringField(t, "", routesModel.Sub.Route.Path)
+	checkIntField(t, "9090", routesModel.Sub.Route.Port)
+}
+
+func TestModelSlice(t *testing.T) {
+	data := map[string]string{
+		prefix + "/routes":    `[{"path": "/a", "port": 80}, {"path": "/b", "methods": ["POST"]}]`,
+		prefix + "/fallbacks": "- path: /c\n",
+	}
+	routesModel := newRoutesModel()
+	if err := m.MapToModel(data, "", routesModel); err != nil {
+		t.Fatal(err)
+	}
+	want := []*RouteModel{
+		{Path: "/a", Port: 80},
+		{Path: "/b", Methods: []string{"POST"}},
+	}
+	if !reflect.DeepEqual(want, routesModel.Routes) {
+		t.Errorf("Expected %+v, but got %+v", want, routesModel.Routes)
+	}
+	if !reflect.DeepEqual([]RouteModel{{Path: "/c"}}, routesModel.Fallbacks) {
+		t.Errorf("Expected a single fallback for /c, but got %+v", routesModel.Fallbacks)
+	}
+}
+
+type RuleModel struct {
+	Name   string        `sample:"name"`
+	Weight int           `sample:"weight" default:"10"`
+	Target *RuleSubModel `sample:"target"`
+}
+
+type RuleSubModel struct {
+	Port int `sample:"port" default:"80"`
+}
+
+type RulesModel struct {
+	Rules []*RuleModel `sample:"rules"`
+}
+
+func TestModelSliceDefaults(t *testing.T) {
+	// Ensure elements start out with their defaults, nested models included.
+	data := map[string]string{
+		prefix + "/rules": `[{"name": "a"}, {"name": "b", "weight": 5, "target": {"port": 8080}}]`,
+	}
+	rulesModel := &RulesModel{}
+	if err := m.MapToModelCollectingErrors(data, "", rulesModel); err != nil {
+		t.Fatal(err)
+	}
+	want := []*RuleModel{
+		{Name: "a", Weight: 10, Target: &RuleSubModel{Port: 80}},
+		{Name: "b", Weight: 5, Target: &RuleSubModel{Port: 8080}},
+	}
+	if !reflect.DeepEqual(want, rulesModel.Rules) {
+		t.Errorf("Expected %+v, but got %+v", want, rulesModel.Rules)
+	}
+}
+
+func TestModelSlicePlanShared(t *testing.T) {
+	// Ensure elements share a plan rather than adding one per index.
+	modeler := NewModeler(prefix, fieldTag, constraintTag, false).WithDefaultTag(defaultTag)
+	data := map[string]string{
+		prefix + "/routes": `[{"path": "/a"}, {"path": "/b"}, {"path": "/c"}]`,
+	}
+	if err := modeler.MapToModel(data, "", newRoutesModel()); err != nil {
+		t.Fatal(err)
+	}
+	plans := 0
+	modeler.plans.Range(func(key, value interface{}) bool {
+		if key.(planKey).context == "routes[]" {
+			plans++
+		}
+		return true
+	})
+	if plans != 1 {
+		t.Errorf("Expected a single plan for the elements, but got %d", plans)
+	}
+}
+
+func TestDocumentErrors(t *testing.T) {
+	data := map[string]string{
+		prefix + "/defaultRoute": `{"path": "no-slash", "port": 80}`,
+		prefix + "/routes":       `[{"path": "/ok"}, {"path": "bad"}]`,
+		prefix + "/fallbacks":    `{"path": "/"}`,
+		prefix + "/sub":          `{"enabled": "maybe"`,
+	}
+	routesModel := newRoutesModel()
+	err := m.MapToModelCollectingErrors(data, "", routesModel)
+	errs, ok := err.(ModelValidationErrors)
+	if !ok {
+		t.Fatalf("Expected modeler.ModelValidationErrors, but got %v", err)
+	}
+	var fields []string
+	for _, validationErr := range errs {
+		fields = append(fields, validationErr.Field)
+	}
+	want := []string{
+		prefix + "/routes[1].path",
+		prefix + "/defaultRoute.path",
+		prefix + "/fallbacks",
+		prefix + "/sub",
+	}
+	if !reflect.DeepEqual(want, fields) {
+		t.Errorf("Expected errors for %v, but got %v", want, errs)
+	}
+	// Constraints apply to values from documents, and the rest of a document is still used, but a
+	// slice is only replaced if all of its elements were valid.
+	checkStringField(t, "", routesModel.DefaultRoute.Path)
+	checkIntField(t, "80", routesModel.DefaultRoute.Port)
+	if routesModel.Routes != nil {
+		t.Errorf("Expected the routes to be left unset, but got %+v", routesModel.Routes)
+	}
+}
+
+func TestEncodeDocumentValue(t *testing.T) {
+	value, err := decodeDocument(`{"list": ["a", " b", "c,d", "e\"f", 1.50, true], "map": {"k:1": "v", "k2": "x\\y"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	object := value.(map[string]interface{})
+	tests := []struct {
+		value interface{}
+		want  string
+	}{
+		{object["list"], `a," b","c,d","e\"f",1.50,true`},
+		{object["map"], `k2:"x\\y","k:1":v`},
+	}
+	for _, test := range tests {
+		got, err := encodeDocumentValue(test.value)
+		if err != nil {
+			t.Errorf("Using value %v, received an unexpected error: %s", test.value, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("Using value %v, expected `%s`, but got `%s`", test.value, test.want, got)
+		}
+	}
+	if _, err := encodeDocumentValue(map[string]interface{}{"nested": map[string]interface{}{}}); err == nil {
+		t.Error("Expected an error for a nested object, but got none")
+	}
+}
+
+func TestModelSliceRoundTrip(t *testing.T) {
+	routesModel := newRoutesModel()
+	routesModel.Routes = []*RouteModel{
+		{Path: "/a", Port: 80, Headers: map[string]string{"X-Foo": "a, b"}},
+		{Path: "/b", Methods: []string{"GET", "POST"}},
+	}
+	routesModel.Fallbacks = []RouteModel{}
+	data, err := m.ModelToMap("", routesModel, newRoutesModel())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		prefix + "/routes":    `[{"headers":"X-Foo:\"a, b\"","path":"/a","port":"80"},{"methods":"GET,POST","path":"/b","port":"0"}]`,
+		prefix + "/fallbacks": "[]",
+	}
+	if !reflect.DeepEqual(want, data) {
+		t.Errorf("Expected %v, but got %v", want, data)
+	}
+	parsedModel := newRoutesModel()
+	if err := m.MapToModel(data, "", parsedModel); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(routesModel, parsedModel) {
+		t.Errorf("Expected %+v, but got %+v", routesModel, parsedModel)
+	}
+}
@@ -3,6 +3,7 @@ package modeler
 import (
 	"fmt"
 	"reflect"
+	"strings"
 )
 
 // NilLiteralModelError represents a failed attempt to populate a "model" because a nil literal was
@@ -60,22 +61,85 @@ func (e NonStructPointerModelError) Error() string {
 	return fmt.Sprintf("Cannot populate non-struct-pointer type %s from the map.", e.tipe)
 }
 
+// DefaultsTypeError represents a failed attempt to turn a model into a map because the defaults
+// passed to the modeler are not of the model's type.
+type DefaultsTypeError struct {
+	tipe         reflect.Type
+	defaultsTipe reflect.Type
+}
+
+func newDefaultsTypeError(tipe reflect.Type, defaultsTipe reflect.Type) DefaultsTypeError {
+	return DefaultsTypeError{tipe: tipe, defaultsTipe: defaultsTipe}
+}
+
+func (e DefaultsTypeError) Error() string {
+	return fmt.Sprintf("Cannot compare type %s with defaults of type %s.", e.tipe, e.defaultsTipe)
+}
+
 // ModelValidationError represents an error resulting from a field having a value that doesn't
-// satisfy a prescribed constraint.
+// satisfy a prescribed constraint or can't be parsed as the field's type. Field is the map key
+// the value was found under. Err is the reason a value that satisfies any constraint still can't
+// be parsed.
 type ModelValidationError struct {
-	field      string
-	constraint string
-	value      string
+	Field      string
+	Constraint string
+	Value      string
+	Err        error
 }
 
 func newModelValidationError(field string, constraint string, value string) ModelValidationError {
 	return ModelValidationError{
-		field:      field,
-		constraint: constraint,
-		value:      value,
+		Field:      field,
+		Constraint: constraint,
+		Value:      value,
+	}
+}
+
+func newModelParseError(field string, value string, err error) ModelValidationError {
+	return ModelValidationError{
+		Field: field,
+		Value: value,
+		Err:   err,
 	}
 }
 
 func (e ModelValidationError) Error() string {
-	return fmt.Sprintf("Field \"%s\" value \"%s\" does not satisfy constraint /%s/", e.field, e.value, e.constraint)
+	if e.Err != nil {
+		return fmt.Sprintf("Field \"%s\" value \"%s\" cannot be parsed: %v", e.Field, e.Value, e.Err)
+	}
+	return fmt.Sprintf("Field \"%s\" value \"%s\" does not satisfy constraint /%s/", e.Field, e.Value, e.Constraint)
+}
+
+// ModelValidationErrors represents every error resulting from fields having values that don't
+// satisfy their prescribed constraints, in the order the fields were visited.
+type ModelValidationErrors []ModelValidationError
+
+func (e ModelValidationErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return fmt.Sprintf("%d field(s) failed validation: %s", len(e), strings.Join(messages, "; "))
+}
+
+// UnknownKeyError represents a map key under the modeler's prefix that doesn't correspond to any
+// tagged field, most likely because of a typo. Suggestion is the most similar known key, if any
+// is similar enough.
+type UnknownKeyError struct {
+	Key        string
+	Suggestion string
+}
+
+func newUnknownKeyError(key string, suggestion string) UnknownKeyError {
+	return UnknownKeyError{
+		Key:        key,
+		Suggestion: suggestion,
+	}
+}
+
+func (e UnknownKeyError) Error() string {
+	if e.Suggestion == "" {
+		return fmt.Sprintf("Key \"%s\" does not correspond to any field", e.Key)
+	}
+	return fmt.Sprintf("Key \"%s\" does not correspond to any field; did you mean \"%s\"?", e.Key, e.Suggestion)
 }
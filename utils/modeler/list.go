@@ -0,0 +1,108 @@
+package modeler
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SyntaxError represents a list or map value that doesn't follow the grammar described by
+// splitList. Offset is the byte offset into the value at which the problem was found.
+type SyntaxError struct {
+	Offset int
+	Msg    string
+}
+
+func (e SyntaxError) Error() string {
+	return fmt.Sprintf("%s at offset %d", e.Msg, e.Offset)
+}
+
+// splitList splits a list value into its entries. Entries are separated by commas, and
+// whitespace surrounding an entry is ignored, as are entries that are empty altogether. Within an
+// entry, a backslash escapes the character following it, and double quotes protect what they
+// enclose, so that separators, quotes, backslashes, and surrounding whitespace can all be part of
+// an entry: `"a, b", c\,d` has the entries `a, b` and `c,d`, and `""` is an empty entry.
+//
+// If pairs is set, each entry is a key and a value separated by the first colon that is neither
+// escaped nor quoted, and whitespace surrounding either is ignored: `a: b:c` maps `a` to `b:c`.
+// An entry without such a colon, or with an empty key, is an error. Each pair is returned as an
+// entry of two elements; otherwise, each entry has one.
+func splitList(s string, pairs bool) ([][]string, error) {
+	var entries [][]string
+	var fields []string
+	var field strings.Builder
+	// Bytes of the current field up to this length were escaped or quoted, and are kept even if
+	// they are whitespace.
+	protected := 0
+	// Whether the current entry contains anything escaped or quoted, which makes it non-empty.
+	entryProtected := false
+	entryStart := 0
+	quoteStart := -1
+	endField := func() {
+		// Trailing whitespace is ignored, unless it was escaped or quoted.
+		value := field.String()
+		fields = append(fields, value[:protected]+strings.TrimRight(value[protected:], " \t\n"))
+		field.Reset()
+		protected = 0
+	}
+	endEntry := func(offset int) error {
+		endField()
+		if len(fields) == 1 && fields[0] == "" && !entryProtected {
+			fields = nil
+			return nil
+		}
+		if pairs {
+			if len(fields) != 2 {
+				return SyntaxError{Offset: entryStart, Msg: fmt.Sprintf("entry \"%s\" has no key:value separator", strings.TrimSpace(s[entryStart:offset]))}
+			}
+			if fields[0] == "" {
+				return SyntaxError{Offset: entryStart, Msg: fmt.Sprintf("entry \"%s\" has an empty key", strings.TrimSpace(s[entryStart:offset]))}
+			}
+		}
+		entries = append(entries, fields)
+		fields = nil
+		entryProtected = false
+		return nil
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\':
+			if i+1 == len(s) {
+				return nil, SyntaxError{Offset: i, Msg: "unfinished escape"}
+			}
+			i++
+			field.WriteByte(s[i])
+			protected = field.Len()
+			entryProtected = true
+		case c == '"':
+			if quoteStart < 0 {
+				quoteStart = i
+			} else {
+				quoteStart = -1
+			}
+			protected = field.Len()
+			entryProtected = true
+		case quoteStart >= 0:
+			field.WriteByte(c)
+			protected = field.Len()
+		case c == ',':
+			if err := endEntry(i); err != nil {
+				return nil, err
+			}
+			entryStart = i + 1
+		case c == ':' && pairs && len(fields) == 0:
+			endField()
+		case (c == ' ' || c == '\t' || c == '\n') && field.Len() == 0:
+			// Leading whitespace is ignored.
+		default:
+			field.WriteByte(c)
+		}
+	}
+	if quoteStart >= 0 {
+		return nil, SyntaxError{Offset: quoteStart, Msg: "unterminated quote"}
+	}
+	if err := endEntry(len(s)); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
@@ -0,0 +1,87 @@
+package modeler
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitList(t *testing.T) {
+	tests := []struct {
+		value string
+		want  [][]string
+	}{
+		{"", nil},
+		{"foo", [][]string{{"foo"}}},
+		{"foo,bar, baz ", [][]string{{"foo"}, {"bar"}, {"baz"}}},
+		{"foo,,bar,", [][]string{{"foo"}, {"bar"}}},
+		{`"a, b", c\,d`, [][]string{{"a, b"}, {"c,d"}}},
+		{`" padded ",\ x\ `, [][]string{{" padded "}, {" x "}}},
+		{`"",foo`, [][]string{{""}, {"foo"}}},
+		{`say "hi, there"`, [][]string{{"say hi, there"}}},
+		{`quote\"s,back\\slash`, [][]string{{`quote"s`}, {`back\slash`}}},
+		{"foo:bar", [][]string{{"foo:bar"}}},
+	}
+	for _, test := range tests {
+		got, err := splitList(test.value, false)
+		if err != nil {
+			t.Errorf("Using value `%s`, received an unexpected error: %s", test.value, err)
+			continue
+		}
+		if !reflect.DeepEqual(test.want, got) {
+			t.Errorf("Using value `%s`, expected %q, but got %q", test.value, test.want, got)
+		}
+	}
+}
+
+func TestSplitListPairs(t *testing.T) {
+	tests := []struct {
+		value string
+		want  [][]string
+	}{
+		{"foo:bar, bat : baz", [][]string{{"foo", "bar"}, {"bat", "baz"}}},
+		{"/admin:http://example.com:8080", [][]string{{"/admin", "http://example.com:8080"}}},
+		{`a\:b:c`, [][]string{{"a:b", "c"}}},
+		{`"a:b":"c, d"`, [][]string{{"a:b", "c, d"}}},
+		{"foo:", [][]string{{"foo", ""}}},
+		{"foo:bar,", [][]string{{"foo", "bar"}}},
+	}
+	for _, test := range tests {
+		got, err := splitList(test.value, true)
+		if err != nil {
+			t.Errorf("Using value `%s`, received an unexpected error: %s", test.value, err)
+			continue
+		}
+		if !reflect.DeepEqual(test.want, got) {
+			t.Errorf("Using value `%s`, expected %q, but got %q", test.value, test.want, got)
+		}
+	}
+}
+
+func TestSplitListErrors(t *testing.T) {
+	tests := []struct {
+		value string
+		pairs bool
+		want  SyntaxError
+	}{
+		{`foo,"bar`, false, SyntaxError{Offset: 4, Msg: "unterminated quote"}},
+		{`foo\`, false, SyntaxError{Offset: 3, Msg: "unfinished escape"}},
+		{"foo:bar,baz", true, SyntaxError{Offset: 8, Msg: `entry "baz" has no key:value separator`}},
+		{"foo:bar, :baz", true, SyntaxError{Offset: 8, Msg: `entry ":baz" has an empty key`}},
+	}
+	for _, test := range tests {
+		_, err := splitList(test.value, test.pairs)
+		if !reflect.DeepEqual(test.want, err) {
+			t.Errorf("Using value `%s`, expected %v, but got %v", test.value, test.want, err)
+		}
+	}
+}
+
+func TestMalformedMap(t *testing.T) {
+	// Malformed map entries are rejected rather than causing a panic.
+	data := map[string]string{prefix + "/a_string_map": "foo:bar,baz"}
+	err := m.MapToModel(data, "", newSampleModel())
+	checkError(t, "modeler.ModelValidationError", err)
+	if _, ok := err.(ModelValidationError).Err.(SyntaxError); !ok {
+		t.Errorf("Expected a modeler.SyntaxError, but got %v", err)
+	}
+}
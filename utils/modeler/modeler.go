@@ -4,9 +4,9 @@ import (
 	"fmt"
 	"log"
 	"reflect"
-	"regexp"
-	"strconv"
+	"sort"
 	"strings"
+	"sync"
 )
 
 // Modeler is a utility for populating an arbitrary model's fields with values from a
@@ -15,7 +15,13 @@ type Modeler struct {
 	prefix                string
 	fieldTag              string
 	constraintTag         string
+	defaultTag            string
 	warnOnValidationError bool
+	// Fields whose scope tag names a scope other than this Modeler's are left alone, as if untagged.
+	scopeTag string
+	scope    string
+	// Plans for populating models, keyed by planKey, worked out the first time each is needed.
+	plans sync.Map
 }
 
 // NewModeler returns a pointer to a new Modeler, confgiured with the provided map key prefix and
@@ -29,13 +35,325 @@ func NewModeler(prefix string, fieldTag string, constraintTag string, warnOnVali
 	}
 }
 
+// WithDefaultTag returns a new Modeler like this one under which fields tagged with the given key
+// start out with the values it gives when defaults are applied.
+func (m *Modeler) WithDefaultTag(defaultTag string) *Modeler {
+	modeler := m.clone()
+	modeler.defaultTag = defaultTag
+	return modeler
+}
+
+// Scoped returns a new Modeler like this one that leaves alone fields whose tag with the given key
+// names a scope other than the given one, such as fields that only apply to some of the models
+// sharing a nested model. Fields without the tag are in every scope.
+func (m *Modeler) Scoped(scopeTag string, scope string) *Modeler {
+	scoped := m.clone()
+	scoped.scopeTag = scopeTag
+	scoped.scope = scope
+	return scoped
+}
+
+// clone returns a new Modeler configured like this one, with no plans worked out yet.
+func (m *Modeler) clone() *Modeler {
+	modeler := NewModeler(m.prefix, m.fieldTag, m.constraintTag, m.warnOnValidationError)
+	modeler.defaultTag = m.defaultTag
+	modeler.scopeTag = m.scopeTag
+	modeler.scope = m.scope
+	return modeler
+}
+
+// fieldTagValue returns the field's field tag, or "" if the field is out of this Modeler's scope.
+func (m *Modeler) fieldTagValue(rf reflect.StructField) string {
+	if m.scopeTag != "" {
+		if scope := rf.Tag.Get(m.scopeTag); scope != "" && scope != m.scope {
+			return ""
+		}
+	}
+	return rf.Tag.Get(m.fieldTag)
+}
+
 // MapToModel populates the provided model with values from the provided map.
 func (m *Modeler) MapToModel(data map[string]string, initialContext string, out interface{}) error {
 	rv := reflect.ValueOf(out)
-	return m.mapToModel(data, initialContext, rv)
+	return m.mapToModel(data, nil, initialContext, rv, nil)
+}
+
+// ApplyDefaults sets each field of the provided model that has a default tag to the value it gives,
+// parsed as a value from the map would be, and does the same for nested models, allocating any
+// that are nil. A default that doesn't satisfy its field's constraint or can't be parsed is
+// returned as a ModelValidationError.
+func (m *Modeler) ApplyDefaults(out interface{}) error {
+	rv := reflect.ValueOf(out)
+	if err := checkModel(rv); err != nil {
+		return err
+	}
+	plan, err := m.plan(rv.Elem().Type(), "")
+	if err != nil {
+		return err
+	}
+	applyDefaults(plan, rv.Elem())
+	return nil
+}
+
+func applyDefaults(plan *typePlan, elem reflect.Value) {
+	for _, field := range plan.fields {
+		fieldValue := elem.Field(field.index)
+		if field.nested != nil {
+			if fieldValue.IsNil() {
+				fieldValue.Set(reflect.New(fieldValue.Type().Elem()))
+			}
+			applyDefaults(field.nested, fieldValue.Elem())
+			continue
+		}
+		if field.defaultValue.IsValid() {
+			// Models mustn't share slices, maps, or pointers with the plan.
+			fieldValue.Set(deepCopy(field.defaultValue))
+		}
+	}
+}
+
+// MapToModelCollectingErrors populates the provided model with values from the provided map like
+// MapToModel does, but rather than warning about or returning the first value that doesn't
+// satisfy its constraint, it skips every such value and, once the whole model has been walked,
+// returns them all as ModelValidationErrors. Any other error is returned right away.
+func (m *Modeler) MapToModelCollectingErrors(data map[string]string, initialContext string, out interface{}) error {
+	rv := reflect.ValueOf(out)
+	var errs ModelValidationErrors
+	if err := m.mapToModel(data, nil, initialContext, rv, &errs); err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// KnownKeys returns the map keys, prefix included, that correspond to the tagged fields of the
+// provided model, sorted. The model may be a nil pointer, as only its type is considered.
+func (m *Modeler) KnownKeys(initialContext string, model interface{}) ([]string, error) {
+	rt := reflect.TypeOf(model)
+	if rt == nil {
+		return nil, newNilLiteralModelError()
+	}
+	if rt.Kind() != reflect.Ptr {
+		return nil, newNonPointerModelError(rt)
+	}
+	if rt.Elem().Kind() != reflect.Struct {
+		return nil, newNonStructPointerModelError(rt)
+	}
+	var keys []string
+	if err := m.knownKeys(initialContext, rt, &keys); err != nil {
+		return nil, err
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// ModelToMap returns the map the provided model would be populated from, which is the inverse of
+// MapToModel. Values the same as those of the provided defaults, a model of the same type, are
+// omitted. If defaults is nil, only fields that aren't set at all, such as nil pointers, are.
+func (m *Modeler) ModelToMap(initialContext string, model interface{}, defaults interface{}) (map[string]string, error) {
+	rv := reflect.ValueOf(model)
+	if err := checkModel(rv); err != nil {
+		return nil, err
+	}
+	dv := reflect.ValueOf(defaults)
+	if dv.IsValid() && dv.Type() != rv.Type() {
+		return nil, newDefaultsTypeError(rv.Type(), dv.Type())
+	}
+	data := make(map[string]string)
+	if err := m.modelToMap(initialContext, rv, dv, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (m *Modeler) modelToMap(context string, rv reflect.Value, dv reflect.Value, data map[string]string) error {
+	elem := rv.Elem()
+	// Defaults may be missing altogether, or for just some nested models.
+	var defaultElem reflect.Value
+	if dv.IsValid() && !dv.IsNil() {
+		defaultElem = dv.Elem()
+	}
+	rt := elem.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		rf := rt.Field(i)
+		fieldTagValue := m.fieldTagValue(rf)
+		if fieldTagValue == "" {
+			continue
+		}
+		var defaultField reflect.Value
+		if defaultElem.IsValid() {
+			defaultField = defaultElem.Field(i)
+		}
+		if isNested(rf.Type) {
+			// Nested models must be struct pointers, just as mapToModel requires.
+			if rf.Type.Kind() != reflect.Ptr {
+				return newNonPointerModelError(rf.Type)
+			}
+			if elem.Field(i).IsNil() {
+				continue
+			}
+			if err := m.modelToMap(m.nestedContext(context, fieldTagValue), elem.Field(i), defaultField, data); err != nil {
+				return err
+			}
+			continue
+		}
+		stringVal, ok, err := m.formatField(elem.Field(i))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		if defaultField.IsValid() {
+			defaultVal, ok, err := m.formatField(defaultField)
+			if err != nil {
+				return err
+			}
+			if ok && defaultVal == stringVal {
+				continue
+			}
+		}
+		data[m.key(context, fieldTagValue)] = stringVal
+	}
+	return nil
+}
+
+func (m *Modeler) knownKeys(context string, rt reflect.Type, keys *[]string) error {
+	// Nested models must be struct pointers, just as mapToModel requires.
+	if rt.Kind() != reflect.Ptr {
+		return newNonPointerModelError(rt)
+	}
+	rt = rt.Elem()
+	for i := 0; i < rt.NumField(); i++ {
+		rf := rt.Field(i)
+		fieldTagValue := m.fieldTagValue(rf)
+		if fieldTagValue == "" {
+			continue
+		}
+		if isNested(rf.Type) {
+			// A nested model may also be given as a whole, as a document.
+			*keys = append(*keys, m.key(context, fieldTagValue))
+			if err := m.knownKeys(m.nestedContext(context, fieldTagValue), rf.Type, keys); err != nil {
+				return err
+			}
+		} else {
+			*keys = append(*keys, m.key(context, fieldTagValue))
+		}
+	}
+	return nil
 }
 
-func (m *Modeler) mapToModel(data map[string]string, context string, rv reflect.Value) error {
+// UnknownKeys returns an UnknownKeyError for each key of the provided map that is under the
+// modeler's prefix, but isn't among the known keys, sorted by key. Each suggests the known key
+// most similar to the unknown one, if any is similar enough to likely be what was meant.
+func (m *Modeler) UnknownKeys(data map[string]string, knownKeys []string) []UnknownKeyError {
+	prefix := m.keyPrefix()
+	known := make(map[string]bool, len(knownKeys))
+	for _, knownKey := range knownKeys {
+		known[knownKey] = true
+	}
+	var errs []UnknownKeyError
+	for key := range data {
+		if !strings.HasPrefix(key, prefix) || known[key] {
+			continue
+		}
+		errs = append(errs, newUnknownKeyError(key, suggestKey(key, knownKeys)))
+	}
+	sort.Slice(errs, func(i, j int) bool {
+		return errs[i].Key < errs[j].Key
+	})
+	return errs
+}
+
+// suggestKey returns the known key closest to the unknown one by edit distance, ignoring case,
+// or "" if none is within a distance of a quarter of the unknown key's length, or at least 2.
+func suggestKey(key string, knownKeys []string) string {
+	maxDistance := len(key) / 4
+	if maxDistance < 2 {
+		maxDistance = 2
+	}
+	suggestion := ""
+	for _, knownKey := range knownKeys {
+		distance := editDistance(strings.ToLower(key), strings.ToLower(knownKey))
+		if distance <= maxDistance {
+			suggestion = knownKey
+			maxDistance = distance - 1
+		}
+	}
+	return suggestion
+}
+
+// editDistance returns the Levenshtein distance between two strings.
+func editDistance(a string, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = minInt(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
+
+func minInt(values ...int) int {
+	result := values[0]
+	for _, value := range values[1:] {
+		if value < result {
+			result = value
+		}
+	}
+	return result
+}
+
+func (m *Modeler) keyPrefix() string {
+	prefix := m.prefix
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix = fmt.Sprintf("%s/", prefix)
+	}
+	return prefix
+}
+
+func (m *Modeler) key(context string, fieldTagValue string) string {
+	if context == "" {
+		return fmt.Sprintf("%s%s", m.keyPrefix(), fieldTagValue)
+	}
+	return fmt.Sprintf("%s%s.%s", m.keyPrefix(), context, fieldTagValue)
+}
+
+func (m *Modeler) nestedContext(context string, fieldTagValue string) string {
+	if context == "" {
+		return fieldTagValue
+	}
+	return fmt.Sprintf("%s.%s", context, fieldTagValue)
+}
+
+// reject handles a value that can't be used for a field according to the modeler's mode: it is
+// collected if errs isn't nil, and otherwise either warned about or returned.
+func (m *Modeler) reject(err ModelValidationError, field reflect.Value, errs *ModelValidationErrors) error {
+	if errs != nil {
+		*errs = append(*errs, err)
+		return nil
+	}
+	if m.warnOnValidationError {
+		log.Printf("WARNING: %s -- skipping this field and using default value \"%v\".", err, field)
+		return nil
+	}
+	return err
+}
+
+// checkModel returns an error if rv isn't a pointer to a struct.
+func checkModel(rv reflect.Value) error {
 	// If rv is invalid (represents a nil literal), we cannot proceed.
 	if rv.Kind() == reflect.Invalid {
 		return newNilLiteralModelError()
@@ -55,89 +373,141 @@ func (m *Modeler) mapToModel(data map[string]string, context string, rv reflect.
 	if elem.Kind() != reflect.Struct {
 		return newNonStructPointerModelError(rv.Type())
 	}
-	rt := elem.Type()
-	for i := 0; i < rt.NumField(); i++ {
-		rf := rt.Field(i)
-		fieldTagValue := rf.Tag.Get(m.fieldTag)
-		// If no tag value is found...
-		if fieldTagValue == "" {
-			// Just move to the next field.
+	return nil
+}
+
+// mapToModel populates the model rv points to. Values are taken from data or, for keys it
+// doesn't contain, from the decoded document given for the model as a whole, if any.
+func (m *Modeler) mapToModel(data map[string]string, document map[string]interface{}, context string, rv reflect.Value, errs *ModelValidationErrors) error {
+	if err := checkModel(rv); err != nil {
+		return err
+	}
+	plan, err := m.plan(rv.Elem().Type(), context)
+	if err != nil {
+		return err
+	}
+	return m.applyPlan(plan, data, document, rv.Elem(), errs)
+}
+
+func (m *Modeler) applyPlan(plan *typePlan, data map[string]string, document map[string]interface{}, elem reflect.Value, errs *ModelValidationErrors) error {
+	for _, field := range plan.fields {
+		fieldValue := elem.Field(field.index)
+		// Values in the map take precedence over those in the document.
+		var value interface{}
+		if stringVal, ok := data[field.key]; ok {
+			value = stringVal
+		} else if docVal := document[field.fieldTagValue]; docVal != nil {
+			value = docVal
+		}
+		if field.nested != nil {
+			// We're nested... the model may also be given as a document, whose values its own keys
+			// take precedence over... use some recursion...
+			var nestedDocument map[string]interface{}
+			if value != nil {
+				var err error
+				nestedDocument, err = asObject(value)
+				if err != nil {
+					if err := m.reject(newModelParseError(field.key, fmt.Sprintf("%v", value), err), fieldValue, errs); err != nil {
+						return err
+					}
+				}
+			}
+			if err := checkModel(fieldValue); err != nil {
+				return err
+			}
+			if err := m.applyPlan(field.nested, data, nestedDocument, fieldValue.Elem(), errs); err != nil {
+				return err
+			}
 			continue
 		}
-		if rf.Type.Kind() == reflect.Ptr || rf.Type.Kind() == reflect.Struct {
-			// We're nested... use some recursion...
-			var nestedContext string
-			if context == "" {
-				nestedContext = fieldTagValue
-			} else {
-				nestedContext = fmt.Sprintf("%s.%s", context, fieldTagValue)
+		// We're not nested!
+		if value == nil {
+			continue
+		}
+		if field.modelSlice {
+			if err := m.mapToModelSlice(value, field.nestedContext, fieldValue, errs); err != nil {
+				return err
 			}
-			err := m.mapToModel(data, nestedContext, elem.Field(i))
-			if err != nil {
+			continue
+		}
+		// Values from a document are given as they would have been in the map, so that they're
+		// validated and parsed the same way.
+		stringVal, err := encodeDocumentValue(value)
+		if err != nil {
+			if err := m.reject(newModelParseError(field.key, fmt.Sprintf("%v", value), err), fieldValue, errs); err != nil {
 				return err
 			}
-		} else {
-			// We're not nested!
-			var key string
-			prefix := m.prefix
-			if prefix != "" && !strings.HasSuffix(prefix, "/") {
-				prefix = fmt.Sprintf("%s/", prefix)
-			}
-			if context == "" {
-				key = fmt.Sprintf("%s%s", prefix, fieldTagValue)
-			} else {
-				key = fmt.Sprintf("%s%s.%s", prefix, context, fieldTagValue)
-			}
-			stringVal, ok := data[key]
-			if ok {
-				constraintTagValue := rf.Tag.Get(m.constraintTag)
-				if constraintTagValue != "" {
-					constraint := regexp.MustCompile(constraintTagValue)
-					if !constraint.MatchString(stringVal) {
-						err := newModelValidationError(key, constraintTagValue, stringVal)
-						if m.warnOnValidationError {
-							log.Printf("WARNING: %s -- skipping this field and using default value \"%v\".", err, elem.Field(i))
-							continue
-						} else {
-							return err
-						}
-					}
-				}
-				if rf.Type.Kind() == reflect.String {
-					elem.Field(i).Set(reflect.ValueOf(stringVal))
-				} else if rf.Type.Kind() == reflect.Int {
-					intVal, err := strconv.Atoi(stringVal)
-					if err != nil {
-						return err
-					}
-					elem.Field(i).Set(reflect.ValueOf(intVal))
-				} else if rf.Type.Kind() == reflect.Bool {
-					boolVal, err := strconv.ParseBool(strings.ToLower(stringVal))
-					if err != nil {
-						return err
-					}
-					elem.Field(i).Set(reflect.ValueOf(boolVal))
-				} else if rf.Type.Kind() == reflect.Slice {
-					sliceVal := strings.Split(stringVal, ",")
-					for j, token := range sliceVal {
-						sliceVal[j] = strings.TrimSpace(token)
-					}
-					elem.Field(i).Set(reflect.ValueOf(sliceVal))
-				} else if rf.Type.Kind() == reflect.Map {
-					sliceVal := strings.Split(stringVal, ",")
-					mapVal := make(map[string]string, len(sliceVal))
-					for _, kvStr := range sliceVal {
-						kvTokens := strings.Split(kvStr, ":")
-						key := strings.TrimSpace(kvTokens[0])
-						value := strings.TrimSpace(kvTokens[1])
-						mapVal[key] = value
-					}
-					elem.Field(i).Set(reflect.ValueOf(mapVal))
-				} else {
-					return fmt.Errorf("Unsupported type %s", rf.Type.Kind())
-				}
+			continue
+		}
+		if field.constraint != nil && !field.constraint.MatchString(stringVal) {
+			if err := m.reject(newModelValidationError(field.key, field.constraintTagValue, stringVal), fieldValue, errs); err != nil {
+				return err
 			}
+			continue
 		}
+		parsed, err := field.parse(stringVal)
+		if err != nil {
+			if _, ok := err.(UnsupportedTypeError); ok {
+				return err
+			}
+			if err := m.reject(newModelParseError(field.key, stringVal, err), fieldValue, errs); err != nil {
+				return err
+			}
+			continue
+		}
+		fieldValue.Set(parsed)
+	}
+	return nil
+}
+
+// mapToModelSlice populates a slice of models from an array of objects, given as a document or
+// decoded from one. Each element starts out with its defaults and is populated like a model of its
+// own, under a context suffixed with its index, and the slice is replaced only if none of its
+// elements' values was rejected.
+func (m *Modeler) mapToModelSlice(value interface{}, context string, field reflect.Value, errs *ModelValidationErrors) error {
+	key := m.key("", context)
+	objects, err := asObjects(value)
+	if err != nil {
+		return m.reject(newModelParseError(key, fmt.Sprintf("%v", value), err), field, errs)
+	}
+	// All elements share the plan for the context without an index; their errors are given the
+	// index afterwards.
+	elemContext := context + "[]"
+	elemKey := m.key("", elemContext)
+	var elemErrs ModelValidationErrors
+	slice := reflect.MakeSlice(field.Type(), 0, len(objects))
+	for i, object := range objects {
+		elemType := field.Type().Elem()
+		ptr := reflect.New(elemType)
+		if elemType.Kind() == reflect.Ptr {
+			ptr.Elem().Set(reflect.New(elemType.Elem()))
+			ptr = ptr.Elem()
+		}
+		if err := m.ApplyDefaults(ptr.Interface()); err != nil {
+			return err
+		}
+		var objectErrs ModelValidationErrors
+		err := m.mapToModel(nil, object, elemContext, ptr, &objectErrs)
+		if err != nil {
+			return err
+		}
+		for _, objectErr := range objectErrs {
+			objectErr.Field = strings.Replace(objectErr.Field, elemKey, fmt.Sprintf("%s[%d]", key, i), 1)
+			elemErrs = append(elemErrs, objectErr)
+		}
+		if elemType.Kind() == reflect.Ptr {
+			slice = reflect.Append(slice, ptr)
+		} else {
+			slice = reflect.Append(slice, ptr.Elem())
+		}
+	}
+	for _, elemErr := range elemErrs {
+		if err := m.reject(elemErr, field, errs); err != nil {
+			return err
+		}
+	}
+	if len(elemErrs) == 0 {
+		field.Set(slice)
 	}
 	return nil
 }
@@ -6,18 +6,20 @@ import (
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 )
 
 const (
 	prefix        string = "sample"
 	fieldTag      string = "sample"
 	constraintTag string = "constraint"
+	defaultTag    string = "default"
 )
 
 var (
 	sampleData        = make(map[string]string)
 	invalidSampleData = make(map[string]string)
-	m                 = NewModeler(prefix, fieldTag, constraintTag, false)
+	m                 = NewModeler(prefix, fieldTag, constraintTag, false).WithDefaultTag(defaultTag)
 )
 
 type SampleModel struct {
@@ -90,6 +92,308 @@ func TestValidationError(t *testing.T) {
 	checkError(t, "modeler.ModelValidationError", err)
 }
 
+func TestValidationErrorFields(t *testing.T) {
+	sampleModel := newSampleModel()
+	err := m.MapToModel(invalidSampleData, "", sampleModel)
+	validationErr, ok := err.(ModelValidationError)
+	if !ok {
+		t.Fatalf("Expected a modeler.ModelValidationError, but got %v", err)
+	}
+	want := ModelValidationError{Field: prefix + "/a_string", Constraint: "^foobar$", Value: "invalid value"}
+	if validationErr != want {
+		t.Errorf("Expected %+v, but got %+v", want, validationErr)
+	}
+}
+
+// ConstrainedSampleModel constrains fields of a nested model as well as its own.
+type ConstrainedSampleModel struct {
+	SampleString   string                     `sample:"a_string" constraint:"^foobar$"`
+	SampleInt      int                        `sample:"an_int"`
+	SampleSubModel *ConstrainedSampleSubModel `sample:"a_submodel"`
+}
+
+type ConstrainedSampleSubModel struct {
+	SampleString string `sample:"a_string" constraint:"^foobar$"`
+}
+
+func newConstrainedSampleModel() *ConstrainedSampleModel {
+	return &ConstrainedSampleModel{SampleSubModel: &ConstrainedSampleSubModel{}}
+}
+
+func TestCollectingErrors(t *testing.T) {
+	data := map[string]string{
+		prefix + "/a_string":            "invalid value",
+		prefix + "/an_int":              "5",
+		prefix + "/a_submodel.a_string": "another invalid value",
+	}
+	sampleModel := newConstrainedSampleModel()
+	err := m.MapToModelCollectingErrors(data, "", sampleModel)
+	checkError(t, "modeler.ModelValidationErrors", err)
+	want := ModelValidationErrors{
+		{Field: prefix + "/a_string", Constraint: "^foobar$", Value: "invalid value"},
+		{Field: prefix + "/a_submodel.a_string", Constraint: "^foobar$", Value: "another invalid value"},
+	}
+	if !reflect.DeepEqual(want, err) {
+		t.Errorf("Expected %+v, but got %+v", want, err)
+	}
+	// Valid values are still applied, while invalid ones are skipped.
+	checkIntField(t, "5", sampleModel.SampleInt)
+	checkStringField(t, "", sampleModel.SampleString)
+	checkStringField(t, "", sampleModel.SampleSubModel.SampleString)
+
+	// Without invalid values, no error is returned at all.
+	if err := m.MapToModelCollectingErrors(sampleData, "", newConstrainedSampleModel()); err != nil {
+		t.Errorf("Expected no error, but got %v", err)
+	}
+}
+
+func TestKnownKeys(t *testing.T) {
+	keys, err := m.KnownKeys("", (*SampleModel)(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		prefix + "/a_bool",
+		prefix + "/a_string",
+		prefix + "/a_string_map",
+		prefix + "/a_string_slice",
+		prefix + "/a_submodel",
+		prefix + "/a_submodel.a_bool",
+		prefix + "/a_submodel.a_string",
+		prefix + "/a_submodel.a_string_slice",
+		prefix + "/a_submodel.an_int",
+		prefix + "/an_int",
+	}
+	if !reflect.DeepEqual(want, keys) {
+		t.Errorf("Expected %v, but got %v", want, keys)
+	}
+
+	_, err = m.KnownKeys("", (*BadSampleModel)(nil))
+	checkError(t, "modeler.NonPointerModelError", err)
+}
+
+type ScopedSampleModel struct {
+	SampleString   string `sample:"a_string"`
+	SampleInt      int    `sample:"an_int" scope:"a"`
+	SampleBool     bool   `sample:"a_bool" scope:"b"`
+	UnmappedString string
+}
+
+func TestScoped(t *testing.T) {
+	// Fields scoped to other scopes are left alone, as if untagged.
+	scoped := m.Scoped("scope", "a")
+	keys, err := scoped.KnownKeys("", (*ScopedSampleModel)(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{prefix + "/a_string", prefix + "/an_int"}
+	if !reflect.DeepEqual(want, keys) {
+		t.Errorf("Expected %v, but got %v", want, keys)
+	}
+	sampleModel := &ScopedSampleModel{}
+	data := map[string]string{prefix + "/an_int": "1", prefix + "/a_bool": "true"}
+	if err := scoped.MapToModel(data, "", sampleModel); err != nil {
+		t.Fatal(err)
+	}
+	if sampleModel.SampleInt != 1 || sampleModel.SampleBool {
+		t.Errorf("Expected only the in-scope field to be set, but got %+v", sampleModel)
+	}
+
+	// The unscoped modeler considers every field.
+	keys, err = m.KnownKeys("", (*ScopedSampleModel)(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 3 {
+		t.Errorf("Expected all 3 keys, but got %v", keys)
+	}
+}
+
+func TestModelToMap(t *testing.T) {
+	sampleModel := newSampleModel()
+	sampleModel.SampleString = "foobar"
+	sampleModel.SampleInt = 1
+	sampleModel.SampleStringSlice = []string{"a", "b,c"}
+	sampleModel.SampleSubModel.SampleBool = true
+	sampleModel.UnmappedString = "ignored"
+	defaults := newSampleModel()
+	defaults.SampleInt = 1
+	data, err := m.ModelToMap("", sampleModel, defaults)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Values equal to the defaults are omitted, as are nil slices and maps.
+	want := map[string]string{
+		prefix + "/a_string":          "foobar",
+		prefix + "/a_string_slice":    `a,"b,c"`,
+		prefix + "/a_submodel.a_bool": "true",
+	}
+	if !reflect.DeepEqual(want, data) {
+		t.Errorf("Expected %v, but got %v", want, data)
+	}
+
+	// Without defaults, every value that is set is included.
+	data, err = m.ModelToMap("", sampleModel, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 7 || data[prefix+"/an_int"] != "1" || data[prefix+"/a_submodel.a_string"] != "" {
+		t.Errorf("Expected all set values, but got %v", data)
+	}
+
+	_, err = m.ModelToMap("", sampleModel, newBadSampleModel())
+	checkError(t, "modeler.DefaultsTypeError", err)
+	_, err = m.ModelToMap("", newBadSampleModel(), nil)
+	checkError(t, "modeler.NonPointerModelError", err)
+	_, err = m.ModelToMap("", nil, nil)
+	checkError(t, "modeler.NilLiteralModelError", err)
+}
+
+func TestDescribe(t *testing.T) {
+	sampleModel := newSampleModel()
+	sampleModel.SampleString = "foobar"
+	descriptions, err := m.Describe("", sampleModel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []FieldDescription{
+		{Key: prefix + "/a_bool", Type: "boolean", Default: "false", HasDefault: true},
+		{Key: prefix + "/a_string", Type: "string", Constraint: "^foobar$", Default: "foobar", HasDefault: true},
+		{Key: prefix + "/a_string_map", Type: "map of strings to strings"},
+		{Key: prefix + "/a_string_slice", Type: "list of strings"},
+		{Key: prefix + "/a_submodel.a_bool", Type: "boolean", Default: "false", HasDefault: true},
+		{Key: prefix + "/a_submodel.a_string", Type: "string", Default: "", HasDefault: true},
+		{Key: prefix + "/a_submodel.a_string_slice", Type: "list of strings"},
+		{Key: prefix + "/a_submodel.an_int", Type: "integer", Default: "0", HasDefault: true},
+		{Key: prefix + "/an_int", Type: "integer", Default: "0", HasDefault: true},
+	}
+	if !reflect.DeepEqual(want, descriptions) {
+		t.Errorf("Expected %+v, but got %+v", want, descriptions)
+	}
+
+	// Without a model, only the types are described.
+	descriptions, err = m.Describe("", (*SampleModel)(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, description := range descriptions {
+		if description.HasDefault {
+			t.Errorf("Expected no default for %s, but got \"%s\"", description.Key, description.Default)
+		}
+	}
+}
+
+type DefaultsSampleModel struct {
+	SampleString      string                  `sample:"a_string" constraint:"^foo" default:"foobar"`
+	SampleInt         int                     `sample:"an_int" default:"5"`
+	SampleEmpty       string                  `sample:"an_empty_string" default:""`
+	SampleStringSlice []string                `sample:"a_string_slice" default:"a,b"`
+	SampleDuration    time.Duration           `sample:"a_duration" default:"12h"`
+	SampleSubModel    *DefaultsSampleSubModel `sample:"a_submodel"`
+	Unmapped          string                  `default:"ignored"`
+}
+
+type DefaultsSampleSubModel struct {
+	SampleBool bool   `sample:"a_bool" default:"true"`
+	SampleName string `sample:"a_name"`
+}
+
+type BadDefaultsSampleModel struct {
+	SampleString string `sample:"a_string" constraint:"^foobar$" default:"foo"`
+}
+
+func TestApplyDefaults(t *testing.T) {
+	sampleModel := &DefaultsSampleModel{SampleEmpty: "replaced"}
+	if err := m.ApplyDefaults(sampleModel); err != nil {
+		t.Fatal(err)
+	}
+	want := &DefaultsSampleModel{
+		SampleString:      "foobar",
+		SampleInt:         5,
+		SampleStringSlice: []string{"a", "b"},
+		SampleDuration:    12 * time.Hour,
+		SampleSubModel:    &DefaultsSampleSubModel{SampleBool: true},
+	}
+	if !reflect.DeepEqual(want, sampleModel) {
+		t.Errorf("Expected %+v, but got %+v", want, sampleModel)
+	}
+	// Models don't share defaults with each other.
+	sampleModel.SampleStringSlice[0] = "changed"
+	other := &DefaultsSampleModel{}
+	if err := m.ApplyDefaults(other); err != nil {
+		t.Fatal(err)
+	}
+	checkStringSliceField(t, "a,b", other.SampleStringSlice)
+	// Values from the map still take precedence.
+	if err := m.MapToModel(map[string]string{prefix + "/an_int": "7"}, "", other); err != nil {
+		t.Fatal(err)
+	}
+	checkIntField(t, "7", other.SampleInt)
+
+	err := m.ApplyDefaults(&BadDefaultsSampleModel{})
+	checkError(t, "modeler.ModelValidationError", err)
+	err = m.ApplyDefaults(newBadSampleModel())
+	checkError(t, "modeler.NonPointerModelError", err)
+
+	// Scoped modelers keep the default tag, while modelers configured without one leave fields alone.
+	scoped := &DefaultsSampleModel{}
+	if err := m.Scoped("scope", "a").ApplyDefaults(scoped); err != nil {
+		t.Fatal(err)
+	}
+	checkIntField(t, "5", scoped.SampleInt)
+	untagged := &DefaultsSampleModel{}
+	if err := NewModeler(prefix, fieldTag, constraintTag, false).ApplyDefaults(untagged); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(&DefaultsSampleModel{SampleSubModel: &DefaultsSampleSubModel{}}, untagged) {
+		t.Errorf("Expected no defaults, but got %+v", untagged)
+	}
+}
+
+func TestUnknownKeys(t *testing.T) {
+	keys, err := m.KnownKeys("", (*SampleModel)(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := map[string]string{
+		prefix + "/a_string":             "foobar",
+		prefix + "/a_strnig":             "foobar",
+		prefix + "/A_SUBMODEL.AN_INT":    "5",
+		prefix + "/something_else":       "foo",
+		"other/a_strnig":                 "foobar",
+		prefix + "/a_submodel.a_string":  "foobar",
+		prefix + "/a_submodel.a_strings": "foobar",
+	}
+	want := []UnknownKeyError{
+		{Key: prefix + "/A_SUBMODEL.AN_INT", Suggestion: prefix + "/a_submodel.an_int"},
+		{Key: prefix + "/a_strnig", Suggestion: prefix + "/a_string"},
+		{Key: prefix + "/a_submodel.a_strings", Suggestion: prefix + "/a_submodel.a_string"},
+		{Key: prefix + "/something_else"},
+	}
+	if got := m.UnknownKeys(data, keys); !reflect.DeepEqual(want, got) {
+		t.Errorf("Expected %+v, but got %+v", want, got)
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a    string
+		b    string
+		want int
+	}{
+		{"", "", 0},
+		{"maintenance", "maintenance", 0},
+		{"maintanance", "maintenance", 1},
+		{"kitten", "sitting", 3},
+		{"", "foo", 3},
+	}
+	for _, test := range tests {
+		if got := editDistance(test.a, test.b); got != test.want {
+			t.Errorf("Expected the distance between %q and %q to be %d, but got %d", test.a, test.b, test.want, got)
+		}
+	}
+}
+
 func TestMapping(t *testing.T) {
 	sampleModel := newSampleModel()
 	err := m.MapToModel(sampleData, "", sampleModel)
@@ -0,0 +1,134 @@
+package modeler
+
+import (
+	"reflect"
+	"regexp"
+	"sync"
+)
+
+var (
+	// Compiled constraints, keyed by the constraint tag values they were compiled from, shared by
+	// all modelers and types since many fields have the same constraint.
+	constraintsMutex sync.Mutex
+	constraints      = make(map[string]*regexp.Regexp)
+)
+
+// typePlan is what a modeler works out about a model type under a context before populating any
+// model of it, so that populating many models of the same type, as the router does for every
+// service, needn't repeat it.
+type typePlan struct {
+	fields []fieldPlan
+}
+
+// fieldPlan describes how to populate a tagged field: from which key, or document entry, to take
+// its value, how to validate and parse it, and which value it defaults to, if any. Fields holding
+// models have a plan of their own, and fields holding slices of models are populated from
+// documents by mapToModelSlice instead.
+type fieldPlan struct {
+	index              int
+	fieldTagValue      string
+	key                string
+	nested             *typePlan
+	nestedContext      string
+	modelSlice         bool
+	constraintTagValue string
+	constraint         *regexp.Regexp
+	parse              parser
+	defaultValue       reflect.Value
+}
+
+// planKey identifies a plan, as keys depend on the context a model is populated under.
+type planKey struct {
+	rt      reflect.Type
+	context string
+}
+
+// plan returns the plan for populating models of the struct type under the context, working it
+// out only the first time.
+func (m *Modeler) plan(rt reflect.Type, context string) (*typePlan, error) {
+	key := planKey{rt: rt, context: context}
+	if plan, ok := m.plans.Load(key); ok {
+		return plan.(*typePlan), nil
+	}
+	plan, err := m.newPlan(rt, context)
+	if err != nil {
+		return nil, err
+	}
+	actual, _ := m.plans.LoadOrStore(key, plan)
+	return actual.(*typePlan), nil
+}
+
+func (m *Modeler) newPlan(rt reflect.Type, context string) (*typePlan, error) {
+	plan := &typePlan{}
+	for i := 0; i < rt.NumField(); i++ {
+		rf := rt.Field(i)
+		fieldTagValue := m.fieldTagValue(rf)
+		if fieldTagValue == "" {
+			continue
+		}
+		field := fieldPlan{
+			index:         i,
+			fieldTagValue: fieldTagValue,
+			key:           m.key(context, fieldTagValue),
+			nestedContext: m.nestedContext(context, fieldTagValue),
+		}
+		switch {
+		case isNested(rf.Type):
+			// Nested models must be struct pointers.
+			if rf.Type.Kind() != reflect.Ptr {
+				return nil, newNonPointerModelError(rf.Type)
+			}
+			nested, err := m.plan(rf.Type.Elem(), field.nestedContext)
+			if err != nil {
+				return nil, err
+			}
+			field.nested = nested
+		case isModelSlice(rf.Type):
+			field.modelSlice = true
+		default:
+			field.constraintTagValue = rf.Tag.Get(m.constraintTag)
+			if field.constraintTagValue != "" {
+				field.constraint = compileConstraint(field.constraintTagValue)
+			}
+			field.parse = newParser(rf.Type)
+			if err := m.planDefault(&field, rf); err != nil {
+				return nil, err
+			}
+		}
+		plan.fields = append(plan.fields, field)
+	}
+	return plan, nil
+}
+
+// planDefault parses the default given by the field's default tag, if any, once and for all.
+// Defaults must satisfy the field's constraint like any other value.
+func (m *Modeler) planDefault(field *fieldPlan, rf reflect.StructField) error {
+	if m.defaultTag == "" {
+		return nil
+	}
+	defaultTagValue, ok := rf.Tag.Lookup(m.defaultTag)
+	if !ok {
+		return nil
+	}
+	if field.constraint != nil && !field.constraint.MatchString(defaultTagValue) {
+		return newModelValidationError(field.key, field.constraintTagValue, defaultTagValue)
+	}
+	value, err := field.parse(defaultTagValue)
+	if err != nil {
+		return newModelParseError(field.key, defaultTagValue, err)
+	}
+	field.defaultValue = value
+	return nil
+}
+
+// compileConstraint returns the compiled constraint, compiling it only the first time.
+func compileConstraint(constraintTagValue string) *regexp.Regexp {
+	constraintsMutex.Lock()
+	defer constraintsMutex.Unlock()
+	constraint, ok := constraints[constraintTagValue]
+	if !ok {
+		constraint = regexp.MustCompile(constraintTagValue)
+		constraints[constraintTagValue] = constraint
+	}
+	return constraint
+}
@@ -0,0 +1,68 @@
+package modeler
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestPlanCached(t *testing.T) {
+	modeler := NewModeler(prefix, fieldTag, constraintTag, false).WithDefaultTag(defaultTag)
+	rt := reflect.TypeOf(SampleModel{})
+	plan, err := modeler.plan(rt, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := modeler.plan(rt, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan != again {
+		t.Error("Expected the plan to be reused.")
+	}
+	// Keys depend on the context, so each context has a plan of its own.
+	other, err := modeler.plan(rt, "other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == plan || other.fields[0].key != prefix+"/other.a_string" {
+		t.Errorf("Expected a separate plan for another context, got %+v", other)
+	}
+	// Fields with the same constraint share the compiled constraint.
+	constrained, err := modeler.plan(reflect.TypeOf(ConstrainedSampleModel{}), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if constrained.fields[0].constraint != constrained.fields[2].nested.fields[0].constraint {
+		t.Error("Expected the compiled constraint to be shared.")
+	}
+
+	_, err = modeler.plan(reflect.TypeOf(BadSampleModel{}), "")
+	checkError(t, "modeler.NonPointerModelError", err)
+}
+
+func BenchmarkMapToModel(b *testing.B) {
+	// Populate models from many distinct maps, as the router does for the services of many apps.
+	const count = 1000
+	data := make([]map[string]string, count)
+	for i := range data {
+		data[i] = map[string]string{
+			prefix + "/a_string":                  "foobar",
+			prefix + "/an_int":                    fmt.Sprintf("%d", i),
+			prefix + "/a_bool":                    "true",
+			prefix + "/a_string_slice":            fmt.Sprintf("foo-%d,bar-%d", i, i),
+			prefix + "/a_string_map":              fmt.Sprintf("foo:%d,bar:%d", i, i),
+			prefix + "/a_submodel.a_string":       "foobar",
+			prefix + "/a_submodel.an_int":         fmt.Sprintf("%d", i),
+			prefix + "/a_submodel.a_string_slice": "a,b,c",
+		}
+	}
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		for i := range data {
+			if err := m.MapToModel(data[i], "", newSampleModel()); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,335 @@
+package modeler
+
+import (
+	"encoding"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	durationType        = reflect.TypeOf(time.Duration(0))
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	textMarshalerType   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	// A single component of an nginx-style duration, such as "30s" or "1h".
+	durationComponent = regexp.MustCompile(`^(\d+)(ms|s|m|h|d|w|M|y)`)
+	// Units durations are formatted in, from the largest to the smallest.
+	durationFormatUnits = []string{"w", "d", "h", "m", "s", "ms"}
+	// Durations of the units nginx understands, where a month is 30 days and a year 365.
+	durationUnits = map[string]time.Duration{
+		"ms": time.Millisecond,
+		"s":  time.Second,
+		"m":  time.Minute,
+		"h":  time.Hour,
+		"d":  24 * time.Hour,
+		"w":  7 * 24 * time.Hour,
+		"M":  30 * 24 * time.Hour,
+		"y":  365 * 24 * time.Hour,
+	}
+)
+
+// UnsupportedTypeError represents a failed attempt to populate a field of a type the modeler
+// doesn't know how to parse values for.
+type UnsupportedTypeError struct {
+	Type reflect.Type
+}
+
+func (e UnsupportedTypeError) Error() string {
+	return fmt.Sprintf("Unsupported type %s", e.Type)
+}
+
+// ParseDuration parses a duration the way nginx does: a sequence of numbers, each followed by one
+// of the units ms, s, m, h, d, w, M (30 days), or y (365 days), optionally separated by spaces,
+// such as "1h 30m". A single number without a unit is taken to be seconds. Durations too long to
+// be represented fail to parse.
+func ParseDuration(s string) (time.Duration, error) {
+	value := strings.TrimSpace(s)
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil && seconds >= 0 {
+		if seconds > math.MaxInt64/int64(time.Second) {
+			return 0, fmt.Errorf("invalid duration \"%s\": too long", s)
+		}
+		return time.Duration(seconds) * time.Second, nil
+	}
+	if value == "" {
+		return 0, fmt.Errorf("invalid duration \"%s\"", s)
+	}
+	var duration time.Duration
+	for value != "" {
+		match := durationComponent.FindStringSubmatch(value)
+		if match == nil {
+			return 0, fmt.Errorf("invalid duration \"%s\"", s)
+		}
+		n, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration \"%s\": %v", s, err)
+		}
+		unit := durationUnits[match[2]]
+		if n > int64(math.MaxInt64-duration)/int64(unit) {
+			return 0, fmt.Errorf("invalid duration \"%s\": too long", s)
+		}
+		duration += time.Duration(n) * unit
+		value = strings.TrimLeft(value[len(match[0]):], " ")
+	}
+	return duration, nil
+}
+
+// FormatDuration formats a duration the way ParseDuration parses it, as a number of the largest
+// unit it is a whole multiple of, not counting months and years, such as "90m" or "2d". Durations
+// shorter than a millisecond are lost, as nginx doesn't support them.
+func FormatDuration(d time.Duration) string {
+	if d == 0 {
+		return "0s"
+	}
+	for _, unit := range durationFormatUnits {
+		if d%durationUnits[unit] == 0 {
+			return fmt.Sprintf("%d%s", d/durationUnits[unit], unit)
+		}
+	}
+	return fmt.Sprintf("%dms", d/time.Millisecond)
+}
+
+// isUnmarshaler returns whether values of the type, or pointers to them, parse themselves.
+func isUnmarshaler(rt reflect.Type) bool {
+	return rt.Implements(textUnmarshalerType) || reflect.PtrTo(rt).Implements(textUnmarshalerType)
+}
+
+// isNested returns whether a field of the type holds a model of its own rather than a value.
+func isNested(rt reflect.Type) bool {
+	if isUnmarshaler(rt) {
+		return false
+	}
+	return rt.Kind() == reflect.Struct || (rt.Kind() == reflect.Ptr && rt.Elem().Kind() == reflect.Struct)
+}
+
+// parser returns the value of a given type represented by a string.
+type parser func(s string) (reflect.Value, error)
+
+// newParser returns a parser for values of the given type, working out how to parse them once
+// rather than for every value. Pointers are set to newly allocated values, so that a value that
+// was set can be told apart from one that wasn't. Slices and maps of values are parsed from lists
+// of values or key:value pairs, as described by splitList. Values of unsupported types fail to
+// parse with an UnsupportedTypeError.
+func newParser(rt reflect.Type) parser {
+	if rt.Kind() == reflect.Ptr && !rt.Implements(textUnmarshalerType) {
+		parseElem := newParser(rt.Elem())
+		return func(s string) (reflect.Value, error) {
+			v := reflect.New(rt).Elem()
+			elem, err := parseElem(s)
+			if err != nil {
+				return v, err
+			}
+			ptr := reflect.New(rt.Elem())
+			ptr.Elem().Set(elem)
+			v.Set(ptr)
+			return v, nil
+		}
+	}
+	if isUnmarshaler(rt) {
+		return func(s string) (reflect.Value, error) {
+			v := reflect.New(rt).Elem()
+			target := v.Addr()
+			if rt.Kind() == reflect.Ptr {
+				v.Set(reflect.New(rt.Elem()))
+				target = v
+			}
+			return v, target.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+		}
+	}
+	if rt == durationType {
+		return func(s string) (reflect.Value, error) {
+			v := reflect.New(rt).Elem()
+			duration, err := ParseDuration(s)
+			v.SetInt(int64(duration))
+			return v, err
+		}
+	}
+	switch rt.Kind() {
+	case reflect.String:
+		return func(s string) (reflect.Value, error) {
+			v := reflect.New(rt).Elem()
+			v.SetString(s)
+			return v, nil
+		}
+	case reflect.Bool:
+		return func(s string) (reflect.Value, error) {
+			v := reflect.New(rt).Elem()
+			b, err := strconv.ParseBool(strings.ToLower(s))
+			if err != nil {
+				return v, err
+			}
+			v.SetBool(b)
+			return v, nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return func(s string) (reflect.Value, error) {
+			v := reflect.New(rt).Elem()
+			i, err := strconv.ParseInt(s, 10, rt.Bits())
+			if err != nil {
+				return v, err
+			}
+			v.SetInt(i)
+			return v, nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return func(s string) (reflect.Value, error) {
+			v := reflect.New(rt).Elem()
+			u, err := strconv.ParseUint(s, 10, rt.Bits())
+			if err != nil {
+				return v, err
+			}
+			v.SetUint(u)
+			return v, nil
+		}
+	case reflect.Float32, reflect.Float64:
+		return func(s string) (reflect.Value, error) {
+			v := reflect.New(rt).Elem()
+			f, err := strconv.ParseFloat(s, rt.Bits())
+			if err != nil {
+				return v, err
+			}
+			v.SetFloat(f)
+			return v, nil
+		}
+	case reflect.Slice:
+		if !isScalar(rt.Elem()) {
+			break
+		}
+		parseElem := newParser(rt.Elem())
+		return func(s string) (reflect.Value, error) {
+			v := reflect.New(rt).Elem()
+			entries, err := splitList(s, false)
+			if err != nil {
+				return v, err
+			}
+			v.Set(reflect.MakeSlice(rt, 0, len(entries)))
+			for _, entry := range entries {
+				elem, err := parseElem(entry[0])
+				if err != nil {
+					return v, err
+				}
+				v.Set(reflect.Append(v, elem))
+			}
+			return v, nil
+		}
+	case reflect.Map:
+		if !isScalar(rt.Key()) || !isScalar(rt.Elem()) {
+			break
+		}
+		parseKey := newParser(rt.Key())
+		parseElem := newParser(rt.Elem())
+		return func(s string) (reflect.Value, error) {
+			v := reflect.New(rt).Elem()
+			entries, err := splitList(s, true)
+			if err != nil {
+				return v, err
+			}
+			v.Set(reflect.MakeMapWithSize(rt, len(entries)))
+			for _, entry := range entries {
+				key, err := parseKey(entry[0])
+				if err != nil {
+					return v, err
+				}
+				elem, err := parseElem(entry[1])
+				if err != nil {
+					return v, err
+				}
+				v.SetMapIndex(key, elem)
+			}
+			return v, nil
+		}
+	}
+	return func(s string) (reflect.Value, error) {
+		return reflect.New(rt).Elem(), UnsupportedTypeError{Type: rt}
+	}
+}
+
+// formatValue returns the string the value would be parsed from, and whether the value is
+// set at all, which nil pointers, slices, and maps are not. Values that parse themselves must also
+// format themselves, as encoding.TextMarshaler.
+func formatValue(v reflect.Value) (string, bool, error) {
+	rt := v.Type()
+	switch rt.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Map:
+		if v.IsNil() {
+			return "", false, nil
+		}
+	}
+	if isUnmarshaler(rt) {
+		if !rt.Implements(textMarshalerType) && v.CanAddr() {
+			v = v.Addr()
+		}
+		marshaler, ok := v.Interface().(encoding.TextMarshaler)
+		if !ok {
+			return "", false, UnsupportedTypeError{Type: rt}
+		}
+		text, err := marshaler.MarshalText()
+		return string(text), true, err
+	}
+	if rt == durationType {
+		return FormatDuration(time.Duration(v.Int())), true, nil
+	}
+	switch rt.Kind() {
+	case reflect.Ptr:
+		return formatValue(v.Elem())
+	case reflect.String:
+		return v.String(), true, nil
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), true, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), true, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), true, nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'g', -1, rt.Bits()), true, nil
+	case reflect.Slice:
+		if !isScalar(rt.Elem()) {
+			return "", false, UnsupportedTypeError{Type: rt}
+		}
+		entries := make([]string, v.Len())
+		for i := range entries {
+			entry, _, err := formatValue(v.Index(i))
+			if err != nil {
+				return "", false, err
+			}
+			entries[i] = quoteEntry(entry)
+		}
+		return strings.Join(entries, ","), true, nil
+	case reflect.Map:
+		if !isScalar(rt.Key()) || !isScalar(rt.Elem()) {
+			return "", false, UnsupportedTypeError{Type: rt}
+		}
+		pairs := make([]string, 0, v.Len())
+		for _, key := range v.MapKeys() {
+			keyVal, _, err := formatValue(key)
+			if err != nil {
+				return "", false, err
+			}
+			elemVal, _, err := formatValue(v.MapIndex(key))
+			if err != nil {
+				return "", false, err
+			}
+			pairs = append(pairs, fmt.Sprintf("%s:%s", quoteEntry(keyVal), quoteEntry(elemVal)))
+		}
+		// Pairs are sorted so that equal maps are always given the same way.
+		sort.Strings(pairs)
+		return strings.Join(pairs, ","), true, nil
+	}
+	return "", false, UnsupportedTypeError{Type: rt}
+}
+
+// isScalar returns whether values of the type can be elements of slices and maps.
+func isScalar(rt reflect.Type) bool {
+	if isUnmarshaler(rt) {
+		return true
+	}
+	switch rt.Kind() {
+	case reflect.Slice, reflect.Map, reflect.Struct, reflect.Ptr, reflect.Array, reflect.Chan, reflect.Func, reflect.Interface, reflect.UnsafePointer, reflect.Complex64, reflect.Complex128, reflect.Invalid:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,229 @@
+package modeler
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type TypedSampleModel struct {
+	SampleDuration    time.Duration      `sample:"a_duration"`
+	SampleFloat       float64            `sample:"a_float"`
+	SampleInt64       int64              `sample:"an_int64"`
+	SampleUint8       uint8              `sample:"a_uint8"`
+	SampleIntPtr      *int               `sample:"an_int_ptr"`
+	SampleBoolPtr     *bool              `sample:"a_bool_ptr"`
+	SampleIntSlice    []int              `sample:"an_int_slice"`
+	SampleFloatMap    map[string]float64 `sample:"a_float_map"`
+	SampleIP          net.IP             `sample:"an_ip"`
+	SampleLevel       SampleLevel        `sample:"a_level"`
+	SampleLevelPtr    *SampleLevel       `sample:"a_level_ptr"`
+	SampleNamedString SampleName         `sample:"a_name"`
+}
+
+type SampleName string
+
+// SampleLevel parses itself from the names of levels.
+type SampleLevel int
+
+func (l *SampleLevel) UnmarshalText(text []byte) error {
+	for i, name := range []string{"low", "medium", "high"} {
+		if strings.EqualFold(string(text), name) {
+			*l = SampleLevel(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown level %s", text)
+}
+
+func (l SampleLevel) MarshalText() ([]byte, error) {
+	return []byte([]string{"low", "medium", "high"}[l]), nil
+}
+
+type UnsupportedSampleModel struct {
+	SampleNestedSlice [][]string `sample:"a_nested_slice"`
+}
+
+func TestTypedMapping(t *testing.T) {
+	data := map[string]string{
+		prefix + "/a_duration":   "1h 30m",
+		prefix + "/a_float":      "0.75",
+		prefix + "/an_int64":     "-9000000000",
+		prefix + "/a_uint8":      "255",
+		prefix + "/an_int_ptr":   "0",
+		prefix + "/an_int_slice": "1, 2,3",
+		prefix + "/a_float_map":  "foo:0.5,bar:2",
+		prefix + "/an_ip":        "10.0.0.1",
+		prefix + "/a_level":      "High",
+		prefix + "/a_level_ptr":  "medium",
+		prefix + "/a_name":       "foo",
+	}
+	sampleModel := &TypedSampleModel{}
+	if err := m.MapToModel(data, "", sampleModel); err != nil {
+		t.Fatal(err)
+	}
+	zero := 0
+	medium := SampleLevel(1)
+	want := &TypedSampleModel{
+		SampleDuration:    90 * time.Minute,
+		SampleFloat:       0.75,
+		SampleInt64:       -9000000000,
+		SampleUint8:       255,
+		SampleIntPtr:      &zero,
+		SampleIntSlice:    []int{1, 2, 3},
+		SampleFloatMap:    map[string]float64{"foo": 0.5, "bar": 2},
+		SampleIP:          net.ParseIP("10.0.0.1"),
+		SampleLevel:       SampleLevel(2),
+		SampleLevelPtr:    &medium,
+		SampleNamedString: SampleName("foo"),
+	}
+	if !reflect.DeepEqual(want, sampleModel) {
+		t.Errorf("Expected %+v, but got %+v", want, sampleModel)
+	}
+	// Pointers tell values that weren't set apart from zero values.
+	if sampleModel.SampleBoolPtr != nil {
+		t.Errorf("Expected an unset pointer to remain nil, but got %v", *sampleModel.SampleBoolPtr)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	data := map[string]string{
+		prefix + "/a_uint8":      "256",
+		prefix + "/an_int_slice": "1,two,3",
+		prefix + "/a_level":      "extreme",
+		prefix + "/a_duration":   "5 minutes",
+		prefix + "/a_float":      "0.5",
+	}
+	sampleModel := &TypedSampleModel{SampleUint8: 1}
+	err := m.MapToModelCollectingErrors(data, "", sampleModel)
+	checkError(t, "modeler.ModelValidationErrors", err)
+	var fields []string
+	for _, validationErr := range err.(ModelValidationErrors) {
+		if validationErr.Err == nil {
+			t.Errorf("Expected the reason %s could not be parsed, but got none", validationErr.Field)
+		}
+		fields = append(fields, validationErr.Field)
+	}
+	want := []string{prefix + "/a_duration", prefix + "/a_uint8", prefix + "/an_int_slice", prefix + "/a_level"}
+	if !reflect.DeepEqual(want, fields) {
+		t.Errorf("Expected errors for %v, but got %v", want, fields)
+	}
+	// Fields whose values can't be parsed keep their previous values.
+	if sampleModel.SampleUint8 != 1 || sampleModel.SampleIntSlice != nil || sampleModel.SampleFloat != 0.5 {
+		t.Errorf("Expected only parseable values to be applied, but got %+v", sampleModel)
+	}
+
+	err = m.MapToModel(data, "", &TypedSampleModel{})
+	checkError(t, "modeler.ModelValidationError", err)
+}
+
+func TestUnsupportedType(t *testing.T) {
+	data := map[string]string{prefix + "/a_nested_slice": "foo"}
+	err := m.MapToModelCollectingErrors(data, "", &UnsupportedSampleModel{})
+	checkError(t, "modeler.UnsupportedTypeError", err)
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"30", 30 * time.Second},
+		{"500ms", 500 * time.Millisecond},
+		{"90s", 90 * time.Second},
+		{"1h30m", 90 * time.Minute},
+		{"1h 30m", 90 * time.Minute},
+		{"2d", 48 * time.Hour},
+		{"1w", 7 * 24 * time.Hour},
+		{"1M", 30 * 24 * time.Hour},
+		{"1y", 365 * 24 * time.Hour},
+	}
+	for _, test := range tests {
+		got, err := ParseDuration(test.value)
+		if err != nil {
+			t.Errorf("Using value \"%s\", received an unexpected error: %s", test.value, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("Using value \"%s\", expected %s, but got %s", test.value, test.want, got)
+		}
+	}
+	// Durations too long to be represented must not wrap around.
+	for _, value := range []string{"", "-5", "5x", "1.5h", "h", "1h-30m", "9999999999999w", "9999999999", "100000d 100000d"} {
+		if _, err := ParseDuration(value); err == nil {
+			t.Errorf("Using value \"%s\", expected an error, but did not receive any error", value)
+		}
+	}
+}
+
+func TestTypedRoundTrip(t *testing.T) {
+	zero := 0
+	medium := SampleLevel(1)
+	sampleModel := &TypedSampleModel{
+		SampleDuration:    90 * time.Minute,
+		SampleFloat:       0.1,
+		SampleInt64:       -9000000000,
+		SampleUint8:       255,
+		SampleIntPtr:      &zero,
+		SampleIntSlice:    []int{1, 2, 3},
+		SampleFloatMap:    map[string]float64{"a:b": 0.5, "c": 2},
+		SampleIP:          net.ParseIP("10.0.0.1"),
+		SampleLevel:       SampleLevel(2),
+		SampleLevelPtr:    &medium,
+		SampleNamedString: SampleName(" padded, "),
+	}
+	data, err := m.ModelToMap("", sampleModel, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		prefix + "/a_duration":   "90m",
+		prefix + "/a_float":      "0.1",
+		prefix + "/an_int64":     "-9000000000",
+		prefix + "/a_uint8":      "255",
+		prefix + "/an_int_ptr":   "0",
+		prefix + "/an_int_slice": "1,2,3",
+		prefix + "/a_float_map":  `"a:b":0.5,c:2`,
+		prefix + "/an_ip":        "10.0.0.1",
+		prefix + "/a_level":      "high",
+		prefix + "/a_level_ptr":  "medium",
+		prefix + "/a_name":       " padded, ",
+	}
+	if !reflect.DeepEqual(want, data) {
+		t.Errorf("Expected %v, but got %v", want, data)
+	}
+	parsedModel := &TypedSampleModel{}
+	if err := m.MapToModel(data, "", parsedModel); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(sampleModel, parsedModel) {
+		t.Errorf("Expected %+v, but got %+v", sampleModel, parsedModel)
+	}
+}
+
+func TestFormatDuration(t *testing.T) {
+	tests := []struct {
+		value time.Duration
+		want  string
+	}{
+		{0, "0s"},
+		{1500 * time.Millisecond, "1500ms"},
+		{30 * time.Second, "30s"},
+		{90 * time.Minute, "90m"},
+		{12 * time.Hour, "12h"},
+		{48 * time.Hour, "2d"},
+		{14 * 24 * time.Hour, "2w"},
+	}
+	for _, test := range tests {
+		got := FormatDuration(test.value)
+		if got != test.want {
+			t.Errorf("Using value %v, expected \"%s\", but got \"%s\"", test.value, test.want, got)
+		}
+		if parsed, err := ParseDuration(got); err != nil || parsed != test.value {
+			t.Errorf("Expected \"%s\" to parse as %v, but got %v (%v)", got, test.value, parsed, err)
+		}
+	}
+}